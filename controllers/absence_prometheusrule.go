@@ -19,13 +19,20 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"slices"
 	"sort"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/go-logr/logr"
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -37,20 +44,299 @@ func AbsencePrometheusRuleName(promServer string) string {
 	return fmt.Sprintf("%s%s", promServer, absencePromRuleNameSuffix)
 }
 
+// Valid values for the Aggregation field of the PrometheusRuleReconciler.
+const (
+	// AggregationPrometheusServer generates one AbsencePrometheusRule per Prometheus
+	// server in a namespace.
+	AggregationPrometheusServer = "prometheus-server"
+	// AggregationNamespace generates a single AbsencePrometheusRule per namespace for all
+	// Prometheus servers.
+	AggregationNamespace = "namespace"
+)
+
+// namespaceAbsencePromRulePrefix is used instead of the Prometheus server in the name of
+// the AbsencePrometheusRule when the absence alert rules are aggregated per namespace.
+const namespaceAbsencePromRulePrefix = "namespace"
+
+// aggregatePerNamespace reports whether the absence alert rules for all Prometheus
+// servers in a namespace are aggregated into a single AbsencePrometheusRule.
+func (r *PrometheusRuleReconciler) aggregatePerNamespace() bool {
+	return r.Aggregation == AggregationNamespace
+}
+
+// absencePrometheusRuleName returns the name of the AbsencePrometheusRule that holds the
+// absence alert rules for a specific Prometheus server.
+func (r *PrometheusRuleReconciler) absencePrometheusRuleName(promServer string) string {
+	if r.aggregatePerNamespace() {
+		promServer = namespaceAbsencePromRulePrefix
+	}
+	if r.InstanceID != "" {
+		// Different operator instances must not compete over the same resource.
+		promServer += "-" + r.InstanceID
+	}
+	return AbsencePrometheusRuleName(promServer)
+}
+
 func (r *PrometheusRuleReconciler) newAbsencePrometheusRule(namespace, promServer string) *monitoringv1.PrometheusRule {
-	return &monitoringv1.PrometheusRule{
+	absencePromRule := &monitoringv1.PrometheusRule{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      AbsencePrometheusRuleName(promServer),
+			Name:      r.absencePrometheusRuleName(promServer),
 			Namespace: namespace,
 			Labels: map[string]string{
 				// Add a label that identifies that this PrometheusRule resource is
 				// created and managed by this operator.
-				labelOperatorManagedBy: "true",
-				labelPrometheusServer:  promServer,
-				"type":                 "alerting-rules",
+				r.managedByLabel():           r.managedByValue(),
+				r.prometheusServerLabelKey(): promServer,
+				labelType:                    defaultTypeLabelValue,
 			},
 		},
 	}
+	if r.aggregatePerNamespace() {
+		// The Prometheus server is specified on the individual absence alert rules
+		// instead.
+		delete(absencePromRule.Labels, r.prometheusServerLabelKey())
+	}
+	return absencePromRule
+}
+
+// defaultTypeLabelValue is the value of the 'type' label of AbsencePrometheusRules
+// unless a TypeLabelTemplate is used.
+const defaultTypeLabelValue = "alerting-rules"
+
+// ParseTypeLabelTemplate parses a template for the value of the 'type' label of
+// AbsencePrometheusRules. The template can use the {{.Namespace}}, {{.Name}}, {{.Labels}},
+// and {{.Annotations}} of a PrometheusRule, e.g. {{index .Labels "type"}} copies its
+// 'type' label. It is rendered once so that invalid templates are detected at startup.
+func ParseTypeLabelTemplate(in string) (*template.Template, error) {
+	tmpl, err := template.New("type-label").Option("missingkey=error").Parse(in)
+	if err != nil {
+		return nil, err
+	}
+	var promRule monitoringv1.PrometheusRule
+	promRule.SetNamespace("namespace")
+	promRule.SetName("name")
+	promRule.SetLabels(map[string]string{labelType: defaultTypeLabelValue})
+	if _, err := RenderTypeLabel(tmpl, &promRule); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// RenderTypeLabel renders the value of the 'type' label of the AbsencePrometheusRule for
+// a PrometheusRule. The defaultTypeLabelValue is used if the result is empty. An error
+// is returned if the result is not a valid label value.
+func RenderTypeLabel(tmpl *template.Template, promRule *monitoringv1.PrometheusRule) (string, error) {
+	var buf strings.Builder
+	err := tmpl.Execute(&buf, struct {
+		Namespace, Name     string
+		Labels, Annotations map[string]string
+	}{promRule.GetNamespace(), promRule.GetName(), promRule.GetLabels(), promRule.GetAnnotations()})
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(buf.String())
+	if v == "" {
+		return defaultTypeLabelValue, nil
+	}
+	if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+		return "", fmt.Errorf("invalid value %q for the %q label: %s", v, labelType, strings.Join(errs, ", "))
+	}
+	return v, nil
+}
+
+// updateTypeLabel sets the 'type' label of an AbsencePrometheusRule using the
+// TypeLabelTemplate, if any. The label is left as is otherwise.
+func (r *PrometheusRuleReconciler) updateTypeLabel(absencePromRule, promRule *monitoringv1.PrometheusRule) error {
+	if r.TypeLabelTemplate == nil {
+		return nil
+	}
+	v, err := RenderTypeLabel(r.TypeLabelTemplate, promRule)
+	if err != nil {
+		return err
+	}
+	if absencePromRule.Labels == nil {
+		absencePromRule.Labels = make(map[string]string)
+	}
+	absencePromRule.Labels[labelType] = v
+	return nil
+}
+
+// prometheusServerLabelKey returns the key of the label that specifies the Prometheus
+// server.
+func (r *PrometheusRuleReconciler) prometheusServerLabelKey() string {
+	if r.PrometheusServerLabelKey != "" {
+		return r.PrometheusServerLabelKey
+	}
+	return DefaultPrometheusServerLabelKey
+}
+
+// managedByLabel returns the key of the label that identifies AbsencePrometheusRules.
+func (r *PrometheusRuleReconciler) managedByLabel() string {
+	if r.ManagedByLabel != "" {
+		return r.ManagedByLabel
+	}
+	return labelOperatorManagedBy
+}
+
+// annotationKey returns the key of an annotation that is managed by the operator using
+// the configured AnnotationPrefix.
+func (r *PrometheusRuleReconciler) annotationKey(name string) string {
+	return annotationKey(r.AnnotationPrefix, name)
+}
+
+// managedByValue returns the value of the managed-by label of the AbsencePrometheusRules
+// that are managed by this operator instance.
+func (r *PrometheusRuleReconciler) managedByValue() string {
+	if r.InstanceID != "" {
+		return r.InstanceID
+	}
+	return "true"
+}
+
+// isManaged reports whether a PrometheusRule is an AbsencePrometheusRule that is managed
+// by this operator instance.
+func (r *PrometheusRuleReconciler) isManaged(promRule *monitoringv1.PrometheusRule) bool {
+	v := promRule.GetLabels()[r.managedByLabel()]
+	if r.InstanceID != "" {
+		return v == r.InstanceID
+	}
+	return parseBool(v)
+}
+
+// isManagedByOtherInstance reports whether a PrometheusRule is an AbsencePrometheusRule
+// that is managed by an operator instance with a different instance ID.
+func (r *PrometheusRuleReconciler) isManagedByOtherInstance(promRule *monitoringv1.PrometheusRule) bool {
+	v, ok := promRule.GetLabels()[r.managedByLabel()]
+	return r.InstanceID != "" && ok && v != r.InstanceID
+}
+
+// isUnadopted reports whether a PrometheusRule is an AbsencePrometheusRule that is
+// managed by this operator instance but was neither created nor adopted by this version
+// of the operator, i.e. it does not have the 'owned' annotation, while DisableAdoption
+// is set. Such resources are left alone.
+func (r *PrometheusRuleReconciler) isUnadopted(promRule *monitoringv1.PrometheusRule) bool {
+	if !r.DisableAdoption || !r.isManaged(promRule) {
+		return false
+	}
+	return !parseBool(promRule.GetAnnotations()[r.annotationKey(annotationOperatorOwned)])
+}
+
+// markOwned adds the 'owned' annotation to an AbsencePrometheusRule which marks it as
+// created or adopted by this version of the operator.
+func (r *PrometheusRuleReconciler) markOwned(absencePromRule *monitoringv1.PrometheusRule) {
+	if absencePromRule.Annotations == nil {
+		absencePromRule.Annotations = make(map[string]string)
+	}
+	absencePromRule.Annotations[r.annotationKey(annotationOperatorOwned)] = "true"
+}
+
+// managedBySelector returns the label selector for listing the AbsencePrometheusRules
+// that are managed by this operator instance. It selects on the value of the managed-by
+// label so that the AbsencePrometheusRules of other instances that use the same label
+// are not listed.
+func (r *PrometheusRuleReconciler) managedBySelector() client.ListOption {
+	return client.MatchingLabels{r.managedByLabel(): r.managedByValue()}
+}
+
+// fieldOwner returns the field manager that is used for write requests. An empty field
+// manager means that the default of the client is used.
+func (r *PrometheusRuleReconciler) fieldOwner() client.FieldOwner {
+	if r.InstanceID != "" {
+		return client.FieldOwner("absent-metrics-operator-" + r.InstanceID)
+	}
+	return ""
+}
+
+// copiedAnnotations returns the annotations of a PrometheusRule that are copied to its
+// absence alert rules.
+func (r *PrometheusRuleReconciler) copiedAnnotations(promRule *monitoringv1.PrometheusRule) map[string]string {
+	var result map[string]string
+	for k, v := range promRule.GetAnnotations() {
+		if !r.CopyAnnotations[k] {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// prometheusServer returns the name of the Prometheus server that a PrometheusRule
+// concerns. If the resource does not have a 'prometheus' label then the
+// FallbackPrometheusServer is returned, which can also be empty.
+func (r *PrometheusRuleReconciler) prometheusServer(promRule *monitoringv1.PrometheusRule) string {
+	if s := promRule.GetLabels()[r.prometheusServerLabelKey()]; s != "" {
+		return s
+	}
+	return r.FallbackPrometheusServer
+}
+
+// prometheusServers returns the names of the Prometheus servers that a PrometheusRule
+// concerns. This is the Prometheus server (see prometheusServer()) or, if a
+// PrometheusServerDelimiter is configured, the list of Prometheus servers that it
+// separates. Empty names and duplicates are skipped.
+func (r *PrometheusRuleReconciler) prometheusServers(promRule *monitoringv1.PrometheusRule) []string {
+	promServer := r.prometheusServer(promRule)
+	if promServer == "" {
+		return nil
+	}
+	if r.PrometheusServerDelimiter == "" {
+		return []string{promServer}
+	}
+
+	var result []string
+	for _, s := range strings.Split(promServer, r.PrometheusServerDelimiter) {
+		s = strings.TrimSpace(s)
+		if s != "" && !slices.Contains(result, s) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// listAllPrometheusRules returns all the PrometheusRules in a namespace.
+func (r *PrometheusRuleReconciler) listAllPrometheusRules(ctx context.Context, namespace string) ([]*monitoringv1.PrometheusRule, error) {
+	var promRules monitoringv1.PrometheusRuleList
+	if err := r.List(ctx, &promRules, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	return promRules.Items, nil
+}
+
+// listPrometheusRules returns all the PrometheusRules in a namespace that concern a
+// specific Prometheus server.
+func (r *PrometheusRuleReconciler) listPrometheusRules(
+	ctx context.Context,
+	namespace, promServer string,
+) ([]*monitoringv1.PrometheusRule, error) {
+
+	// Resources that use the fallback Prometheus server do not have a 'prometheus' label
+	// and the label of resources for multiple Prometheus servers does not have the name
+	// of the server as its value, therefore we can't use a label selector for them.
+	useSelector := promServer != r.FallbackPrometheusServer && r.PrometheusServerDelimiter == ""
+
+	var listOpts client.ListOptions
+	client.InNamespace(namespace).ApplyToList(&listOpts)
+	if useSelector {
+		client.MatchingLabels{r.prometheusServerLabelKey(): promServer}.ApplyToList(&listOpts)
+	}
+	var promRules monitoringv1.PrometheusRuleList
+	if err := r.List(ctx, &promRules, &listOpts); err != nil {
+		return nil, err
+	}
+	if useSelector {
+		return promRules.Items, nil
+	}
+
+	result := make([]*monitoringv1.PrometheusRule, 0, len(promRules.Items))
+	for _, pr := range promRules.Items {
+		if slices.Contains(r.prometheusServers(pr), promServer) {
+			result = append(result, pr)
+		}
+	}
+	return result, nil
 }
 
 func (r *PrometheusRuleReconciler) getExistingAbsencePrometheusRule(
@@ -58,22 +344,44 @@ func (r *PrometheusRuleReconciler) getExistingAbsencePrometheusRule(
 	namespace, promServer string,
 ) (*monitoringv1.PrometheusRule, error) {
 
-	var absencePromRule monitoringv1.PrometheusRule
-	nsName := types.NamespacedName{Namespace: namespace, Name: AbsencePrometheusRuleName(promServer)}
-	if err := r.Get(ctx, nsName, &absencePromRule); err != nil {
+	nsName := types.NamespacedName{Namespace: namespace, Name: r.absencePrometheusRuleName(promServer)}
+	absencePromRule, err := r.getAbsencePrometheusRule(ctx, nsName)
+	if err != nil {
 		return nil, err
 	}
-	return &absencePromRule, nil
+	kind := monitoringv1.PrometheusRuleKind
+	if r.outputConfigMap() {
+		kind = "ConfigMap"
+	}
+	// Do not touch resources that are managed by a different operator instance or that
+	// were not created by the operator at all.
+	if !r.isManaged(absencePromRule) {
+		return nil, fmt.Errorf("%s %s already exists but does not have the %s=%s label",
+			kind, nsName, r.managedByLabel(), r.managedByValue())
+	}
+	if r.isUnadopted(absencePromRule) {
+		return nil, fmt.Errorf("%s %s was not created by this version of the operator "+
+			"and adoption of existing resources is disabled", kind, nsName)
+	}
+	return absencePromRule, nil
 }
 
-func sortRuleGroups(absencePromRule *monitoringv1.PrometheusRule) {
-	// Sort rule groups for consistent test results.
+// sortRuleGroups sorts the rule groups of an AbsencePrometheusRule by name for consistent
+// results. Their rules are sorted as well unless the RuleOrder of the ParseOpts keeps
+// them in the order of the original rule groups.
+func (r *PrometheusRuleReconciler) sortRuleGroups(absencePromRule *monitoringv1.PrometheusRule) {
 	sort.SliceStable(absencePromRule.Spec.Groups, func(i, j int) bool {
 		return absencePromRule.Spec.Groups[i].Name < absencePromRule.Spec.Groups[j].Name
 	})
+	if r.ParseOpts.RuleOrder == RuleOrderSource {
+		return
+	}
+	for _, g := range absencePromRule.Spec.Groups {
+		sortRules(g.Rules)
+	}
 }
 
-func updateAnnotationTime(absencePromRule *monitoringv1.PrometheusRule) {
+func (r *PrometheusRuleReconciler) updateAnnotationTime(absencePromRule *monitoringv1.PrometheusRule) {
 	now := time.Now()
 	if IsTest {
 		now = time.Unix(1, 0)
@@ -81,13 +389,21 @@ func updateAnnotationTime(absencePromRule *monitoringv1.PrometheusRule) {
 	if absencePromRule.Annotations == nil {
 		absencePromRule.Annotations = make(map[string]string)
 	}
-	absencePromRule.Annotations[annotationOperatorUpdatedAt] = now.UTC().Format(time.RFC3339)
+	absencePromRule.Annotations[r.annotationKey(annotationOperatorUpdatedAt)] = now.UTC().Format(time.RFC3339)
 }
 
 func (r *PrometheusRuleReconciler) createAbsencePrometheusRule(ctx context.Context, absencePromRule *monitoringv1.PrometheusRule) error {
-	sortRuleGroups(absencePromRule)
-	updateAnnotationTime(absencePromRule)
-	if err := r.Create(ctx, absencePromRule); err != nil {
+	r.sortRuleGroups(absencePromRule)
+	r.markOwned(absencePromRule)
+	r.updateAnnotationTime(absencePromRule)
+	obj, err := r.outputObject(r.withWatchdogRuleGroup(r.withMissingMetricsRuleGroup(absencePromRule)))
+	if err != nil {
+		return err
+	}
+	timer := prometheus.NewTimer(apiServerWriteDuration.WithLabelValues(writeVerbCreate))
+	err = r.Create(ctx, obj, r.fieldOwner())
+	timer.ObserveDuration()
+	if err != nil {
 		return err
 	}
 
@@ -102,9 +418,23 @@ func (r *PrometheusRuleReconciler) patchAbsencePrometheusRule(
 	unmodifiedAbsencePromRule *monitoringv1.PrometheusRule,
 ) error {
 
-	sortRuleGroups(absencePromRule)
-	updateAnnotationTime(absencePromRule)
-	if err := r.Patch(ctx, absencePromRule, client.MergeFrom(unmodifiedAbsencePromRule)); err != nil {
+	r.sortRuleGroups(absencePromRule)
+	r.markOwned(absencePromRule)
+	r.updateAnnotationTime(absencePromRule)
+	// The watchdog and missing metrics rule groups are removed when an
+	// AbsencePrometheusRule is read, therefore they are restored for the base of the patch.
+	obj, err := r.outputObject(r.withWatchdogRuleGroup(r.withMissingMetricsRuleGroup(absencePromRule)))
+	if err != nil {
+		return err
+	}
+	unmodifiedObj, err := r.outputObject(r.withStoredWatchdogRuleGroup(r.withStoredMissingMetricsRuleGroup(unmodifiedAbsencePromRule)))
+	if err != nil {
+		return err
+	}
+	timer := prometheus.NewTimer(apiServerWriteDuration.WithLabelValues(writeVerbPatch))
+	err = r.Patch(ctx, obj, client.MergeFrom(unmodifiedObj), r.fieldOwner())
+	timer.ObserveDuration()
+	if err != nil {
 		return err
 	}
 
@@ -114,15 +444,38 @@ func (r *PrometheusRuleReconciler) patchAbsencePrometheusRule(
 }
 
 func (r *PrometheusRuleReconciler) deleteAbsencePrometheusRule(ctx context.Context, absencePromRule *monitoringv1.PrometheusRule) error {
-	if err := r.Delete(ctx, absencePromRule); err != nil {
+	obj, err := r.outputObject(absencePromRule)
+	if err != nil {
+		return err
+	}
+	timer := prometheus.NewTimer(apiServerWriteDuration.WithLabelValues(writeVerbDelete))
+	err = r.Delete(ctx, obj)
+	timer.ObserveDuration()
+	if err != nil {
 		return err
 	}
 
+	deleted := countAlertRules(absencePromRule.Spec.Groups)
+	absenceRulesDeleted.WithLabelValues(absencePromRule.GetNamespace()).Add(float64(deleted))
 	r.Log.V(logLevelDebug).Info("successfully deleted AbsencePrometheusRule",
 		"AbsencePrometheusRule", fmt.Sprintf("%s/%s", absencePromRule.GetNamespace(), absencePromRule.GetName()))
 	return nil
 }
 
+// countAlertRules returns the number of alert rules in the given rule groups, e.g. the
+// absence alert rules in AbsenceRuleGroups.
+func countAlertRules(ruleGroups []monitoringv1.RuleGroup) int {
+	count := 0
+	for _, g := range ruleGroups {
+		for _, rule := range g.Rules {
+			if rule.Alert != "" {
+				count++
+			}
+		}
+	}
+	return count
+}
+
 var errCorrespondingAbsencePromRuleNotExists = errors.New("corresponding AbsencePrometheusRule for clean up does not exist")
 
 // cleanUpOrphanedAbsenceAlertRules deletes the absence alert rules for a PrometheusRule
@@ -136,63 +489,79 @@ func (r *PrometheusRuleReconciler) cleanUpOrphanedAbsenceAlertRules(
 	promServer string,
 ) error {
 
-	// Step 1: find the corresponding AbsencePrometheusRule that needs to be cleaned up.
-	var aPRToClean *monitoringv1.PrometheusRule
+	// Step 1: find the corresponding AbsencePrometheusRules that need to be cleaned up.
+	// There can be more than one if the AbsencePrometheusRule was split into parts.
+	var aPRsToClean []*monitoringv1.PrometheusRule
 	if promServer != "" {
-		var err error
-		if aPRToClean, err = r.getExistingAbsencePrometheusRule(ctx, promRule.Namespace, promServer); err != nil {
+		aPR, err := r.getExistingAbsencePrometheusRule(ctx, promRule.Namespace, promServer)
+		switch {
+		case err == nil:
+			aPRsToClean = append(aPRsToClean, aPR)
+		case apierrors.IsNotFound(err) && r.splitAbsencePrometheusRules():
+			// The parts could still exist.
+		default:
+			return err
+		}
+		parts, err := r.listAbsencePrometheusRuleParts(ctx, promRule.Namespace, r.absencePrometheusRuleName(promServer))
+		if err != nil {
 			return err
 		}
+		aPRsToClean = append(aPRsToClean, parts...)
 	} else {
 		// Since we don't know the Prometheus server for this PrometheusRule therefore we
 		// have to list all AbsencePrometheusRules in its namespace and find the specific
-		// AbsencePrometheusRule that contains the absence alert rules that were generated
+		// AbsencePrometheusRules that contain the absence alert rules that were generated
 		// for this PrometheusRule.
-		var listOpts client.ListOptions
-		client.InNamespace(promRule.Namespace).ApplyToList(&listOpts)
-		client.HasLabels{labelOperatorManagedBy}.ApplyToList(&listOpts)
-		var absencePromRules monitoringv1.PrometheusRuleList
-		if err := r.List(ctx, &absencePromRules, &listOpts); err != nil {
+		absencePromRules, err := r.listAbsencePrometheusRules(ctx, client.InNamespace(promRule.Namespace), r.managedBySelector())
+		if err != nil {
 			return err
 		}
 
-		for _, aPR := range absencePromRules.Items {
-			for _, g := range aPR.Spec.Groups {
-				n := promRulefromAbsenceRuleGroupName(g.Name)
-				if n != "" && n == promRule.Name {
-					aPRToClean = aPR
-					break
-				}
+		for _, aPR := range absencePromRules {
+			if !r.isUnadopted(aPR) && r.hasAbsenceRuleGroups(aPR, promRule.Name) {
+				aPRsToClean = append(aPRsToClean, aPR)
 			}
 		}
 	}
-	if aPRToClean == nil {
+	if len(aPRsToClean) == 0 {
 		return errCorrespondingAbsencePromRuleNotExists
 	}
 
-	// Step 2: iterate through the AbsenceRuleGroups, skip those that were generated for
-	// this PrometheusRule and keep the rest as is.
-	oldRuleGroups := aPRToClean.Spec.Groups
-	newRuleGroups := make([]monitoringv1.RuleGroup, 0, len(oldRuleGroups))
-	for _, g := range oldRuleGroups {
-		n := promRulefromAbsenceRuleGroupName(g.Name)
-		if n != "" && n == promRule.Name {
+	for _, aPR := range aPRsToClean {
+		// Step 2: iterate through the AbsenceRuleGroups, skip those that were generated for
+		// this PrometheusRule and keep the rest as is.
+		oldRuleGroups := aPR.Spec.Groups
+		sources := r.ruleGroupSources(aPR)
+		newRuleGroups := make([]monitoringv1.RuleGroup, 0, len(oldRuleGroups))
+		for _, g := range oldRuleGroups {
+			n := promRuleFromAbsenceRuleGroup(sources, g.Name)
+			if n != "" && n == promRule.Name {
+				continue
+			}
+			newRuleGroups = append(newRuleGroups, g)
+		}
+		if len(oldRuleGroups) == len(newRuleGroups) {
 			continue
 		}
-		newRuleGroups = append(newRuleGroups, g)
-	}
-	if reflect.DeepEqual(oldRuleGroups, newRuleGroups) {
-		return nil
+
+		// Step 3: update the AbsencePrometheusRule.
+		if err := r.updateCleanedUpAbsencePrometheusRule(ctx, aPR, newRuleGroups); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Step 3: if, after the cleanup, the AbsencePrometheusRule ends up being empty then
-	// delete it otherwise update.
-	if len(newRuleGroups) == 0 {
-		return r.deleteAbsencePrometheusRule(ctx, aPRToClean)
+// hasAbsenceRuleGroups reports whether an AbsencePrometheusRule contains absence rule
+// groups that were generated for a specific PrometheusRule.
+func (r *PrometheusRuleReconciler) hasAbsenceRuleGroups(absencePromRule *monitoringv1.PrometheusRule, promRuleName string) bool {
+	sources := r.ruleGroupSources(absencePromRule)
+	for _, g := range absencePromRule.Spec.Groups {
+		if promRuleFromAbsenceRuleGroup(sources, g.Name) == promRuleName {
+			return true
+		}
 	}
-	unmodified := aPRToClean.DeepCopy()
-	aPRToClean.Spec.Groups = newRuleGroups
-	return r.patchAbsencePrometheusRule(ctx, aPRToClean, unmodified)
+	return false
 }
 
 // cleanUpAbsencePrometheusRule checks an AbsencePrometheusRule to see if it contains
@@ -202,58 +571,101 @@ func (r *PrometheusRuleReconciler) cleanUpOrphanedAbsenceAlertRules(
 func (r *PrometheusRuleReconciler) cleanUpAbsencePrometheusRule(ctx context.Context, absencePromRule *monitoringv1.PrometheusRule) error {
 	// Step 1: get names of all PrometheusRule resources in this namespace for the
 	// concerning Prometheus server.
-	var listOpts client.ListOptions
-	client.InNamespace(absencePromRule.GetNamespace()).ApplyToList(&listOpts)
-	client.MatchingLabels{
-		labelPrometheusServer: absencePromRule.Labels[labelPrometheusServer],
-	}.ApplyToList(&listOpts)
-	var promRules monitoringv1.PrometheusRuleList
-	if err := r.List(ctx, &promRules, &listOpts); err != nil {
+	var promRules []*monitoringv1.PrometheusRule
+	var err error
+	if r.aggregatePerNamespace() {
+		promRules, err = r.listAllPrometheusRules(ctx, absencePromRule.GetNamespace())
+	} else {
+		promRules, err = r.listPrometheusRules(ctx,
+			absencePromRule.GetNamespace(), absencePromRule.Labels[r.prometheusServerLabelKey()])
+	}
+	if err != nil {
 		return err
 	}
 	prNames := make(map[string]bool)
-	for _, pr := range promRules.Items {
+	for _, pr := range promRules {
 		prNames[pr.GetName()] = true
 	}
 
 	// Step 2: iterate through all the AbsencePrometheusRule's RuleGroups and remove those
 	// that don't belong to any PrometheusRule.
+	sources := r.ruleGroupSources(absencePromRule)
 	newRuleGroups := make([]monitoringv1.RuleGroup, 0, len(absencePromRule.Spec.Groups))
 	for _, g := range absencePromRule.Spec.Groups {
-		n := promRulefromAbsenceRuleGroupName(g.Name)
+		n := promRuleFromAbsenceRuleGroup(sources, g.Name)
 		if !prNames[n] {
 			continue
 		}
 		newRuleGroups = append(newRuleGroups, g)
 	}
-	if reflect.DeepEqual(absencePromRule.Spec.Groups, newRuleGroups) {
+	if len(absencePromRule.Spec.Groups) == len(newRuleGroups) {
 		return nil
 	}
 
-	// Step 3: if, after the cleanup, the AbsencePrometheusRule ends up being empty then
-	// delete it otherwise update.
-	if len(newRuleGroups) == 0 {
+	// Step 3: update the AbsencePrometheusRule.
+	return r.updateCleanedUpAbsencePrometheusRule(ctx, absencePromRule, newRuleGroups)
+}
+
+// updateCleanedUpAbsencePrometheusRule updates an AbsencePrometheusRule with the rule
+// groups that remain after a clean up. If the AbsencePrometheusRule ends up being empty
+// then it is deleted unless KeepEmptyAbsencePrometheusRules is set.
+func (r *PrometheusRuleReconciler) updateCleanedUpAbsencePrometheusRule(
+	ctx context.Context,
+	absencePromRule *monitoringv1.PrometheusRule,
+	ruleGroups []monitoringv1.RuleGroup,
+) error {
+
+	if len(ruleGroups) == 0 && !r.KeepEmptyAbsencePrometheusRules {
 		return r.deleteAbsencePrometheusRule(ctx, absencePromRule)
 	}
 	unmodified := absencePromRule.DeepCopy()
-	absencePromRule.Spec.Groups = newRuleGroups
-	return r.patchAbsencePrometheusRule(ctx, absencePromRule, unmodified)
+	absencePromRule.Spec.Groups = ruleGroups
+	if err := r.pruneRuleGroupSources(absencePromRule); err != nil {
+		return err
+	}
+	if err := r.pruneRuleGroupHashes(absencePromRule); err != nil {
+		return err
+	}
+	if err := r.patchAbsencePrometheusRule(ctx, absencePromRule, unmodified); err != nil {
+		return err
+	}
+	deleted := countAlertRules(unmodified.Spec.Groups) - countAlertRules(ruleGroups)
+	absenceRulesDeleted.WithLabelValues(absencePromRule.GetNamespace()).Add(float64(deleted))
+	return nil
 }
 
 // updateAbsenceAlertRules generates absence alert rules for the given PrometheusRule and
-// adds them to the corresponding AbsencePrometheusRule.
+// adds them to the corresponding AbsencePrometheusRule of each of its Prometheus servers.
 func (r *PrometheusRuleReconciler) updateAbsenceAlertRules(ctx context.Context, promRule *monitoringv1.PrometheusRule) error {
-	promRuleName := promRule.GetName()
-	namespace := promRule.GetNamespace()
-	log := r.Log.WithValues("name", promRuleName, "namespace", namespace)
-
-	// Step 1: find the Prometheus server for this resource.
-	promRuleLabels := promRule.GetLabels()
-	promServer, ok := promRuleLabels["prometheus"]
-	if !ok {
+	// Step 1: find the Prometheus servers for this resource.
+	promServers := r.prometheusServers(promRule)
+	if len(promServers) == 0 {
 		// Normally this shouldn't happen but just in case that it does.
-		return errors.New("no 'prometheus' label found")
+		return fmt.Errorf("no '%s' label found", r.prometheusServerLabelKey())
+	}
+	// Counted once here rather than while parsing since the alert rules are parsed for
+	// each Prometheus server.
+	rulesProcessed.WithLabelValues(promRule.GetNamespace()).Add(float64(countAlertRules(promRule.Spec.Groups)))
+	for _, promServer := range promServers {
+		if err := r.updateAbsenceAlertRulesOfServer(ctx, promRule, promServer); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// updateAbsenceAlertRulesOfServer generates absence alert rules for the given
+// PrometheusRule and adds them to the AbsencePrometheusRule of a specific Prometheus
+// server.
+func (r *PrometheusRuleReconciler) updateAbsenceAlertRulesOfServer(
+	ctx context.Context,
+	promRule *monitoringv1.PrometheusRule,
+	promServer string,
+) error {
+
+	promRuleName := promRule.GetName()
+	namespace := promRule.GetNamespace()
+	log := r.Log.WithValues("name", promRuleName, "namespace", namespace, "prometheusServer", promServer)
 
 	// Step 2: get the corresponding AbsencePrometheusRule if it exists. We do this in
 	// advance so that we can get suitable defaults for tier and service labels in the
@@ -271,7 +683,31 @@ func (r *PrometheusRuleReconciler) updateAbsenceAlertRules(ctx context.Context,
 		return err
 	}
 
+	// If the AbsencePrometheusRule was split into parts then we update all of them as a
+	// whole and split it again in the last step.
+	var existingSplitAbsencePromRules []*monitoringv1.PrometheusRule
+	if r.splitAbsencePrometheusRules() {
+		parts, err := r.listAbsencePrometheusRuleParts(ctx, namespace, absencePromRule.GetName())
+		if err != nil {
+			return err
+		}
+		if existingAbsencePrometheusRule {
+			existingSplitAbsencePromRules = append(existingSplitAbsencePromRules, absencePromRule.DeepCopy())
+		}
+		existingSplitAbsencePromRules = append(existingSplitAbsencePromRules, parts...)
+		if len(parts) > 0 {
+			existingAbsencePrometheusRule = true
+			if err := r.combineAbsencePrometheusRuleParts(absencePromRule, parts); err != nil {
+				return err
+			}
+		}
+	}
+
 	unmodifiedAbsencePromRule := absencePromRule.DeepCopy()
+	r.markOwned(absencePromRule)
+	if err := r.updateTypeLabel(absencePromRule, promRule); err != nil {
+		return err
+	}
 
 	// Step 3: get defaults for support group, tier and service labels and add them to the
 	// AbsencePrometheusRule.
@@ -281,24 +717,33 @@ func (r *PrometheusRuleReconciler) updateAbsenceAlertRules(ctx context.Context,
 	labelOpts := LabelOpts{Keep: r.KeepLabel}
 	if keepCCloudLabels(labelOpts.Keep) {
 		var err error
-		labelOpts, err = r.labelOptsWithCCloudDefaults(ctx, promRule)
+		labelOpts, err = r.labelOptsWithCCloudDefaults(ctx, promRule, true)
 		if err != nil {
 			return err
 		}
-
-		// Update the labels on AbsencePrometheusRule object in case they might've changed
-		// or delete them in case they no longer exist and defaults could not be
-		// determined.
-		// New CCloud format:
-		updateLabel(absencePromRule.Labels, LabelCCloudSupportGroup, labelOpts.DefaultSupportGroup)
-		updateLabel(absencePromRule.Labels, LabelCCloudService, labelOpts.DefaultService)
-		// Old CCloud format:
-		updateLabel(absencePromRule.Labels, LabelTier, labelOpts.DefaultTier)
-		updateLabel(absencePromRule.Labels, LabelService, labelOpts.DefaultService)
+		if r.RetainExistingLabels && existingAbsencePrometheusRule {
+			labelOpts = r.existingLabelOpts(labelOpts, unmodifiedAbsencePromRule)
+		}
+		updateCCloudLabels(absencePromRule, labelOpts)
+	} else if r.ForceRelabel {
+		// Remove the labels that were carried over while they were still kept.
+		updateCCloudLabels(absencePromRule, LabelOpts{})
 	}
 
 	// Step 4: parse RuleGroups and generate corresponding absence alert rules.
-	absenceRuleGroups, err := ParseRuleGroups(log, promRule.Spec.Groups, promRuleName, labelOpts)
+	nsSeverity, err := r.namespaceSeverity(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	optionalMetrics, err := r.optionalMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	handWritten, err := r.handWrittenAbsence(ctx, namespace, promServer)
+	if err != nil {
+		return err
+	}
+	absenceRuleGroups, err := r.parseRuleGroups(log, promRule, promServer, labelOpts, nsSeverity, optionalMetrics, handWritten)
 	if err != nil {
 		return err
 	}
@@ -309,12 +754,34 @@ func (r *PrometheusRuleReconciler) updateAbsenceAlertRules(ctx context.Context,
 	// alerts. E.g. absent() or the 'no_alert_on_absence' label was used.
 	if len(absenceRuleGroups) == 0 {
 		if existingAbsencePrometheusRule {
+			covered := r.hasAbsenceRuleGroups(absencePromRule, promRuleName)
 			key := types.NamespacedName{Namespace: namespace, Name: promRuleName}
-			return r.cleanUpOrphanedAbsenceAlertRules(ctx, key, promServer)
+			if covered {
+				// Guard against transient empty results, e.g. due to a broken expression
+				// that is fixed shortly after.
+				count := r.emptyResults.inc(key, promServer)
+				if count < r.EmptyResultThreshold {
+					log.Info("PrometheusRule has no absence alert rules, keeping the existing ones for now",
+						"count", count, "threshold", r.EmptyResultThreshold)
+					return nil
+				}
+			}
+			r.emptyResults.reset(key, promServer)
+			if err := r.cleanUpOrphanedAbsenceAlertRules(ctx, key, promServer); err != nil {
+				return err
+			}
+			if covered {
+				// Make it visible when absence alert rules silently stop being generated
+				// for a PrometheusRule.
+				log.Info("PrometheusRule no longer has any absence alert rules")
+				coverageDropped.WithLabelValues(namespace, promRuleName).Inc()
+			}
 		}
 		return nil
 	}
 
+	r.emptyResults.reset(types.NamespacedName{Namespace: namespace, Name: promRuleName}, promServer)
+
 	// Step 6. log in case we couldn't find defaults for tier and service. We log after
 	// Step 4 and 5 to avoid unnecessary logging in case the aforementioned steps result
 	// in no change.
@@ -333,21 +800,142 @@ func (r *PrometheusRuleReconciler) updateAbsenceAlertRules(ctx context.Context,
 	// Step 7: if it's an existing AbsencePrometheusRule then update otherwise create a new resource.
 	if existingAbsencePrometheusRule {
 		existingRuleGroups := absencePromRule.Spec.Groups
-		result := mergeAbsenceRuleGroups(existingRuleGroups, absenceRuleGroups)
+		result := r.handleDuplicateAlertNames(log, mergeAbsenceRuleGroups(existingRuleGroups, absenceRuleGroups))
+		hashes, err := r.storedRuleGroupHashes(result, absenceRuleGroups)
+		if err != nil {
+			return err
+		}
+		unchanged, err := hasRuleGroupHashes(existingRuleGroups, hashes)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			// The existing rule groups are kept as is, see HashRuleGroups.
+			result = existingRuleGroups
+		}
+		absencePromRule.Spec.Groups = result
+		if err := r.updateRuleGroupSources(absencePromRule, promRule, absenceRuleGroups); err != nil {
+			return err
+		}
+		if err := r.updateRuleGroupHashes(absencePromRule, hashes); err != nil {
+			return err
+		}
+		r.updateWatchdogAnnotation(absencePromRule)
+		r.updateMissingMetricsAnnotation(absencePromRule)
+		if r.splitAbsencePrometheusRules() {
+			return r.writeSplitAbsencePrometheusRule(ctx, absencePromRule, existingSplitAbsencePromRules)
+		}
 		if reflect.DeepEqual(getCCloudLabels(unmodifiedAbsencePromRule), getCCloudLabels(absencePromRule)) &&
-			reflect.DeepEqual(existingRuleGroups, result) {
+			unmodifiedAbsencePromRule.Labels[labelType] == absencePromRule.Labels[labelType] &&
+			(unchanged || reflect.DeepEqual(existingRuleGroups, result)) &&
+			reflect.DeepEqual(unmodifiedAbsencePromRule.Annotations, absencePromRule.Annotations) {
 			return nil
 		}
-		absencePromRule.Spec.Groups = result
 		return r.patchAbsencePrometheusRule(ctx, absencePromRule, unmodifiedAbsencePromRule)
 	}
-	absencePromRule.Spec.Groups = absenceRuleGroups
+	absencePromRule.Spec.Groups = r.handleDuplicateAlertNames(log, absenceRuleGroups)
+	if err := r.updateRuleGroupSources(absencePromRule, promRule, absenceRuleGroups); err != nil {
+		return err
+	}
+	hashes, err := r.storedRuleGroupHashes(absencePromRule.Spec.Groups, absenceRuleGroups)
+	if err != nil {
+		return err
+	}
+	if err := r.updateRuleGroupHashes(absencePromRule, hashes); err != nil {
+		return err
+	}
+	if r.splitAbsencePrometheusRules() {
+		return r.writeSplitAbsencePrometheusRule(ctx, absencePromRule, nil)
+	}
 	return r.createAbsencePrometheusRule(ctx, absencePromRule)
 }
 
+// updateCCloudLabels updates the labels on an AbsencePrometheusRule object in case they
+// might've changed or deletes them in case they no longer exist and defaults could not be
+// determined.
+func updateCCloudLabels(absencePromRule *monitoringv1.PrometheusRule, labelOpts LabelOpts) {
+	// New CCloud format:
+	updateLabel(absencePromRule.Labels, LabelCCloudSupportGroup, labelOpts.DefaultSupportGroup)
+	updateLabel(absencePromRule.Labels, LabelCCloudService, labelOpts.DefaultService)
+	// Old CCloud format:
+	updateLabel(absencePromRule.Labels, LabelTier, labelOpts.DefaultTier)
+	updateLabel(absencePromRule.Labels, LabelService, labelOpts.DefaultService)
+}
+
+// parseRuleGroups generates the absence rule groups for a PrometheusRule using the
+// ParseOpts of the reconciler. The nsSeverity overrides the default severity if it is
+// not empty and the optionalMetrics do not get absence alert rules. Neither do the time
+// series in handWritten (see ParseOpts.HandWrittenAbsence).
+func (r *PrometheusRuleReconciler) parseRuleGroups(
+	log logr.Logger,
+	promRule *monitoringv1.PrometheusRule,
+	promServer string,
+	labelOpts LabelOpts,
+	nsSeverity string,
+	optionalMetrics map[string]bool,
+	handWritten map[string]bool,
+) ([]monitoringv1.RuleGroup, error) {
+
+	parseOpts := r.ParseOpts
+	parseOpts.LabelOpts = labelOpts
+	parseOpts.Namespace = promRule.GetNamespace()
+	if nsSeverity != "" {
+		parseOpts.DefaultSeverity = nsSeverity
+	}
+	if len(optionalMetrics) > 0 {
+		parseOpts.OptionalMetrics = optionalMetrics
+	}
+	if len(handWritten) > 0 {
+		parseOpts.HandWrittenAbsence = handWritten
+	}
+	if prefix := promRule.GetLabels()[labelAlertNamePrefix]; prefix != "" {
+		parseOpts.AlertNamePrefix = prefix
+	}
+	if r.OwnerMapping != nil {
+		var err error
+		parseOpts.Owner, err = r.OwnerMapping.Owner(promRule.GetNamespace())
+		if err != nil {
+			return nil, err
+		}
+	}
+	parseOpts.Annotations = r.copiedAnnotations(promRule)
+	parseOpts.AnnotationPrefix = r.AnnotationPrefix
+	if r.PrometheusServerLabel || r.aggregatePerNamespace() {
+		// In case of aggregation per namespace, the AbsencePrometheusRule holds the absence
+		// alert rules for all Prometheus servers therefore we always specify the
+		// Prometheus server on the rules themselves.
+		parseOpts.PrometheusServer = promServer
+	}
+	parseOpts.PrometheusServerLabelKey = r.prometheusServerLabelKey()
+	if r.SourceURLTemplate != nil {
+		var err error
+		parseOpts.SourceURL, err = RenderSourceURL(r.SourceURLTemplate, promRule.GetNamespace(), promRule.GetName())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.GroupingLabel != "" && r.GroupingLabelTemplate != nil {
+		var err error
+		parseOpts.GroupingLabel = r.GroupingLabel
+		parseOpts.GroupingLabelValue, err = RenderGroupingLabel(r.GroupingLabelTemplate, promServer, promRule.GetNamespace())
+		if err != nil {
+			return nil, err
+		}
+	}
+	out, dropped, err := generateRuleGroups(log, promRule.Spec.Groups, promRule.GetName(), parseOpts)
+	if dropped > 0 && r.Recorder != nil {
+		r.Recorder.Eventf(promRule, corev1.EventTypeWarning, "TooManyAbsenceRules",
+			"dropped %d absence alert rules that exceed the maximum of %d", dropped, parseOpts.MaxRules)
+	}
+	return out, err
+}
+
 // mergeAbsenceRuleGroups merges existing and newly generated AbsenceRuleGroups. If the
 // same AbsenceRuleGroup exists in both 'existing' and 'new' then the newer one will be
 // used.
+//
+// The groupsMerged counter is incremented for each AbsenceRuleGroup in the result. A new
+// AbsenceRuleGroup that is identical to the existing one counts as carried over.
 func mergeAbsenceRuleGroups(existingRuleGroups, newRuleGroups []monitoringv1.RuleGroup) []monitoringv1.RuleGroup {
 	var result []monitoringv1.RuleGroup
 	added := make(map[string]bool)
@@ -359,17 +947,24 @@ OuterLoop:
 				// Add the new updated RuleGroup.
 				result = append(result, newG)
 				added[newG.Name] = true
+				if reflect.DeepEqual(oldG, newG) {
+					groupsMerged.WithLabelValues(mergeActionCarriedOver).Inc()
+				} else {
+					groupsMerged.WithLabelValues(mergeActionUpdated).Inc()
+				}
 				continue OuterLoop
 			}
 		}
 		// This RuleGroup should be carried over as is.
 		result = append(result, oldG)
+		groupsMerged.WithLabelValues(mergeActionCarriedOver).Inc()
 	}
 
 	// Add the pending rule groups.
 	for _, g := range newRuleGroups {
 		if !added[g.Name] {
 			result = append(result, g)
+			groupsMerged.WithLabelValues(mergeActionAdded).Inc()
 		}
 	}
 	return result
@@ -15,14 +15,20 @@
 package controllers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"regexp"
 	"sort"
 	"strings"
+	"text/template"
+	"time"
+	"unicode"
 
 	"github.com/go-logr/logr"
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/common/model"
 	promlabels "github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/promql/parser"
 	"golang.org/x/text/cases"
@@ -38,54 +44,79 @@ type metricNameExtractor struct {
 	// expr is the PromQL expression that the metricNameExtractor is working on.
 	expr string
 
-	// This map contains metric names that were extracted from a promql.Node.
-	// We only use the keys of the map and never depend on the presence of an
-	// element in the map nor its value therefore we use an empty struct instead
-	// of a bool.
-	found map[string]struct{}
+	// preserveMatchers specifies whether the label matchers of a VectorSelector are
+	// retained in the expression of the corresponding absence alert rule.
+	preserveMatchers bool
+
+	// covered contains the keys (see selectorKey()) of the VectorSelectors that are
+	// already checked by an absent function in the expression.
+	covered map[string]bool
+
+	// skipLabels contains the label name/value pairs that mark a VectorSelector as
+	// referencing time series from an external source (e.g. via federation).
+	skipLabels map[string]string
+
+	// excludeMetrics matches the names of metrics that do not get absence alert rules.
+	excludeMetrics *regexp.Regexp
+
+	// recordedMetrics contains the names of the metrics that are recorded by recording
+	// rules in the same PrometheusRule.
+	recordedMetrics map[string]bool
+
+	// optionalMetrics contains the names of metrics that are known to be optional.
+	optionalMetrics map[string]bool
+
+	// handWritten contains the keys (see selectorKey()) of the arguments of hand-written
+	// absence alert rules (see ParseOpts.HandWrittenAbsence).
+	handWritten map[string]bool
+
+	// skipColonMetrics specifies whether metrics whose name contains a colon are skipped.
+	skipColonMetrics bool
+
+	// alertOnUp specifies whether the "up" metric is processed if it is selected with
+	// label matchers.
+	alertOnUp bool
+
+	// liftLabels contains the names of the labels whose equality matchers are lifted
+	// into the labels of the absence alert rules.
+	liftLabels map[string]bool
+
+	// lifted contains the values of the lifted labels. The key is the same as in found.
+	lifted map[string]map[string]string
+
+	// This map contains the time series that were extracted from a promql.Node.
+	// The key is the argument for the absent function in the absence alert rule
+	// (i.e. the metric name or a selector with label matchers) and the value is the
+	// metric name.
+	found map[string]string
+
+	// order contains the keys of found in the order in which they were first found.
+	order []string
+}
+
+// absentFuncs are the PromQL functions that check for the absence of time series.
+var absentFuncs = map[string]bool{
+	"absent":           true,
+	"absent_over_time": true,
 }
 
 // Visit implements the parser.Visitor interface.
 func (mex *metricNameExtractor) Visit(node parser.Node, path []parser.Node) (parser.Visitor, error) {
+	// Only VectorSelectors reference time series. Function arguments such as the label
+	// names and regexes in label_replace() are StringLiterals and are skipped here, as are
+	// the parameters of aggregations (e.g. the 3 in "topk(3, foo)" or the label name in
+	// "count_values("value", foo)") and the scalar arguments of functions (e.g. the 3600
+	// in "predict_linear(foo[1h], 3600)" or the factors in "holt_winters(foo[1h], 0.3,
+	// 0.6)") which are NumberLiterals. Scalar arguments that select time series, e.g.
+	// "scalar(bar)", are visited like any other expression. The grouping labels of
+	// aggregations (e.g. "sum without (instance) (foo)") are not nodes at all and
+	// therefore never visited.
 	vs, ok := node.(*parser.VectorSelector)
 	if !ok {
 		return mex, nil
 	}
 
-	name := vs.Name
-	if name == "" {
-		// Check if the VectorSelector uses label matching against the internal `__name__`
-		// label. For example, the expression `http_requests_total` is equivalent to
-		// `{__name__="http_requests_total"}`.
-		for _, v := range vs.LabelMatchers {
-			if v.Name != "__name__" {
-				continue
-			}
-
-			switch v.Type {
-			case promlabels.MatchEqual, promlabels.MatchNotEqual:
-				name = v.Value
-			case promlabels.MatchRegexp, promlabels.MatchNotRegexp:
-				// Currently, we don't create absence alerts for regex name
-				// label matching.
-				// However, there are cases where some alert expressions use
-				// regexp matching even where an equality would suffice.
-				// E.g.:
-				//   {__name__=~"http_requests_total"}
-				rx, err := regexp.Compile(v.Value)
-				if err != nil {
-					// We do not return on error here so that any subsequent
-					// VectorSelector(s) get a chance to be processed.
-					mex.logger.Error(err, fmt.Sprintf("could not compile regex: %s", v.Value),
-						"expr", mex.expr)
-					continue
-				}
-				if rx.MatchString(v.Value) {
-					name = v.Value
-				}
-			}
-		}
-	}
+	name := mex.metricName(vs)
 	if name == "" {
 		mex.logger.Error(errors.New("error while parsing PromQL query"),
 			fmt.Sprintf("could not find metric name for VectorSelector: %s", vs.String()),
@@ -94,20 +125,244 @@ func (mex *metricNameExtractor) Visit(node parser.Node, path []parser.Node) (par
 	}
 
 	switch {
-	case strings.Contains(mex.expr, fmt.Sprintf("absent(%s", name)) ||
-		strings.Contains(mex.expr, fmt.Sprintf("absent({__name__=\"%s\"", name)):
-		// Skip this metric if the there is already an absent function for it in the
-		// original expression.
-		// E.g. absent(metric_name) || absent({__name__="metric_name"})
-	case name == "up":
+	case isInAbsentFunc(path) || mex.covered[selectorKey(name, vs)]:
+		// Skip this time series if the there is already an absent function for it in
+		// the original expression.
+		// E.g. absent(metric_name) || absent({__name__="metric_name"}) ||
+		// absent_over_time(metric_name[5m])
+	case mex.handWritten[mex.argKey(name, vs)]:
+		// Skip this time series if there is a hand-written absence alert rule that is
+		// identical to the one that would be generated.
+	case hasSkipMatcher(vs, mex.skipLabels):
+		// Skip time series from external sources, e.g. metrics that are ingested via
+		// federation, since absence alerts for them are unreliable.
+	case name == "up" && !(mex.alertOnUp && mex.preserveMatchers && len(labelMatchers(vs)) > 0):
 		// Skip "up" metric, it is automatically injected by Prometheus to describe
-		// Prometheus scraping jobs.
+		// Prometheus scraping jobs. With label matchers (e.g. up{job="x"}), its absence
+		// means that a scrape target vanished entirely which can be opted into.
+	case mex.excludeMetrics != nil && mex.excludeMetrics.MatchString(name):
+		// Skip metrics that are excluded explicitly, e.g. scrape health metrics like
+		// "foo_up" that are usually covered by other alerts.
+	case mex.recordedMetrics[name]:
+		// Skip metrics that are recorded in the same PrometheusRule.
+	case mex.optionalMetrics[name]:
+		// Skip metrics that are known to be optional, i.e. their absence is expected.
+	case mex.skipColonMetrics && strings.Contains(name, ":"):
+		// Skip metrics that follow the naming convention of recording rules, i.e.
+		// "level:metric:operations", since they are usually not scraped time series.
 	default:
-		mex.found[name] = struct{}{}
+		arg := name
+		if mex.preserveMatchers {
+			arg = selectorString(name, vs)
+		}
+		if len(mex.liftLabels) > 0 {
+			mex.liftMatchers(arg, vs)
+		}
+		if _, ok := mex.found[arg]; !ok {
+			mex.order = append(mex.order, arg)
+		}
+		mex.found[arg] = name
 	}
 	return mex, nil
 }
 
+// argKey returns the key (see selectorKey()) of the argument of the absent function in
+// the absence alert rule for a VectorSelector.
+func (mex *metricNameExtractor) argKey(name string, vs *parser.VectorSelector) string {
+	if mex.preserveMatchers {
+		return selectorKey(name, vs)
+	}
+	return selectorKey(name, &parser.VectorSelector{})
+}
+
+// liftMatchers records the values of the equality matchers of a VectorSelector for the
+// labels in liftLabels. If the same argument is found multiple times with different
+// values for a label then that label is dropped since it is ambiguous.
+func (mex *metricNameExtractor) liftMatchers(arg string, vs *parser.VectorSelector) {
+	values := make(map[string]string)
+	for _, m := range labelMatchers(vs) {
+		if mex.liftLabels[m.Name] && m.Type == promlabels.MatchEqual && m.Value != "" {
+			values[m.Name] = m.Value
+		}
+	}
+
+	prev, ok := mex.lifted[arg]
+	if !ok {
+		mex.lifted[arg] = values
+		return
+	}
+	for k, v := range prev {
+		if values[k] != v {
+			delete(prev, k)
+		}
+	}
+}
+
+// metricName returns the metric name of a VectorSelector. An empty string is returned
+// if the name can't be determined.
+func (mex *metricNameExtractor) metricName(vs *parser.VectorSelector) string {
+	if vs.Name != "" {
+		return vs.Name
+	}
+
+	// Check if the VectorSelector uses label matching against the internal `__name__`
+	// label. For example, the expression `http_requests_total` is equivalent to
+	// `{__name__="http_requests_total"}`.
+	var name string
+	for _, v := range vs.LabelMatchers {
+		if v.Name != promlabels.MetricName {
+			continue
+		}
+
+		switch v.Type {
+		case promlabels.MatchEqual, promlabels.MatchNotEqual:
+			name = v.Value
+		case promlabels.MatchRegexp, promlabels.MatchNotRegexp:
+			// Currently, we don't create absence alerts for regex name
+			// label matching.
+			// However, there are cases where some alert expressions use
+			// regexp matching even where an equality would suffice.
+			// E.g.:
+			//   {__name__=~"http_requests_total"}
+			rx, err := regexp.Compile(v.Value)
+			if err != nil {
+				// We do not return on error here so that any subsequent
+				// VectorSelector(s) get a chance to be processed.
+				mex.logger.Error(err, fmt.Sprintf("could not compile regex: %s", v.Value),
+					"expr", mex.expr)
+				continue
+			}
+			if rx.MatchString(v.Value) {
+				name = v.Value
+			}
+		}
+	}
+	return name
+}
+
+// isInAbsentFunc checks whether a node with the given path is an argument of an absent
+// function.
+func isInAbsentFunc(path []parser.Node) bool {
+	for _, n := range path {
+		if c, ok := n.(*parser.Call); ok && absentFuncs[c.Func.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// labelMatchers returns the label matchers of a VectorSelector excluding the matcher for
+// the metric name.
+func labelMatchers(vs *parser.VectorSelector) []*promlabels.Matcher {
+	result := make([]*promlabels.Matcher, 0, len(vs.LabelMatchers))
+	for _, m := range vs.LabelMatchers {
+		if m.Name != promlabels.MetricName {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// hasSkipMatcher reports whether a VectorSelector has an equality matcher for one of the
+// given label name/value pairs.
+func hasSkipMatcher(vs *parser.VectorSelector, skipLabels map[string]string) bool {
+	for _, m := range labelMatchers(vs) {
+		if v, ok := skipLabels[m.Name]; ok && m.Type == promlabels.MatchEqual && m.Value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// selectorKey returns a key that identifies the time series selected by a
+// VectorSelector irrespective of the order of its label matchers. Regex matchers with a
+// literal value are treated like the equivalent equality matchers, e.g. foo{job=~"a"}
+// has the same key as foo{job="a"}.
+func selectorKey(name string, vs *parser.VectorSelector) string {
+	var matchers []string
+	for _, m := range labelMatchers(vs) {
+		if regexp.QuoteMeta(m.Value) == m.Value {
+			switch m.Type {
+			case promlabels.MatchRegexp:
+				m = &promlabels.Matcher{Type: promlabels.MatchEqual, Name: m.Name, Value: m.Value}
+			case promlabels.MatchNotRegexp:
+				m = &promlabels.Matcher{Type: promlabels.MatchNotEqual, Name: m.Name, Value: m.Value}
+			}
+		}
+		matchers = append(matchers, m.String())
+	}
+	sort.Strings(matchers)
+	return fmt.Sprintf("%s{%s}", name, strings.Join(matchers, ","))
+}
+
+// selectorString returns the VectorSelector for a metric with its label matchers. Any
+// offset or @ modifiers of the original VectorSelector are not included.
+func selectorString(name string, vs *parser.VectorSelector) string {
+	matchers := labelMatchers(vs)
+	if len(matchers) == 0 {
+		return name
+	}
+	strs := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		strs = append(strs, m.String())
+	}
+	return fmt.Sprintf("%s{%s}", name, strings.Join(strs, ", "))
+}
+
+// absentSelectorKeys returns the keys (see selectorKey()) of all VectorSelectors that
+// are used as arguments of an absent function in the given expression.
+func absentSelectorKeys(mex *metricNameExtractor, expr parser.Node) map[string]bool {
+	result := make(map[string]bool)
+	parser.Inspect(expr, func(node parser.Node, path []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok || !isInAbsentFunc(path) {
+			return nil
+		}
+		if name := mex.metricName(vs); name != "" {
+			result[selectorKey(name, vs)] = true
+		}
+		return nil
+	})
+	return result
+}
+
+// vectorUnlessSelectorKeys returns the keys (see selectorKey()) of all VectorSelectors
+// that are the right-hand side of an expression of the form "vector(N) unless <selector>"
+// in the given expression, e.g. foo in "vector(1) unless foo".
+func vectorUnlessSelectorKeys(mex *metricNameExtractor, expr parser.Node) map[string]bool {
+	result := make(map[string]bool)
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		be, ok := node.(*parser.BinaryExpr)
+		if !ok || be.Op != parser.LUNLESS {
+			return nil
+		}
+		c, ok := unwrapParenExpr(be.LHS).(*parser.Call)
+		if !ok || c.Func.Name != "vector" {
+			return nil
+		}
+		vs, ok := unwrapParenExpr(be.RHS).(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		if name := mex.metricName(vs); name != "" {
+			result[selectorKey(name, vs)] = true
+		}
+		return nil
+	})
+	return result
+}
+
+// unwrapParenExpr returns the expression inside any number of parentheses.
+func unwrapParenExpr(expr parser.Expr) parser.Expr {
+	for {
+		p, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = p.Expr
+	}
+}
+
 // absenceRuleGroupName returns the name of the RuleGroup that holds absence alert rules
 // for a specific RuleGroup in a specific PrometheusRule.
 func absenceRuleGroupName(promRule, ruleGroup string) string {
@@ -125,6 +380,258 @@ func promRulefromAbsenceRuleGroupName(ruleGroup string) string {
 	return sL[0]
 }
 
+// ParseOpts holds the options that define how absence alert rules are generated.
+type ParseOpts struct {
+	LabelOpts
+
+	// Namespace is the namespace of the PrometheusRule. It is only used for metrics.
+	Namespace string
+
+	// IncludeSourceExpr specifies whether the expression of the original alert rule
+	// should be added as an annotation to its absence alert rules.
+	IncludeSourceExpr bool
+
+	// IncludeSourceGroup specifies whether the name of the original rule group should be
+	// added as an annotation to its absence alert rules. Unlike the name of the absence
+	// rule group, it does not depend on the naming format of absence rule groups.
+	IncludeSourceGroup bool
+
+	// SourceGroup is the name of the rule group of the alert rule that is parsed. It is
+	// determined separately for each rule group.
+	SourceGroup string
+
+	// GeneratedByVersion is the version of the operator. If not empty, it is added as the
+	// 'absent-metrics-operator/generated-by-version' annotation to all absence alert rules
+	// so that it can be audited which version generated them. Since the annotation is
+	// part of the rule groups, it also changes their hashes (see
+	// PrometheusRuleReconciler.HashRuleGroups), therefore all absence rule groups are
+	// regenerated after an upgrade.
+	GeneratedByVersion string
+
+	// StaticLabels are added to all absence alert rules.
+	StaticLabels map[string]string
+
+	// MinFor is the minimum duration for the `for` field of absence alert rules.
+	MinFor time.Duration
+
+	// ForBySeverity maps values of the `severity` label of absence alert rules to the
+	// duration for their `for` field. It takes precedence over the default duration and
+	// MinFor.
+	ForBySeverity map[string]time.Duration
+
+	// PreserveMatchers specifies whether the label matchers that are used for a metric
+	// in the original alert rule are retained in the expression of its absence alert
+	// rule, e.g. absent(foo{job="a"}) instead of absent(foo).
+	PreserveMatchers bool
+
+	// DefaultSeverity is the value of the `severity` label of absence alert rules. If
+	// empty, "info" is used.
+	DefaultSeverity string
+
+	// EscalationSeverity is the value of the `severity` label of escalated absence alert
+	// rules. If empty, "critical" is used. Escalated absence alert rules are generated
+	// in addition to the regular ones for alert rules that have the
+	// 'absent-metrics-operator/escalate-after' annotation, whose value is used for their
+	// `for` field.
+	EscalationSeverity string
+
+	// SkipLabels contains label name/value pairs that mark time series from external
+	// sources (e.g. federation). Metrics that are selected with an equality matcher for
+	// one of these pairs do not get absence alert rules.
+	SkipLabels map[string]string
+
+	// ExcludeMetrics matches the names of metrics that do not get absence alert rules.
+	// The "up" metric is always excluded unless AlertOnUp is set.
+	ExcludeMetrics *regexp.Regexp
+
+	// OptionalMetrics contains the exact names of metrics that are known to be optional.
+	// These metrics do not get absence alert rules.
+	OptionalMetrics map[string]bool
+
+	// HandWrittenAbsence contains the keys of the time series that already have
+	// hand-written absence alert rules, e.g. absent(foo{job="bar"}). No absence alert
+	// rules are generated for them if the generated ones would check the same time
+	// series, i.e. the same metric with the same label matchers irrespective of their
+	// order. It is determined separately for each PrometheusRule.
+	HandWrittenAbsence map[string]bool
+
+	// SkipColonMetrics specifies whether metrics whose name contains a colon are skipped.
+	// By convention, only the names of metrics that are recorded by recording rules
+	// contain colons (e.g. "job:http_requests:rate5m").
+	SkipColonMetrics bool
+
+	// SkipVectorUnless specifies whether the VectorSelectors in expressions of the form
+	// "vector(N) unless <selector>" are skipped. Such an expression is itself a check for
+	// the absence of the selected time series.
+	SkipVectorUnless bool
+
+	// AlertOnUp specifies whether absence alert rules are generated for the "up" metric
+	// if it is selected with label matchers, e.g. absent(up{job="x"}) which detects a
+	// scrape target that vanished entirely. This requires PreserveMatchers since
+	// absent(up) is meaningless. By default, the "up" metric is skipped.
+	AlertOnUp bool
+
+	// LiftMatcherLabels contains the names of labels whose values are lifted from the
+	// equality matchers of a metric in the original alert rule into the labels of its
+	// absence alert rule, e.g. `region: eu` for foo{region="eu"}. Lifted labels do not
+	// override other labels.
+	LiftMatcherLabels map[string]bool
+
+	// StripLabels contains the names of labels that are never carried over from the
+	// original alert rule to its absence alert rules, neither retained (see
+	// LabelOpts.Keep) nor lifted from matchers (see LiftMatcherLabels). This keeps the
+	// cardinality of absence alerts low.
+	StripLabels map[string]bool
+
+	// AllowedLabels contains the names of the only labels that may be carried over from
+	// the original alert rule to its absence alert rules. This applies to retained and
+	// lifted labels as well as to the defaults for the support group, tier, and service
+	// labels that are read from PrometheusRules. StripLabels still take precedence. All
+	// labels are allowed if it is empty.
+	AllowedLabels map[string]bool
+
+	// OwnerLabel is the name of the label that is set to the Owner if it was not
+	// specified otherwise, e.g. by retaining it from the original alert rule.
+	OwnerLabel string
+
+	// Owner is the team that owns the namespace of the PrometheusRule according to the
+	// OwnerMapping of the reconciler. It is determined separately for each
+	// PrometheusRule.
+	Owner string
+
+	// AlertNamePrefix is the first word of the names of absence alert rules. If empty,
+	// "absent" is used.
+	AlertNamePrefix string
+
+	// AlertNameStyle specifies how the names of absence alert rules are generated from
+	// the names of their metrics: AlertNameStyleTitleCase (the default),
+	// AlertNameStyleVerbatim, or AlertNameStylePrefixed.
+	AlertNameStyle string
+
+	// Annotations are added to all absence alert rules. They do not override the
+	// `summary` and `description` annotations.
+	Annotations map[string]string
+
+	// SourceURL is added as the 'absent-metrics-operator/source-url' annotation to all
+	// absence alert rules. It links to the source of the original alert rules, e.g. a
+	// file in a Git repository.
+	SourceURL string
+
+	// QueryURLTemplate is rendered for each metric (see RenderQueryURL()) and added as
+	// the 'absent-metrics-operator/query-url' annotation to its absence alert rules,
+	// e.g. a link to the Grafana explore view of the metric. Since the metric is absent,
+	// this is the place to look for its last values.
+	QueryURLTemplate *template.Template
+
+	// AnnotationPrefix is the prefix of the keys of the annotations that are added by the
+	// operator (e.g. 'absent-metrics-operator/source-url'). If empty, the
+	// DefaultAnnotationPrefix is used.
+	AnnotationPrefix string
+
+	// SkipSeverities contains the values of the `severity` label of alert rules for
+	// which no absence alert rules are generated.
+	SkipSeverities map[string]bool
+
+	// RenameLabels maps label names of absence alert rules to the names that are used
+	// instead. The labels are renamed after all other labels have been determined. Use
+	// ValidateRenameLabels() to check the new names.
+	RenameLabels map[string]string
+
+	// OriginalSeverity specifies whether the `severity` label of the original alert rule
+	// is added as the `original_severity` label to its absence alert rules, e.g. for
+	// analytics. Templated severities are not added.
+	OriginalSeverity bool
+
+	// SeverityOrder lists values of the `severity` label from highest to lowest. If set,
+	// the absence alert rules in a rule group that have the same expression are merged
+	// into the one that was generated for the alert rule with the highest severity.
+	SeverityOrder []string
+
+	// RuleOrder specifies the order of the absence alert rules in a rule group:
+	// RuleOrderSorted (the default) or RuleOrderSource.
+	RuleOrder string
+
+	// MinGroupInterval is the minimum evaluation interval of absence rule groups. The
+	// interval of the original rule group is copied to its absence rule group and raised
+	// to this minimum if it is lower, so that absence rule groups do not overload the
+	// rule evaluation of Prometheus.
+	MinGroupInterval time.Duration
+
+	// MaxRules is the maximum number of absence alert rules that are generated for a
+	// PrometheusRule. Further absence alert rules are dropped. Zero means no limit.
+	MaxRules int
+
+	// SkipLocalRecordedMetrics specifies whether metrics that are recorded by a recording
+	// rule in the same PrometheusRule are skipped. Metrics that are recorded in other
+	// PrometheusRules still get absence alert rules.
+	SkipLocalRecordedMetrics bool
+
+	// PrometheusServer is added as the `prometheus` label to all absence alert rules if
+	// it is not empty. It is determined separately for each PrometheusRule.
+	PrometheusServer string
+
+	// PrometheusServerLabelKey is the key of the label for the PrometheusServer. If
+	// empty, DefaultPrometheusServerLabelKey is used.
+	PrometheusServerLabelKey string
+
+	// GroupingLabel is the key of a label that is added with the GroupingLabelValue to all
+	// absence alert rules, e.g. so that Alertmanager groups all absence alerts of a
+	// Prometheus server into one notification. It is ignored if empty.
+	GroupingLabel string
+
+	// GroupingLabelValue is the value of the GroupingLabel. It is determined separately
+	// for each PrometheusRule (see RenderGroupingLabel()).
+	GroupingLabelValue string
+
+	// MissingLabels specifies how absence alert rules are handled whose retained tier or
+	// service label could not be determined: MissingLabelsLog (the default),
+	// MissingLabelsSkip, or MissingLabelsPlaceholder.
+	MissingLabels string
+
+	// MissingLabelPlaceholder is the value that is used for missing labels with
+	// MissingLabelsPlaceholder.
+	MissingLabelPlaceholder string
+
+	// SanitizeLabelValues specifies whether the values of the labels of absence alert
+	// rules are sanitized (see sanitizeLabelValue()).
+	SanitizeLabelValues bool
+}
+
+// Valid values for the RuleOrder field of ParseOpts.
+const (
+	// RuleOrderSorted sorts the absence alert rules by their name, expression, and
+	// description (see sortRules()). The order does not depend on the order of the
+	// original alert rules which keeps diffs small.
+	RuleOrderSorted = "sorted"
+	// RuleOrderSource keeps the absence alert rules in the order in which their metrics
+	// appear in the original rule group.
+	RuleOrderSource = "source-order"
+)
+
+// Valid values for the AlertNameStyle field of ParseOpts.
+const (
+	// AlertNameStyleTitleCase joins the title-cased words of the prefix, the support
+	// group (or tier), the service, and the metric name, e.g. "AbsentTierServiceFooBar".
+	AlertNameStyleTitleCase = "titlecase"
+	// AlertNameStyleVerbatim appends the metric name as is to the title-cased prefix,
+	// e.g. "Absent_foo_bar".
+	AlertNameStyleVerbatim = "verbatim"
+	// AlertNameStylePrefixed appends the metric name as is to the lowercase prefix, e.g.
+	// "absent_foo_bar".
+	AlertNameStylePrefixed = "prefixed"
+)
+
+// Valid values for the MissingLabels field of ParseOpts.
+const (
+	// MissingLabelsLog generates absence alert rules without the missing labels. The
+	// missing defaults are logged by the controller.
+	MissingLabelsLog = "log"
+	// MissingLabelsSkip does not generate absence alert rules with missing labels.
+	MissingLabelsSkip = "skip"
+	// MissingLabelsPlaceholder uses the MissingLabelPlaceholder for missing labels.
+	MissingLabelsPlaceholder = "placeholder"
+)
+
 type ruleGroupParseError struct {
 	cause error
 }
@@ -134,6 +641,8 @@ func (e *ruleGroupParseError) Error() string {
 	return e.cause.Error()
 }
 
+var errTooManyRules = errors.New("too many absence alert rules for PrometheusRule")
+
 // ParseRuleGroups takes a slice of RuleGroup that has alert rules and returns
 // a new slice of RuleGroup that has the corresponding absence alert rules.
 //
@@ -143,10 +652,33 @@ func (e *ruleGroupParseError) Error() string {
 // used.
 //
 // The rule group names for the absence alerts have the format: promRuleName/originalGroupName.
-func ParseRuleGroups(logger logr.Logger, in []monitoringv1.RuleGroup, promRuleName string, opts LabelOpts) ([]monitoringv1.RuleGroup, error) {
+func ParseRuleGroups(logger logr.Logger, in []monitoringv1.RuleGroup, promRuleName string, opts ParseOpts) ([]monitoringv1.RuleGroup, error) {
+	out, _, err := generateRuleGroups(logger, in, promRuleName, opts)
+	return out, err
+}
+
+// generateRuleGroups implements ParseRuleGroups. It additionally returns the number of
+// absence alert rules that were dropped because of ParseOpts.MaxRules.
+func generateRuleGroups(
+	logger logr.Logger,
+	in []monitoringv1.RuleGroup,
+	promRuleName string,
+	opts ParseOpts,
+) ([]monitoringv1.RuleGroup, int, error) {
+
 	out := make([]monitoringv1.RuleGroup, 0, len(in))
-	for _, g := range in {
+	groupNames := uniqueRuleGroupNames(in)
+	var recorded map[string]bool
+	if opts.SkipLocalRecordedMetrics {
+		recorded = recordedMetrics(in)
+	}
+	total := 0   // number of absence alert rules in out
+	dropped := 0 // number of absence alert rules that exceeded opts.MaxRules
+	for i, g := range in {
+		groupOpts := opts
+		groupOpts.SourceGroup = g.Name
 		var absenceAlertRules []monitoringv1.Rule
+		var severities []string // severity of the original alert rule for each absence alert rule
 		for _, r := range g.Rules {
 			// Do not parse recording rules.
 			if r.Record != "" {
@@ -156,28 +688,205 @@ func ParseRuleGroups(logger logr.Logger, in []monitoringv1.RuleGroup, promRuleNa
 			if r.Labels != nil && parseBool(r.Labels[labelNoAlertOnAbsence]) {
 				continue
 			}
-			rules, err := parseAlertRule(logger, r, opts)
+			// Do not parse alert rule if its severity is skipped.
+			if opts.SkipSeverities[r.Labels["severity"]] {
+				continue
+			}
+			rules, err := parseAlertRule(logger, r, recorded, groupOpts)
 			if err != nil {
-				return nil, &ruleGroupParseError{cause: err}
+				return nil, 0, &ruleGroupParseError{cause: err}
 			}
 			if len(rules) > 0 {
 				absenceAlertRules = append(absenceAlertRules, rules...)
+				for range rules {
+					severities = append(severities, r.Labels["severity"])
+				}
 			}
 		}
+		if len(opts.SeverityOrder) > 0 {
+			absenceAlertRules = mergeBySeverity(absenceAlertRules, severities, opts.SeverityOrder)
+		}
+		if opts.MaxRules > 0 && total+len(absenceAlertRules) > opts.MaxRules {
+			kept := truncateRules(absenceAlertRules, opts.MaxRules-total)
+			dropped += len(absenceAlertRules) - len(kept)
+			absenceAlertRules = kept
+		}
+		total += len(absenceAlertRules)
 
 		if len(absenceAlertRules) > 0 {
-			// Sort alert rules for consistent test results.
-			sort.SliceStable(absenceAlertRules, func(i, j int) bool {
-				return absenceAlertRules[i].Alert < absenceAlertRules[j].Alert
-			})
+			interval, err := absenceRuleGroupInterval(g.Interval, opts.MinGroupInterval)
+			if err != nil {
+				return nil, 0, &ruleGroupParseError{cause: fmt.Errorf("invalid interval of rule group %q: %w", g.Name, err)}
+			}
+			name := absenceRuleGroupName(promRuleName, groupNames[i])
+			if opts.RuleOrder != RuleOrderSource {
+				sortRules(absenceAlertRules)
+			}
 
 			out = append(out, monitoringv1.RuleGroup{
-				Name:  absenceRuleGroupName(promRuleName, g.Name),
-				Rules: absenceAlertRules,
+				Name:     name,
+				Interval: interval,
+				Rules:    absenceAlertRules,
 			})
 		}
 	}
-	return out, nil
+	if dropped > 0 {
+		// Make it visible that the PrometheusRule is not fully covered.
+		logger.Error(errTooManyRules, "dropping absence alert rules for PrometheusRule",
+			"maxRules", opts.MaxRules, "dropped", dropped)
+		truncatedSources.WithLabelValues(opts.Namespace, promRuleName).Inc()
+	}
+	return out, dropped, nil
+}
+
+// sortRules sorts absence alert rules by their name, expression, and description. The
+// order does not depend on the order of the original alert rules so that the generated
+// resources are stable and tools like 'kubectl diff' only show actual changes.
+func sortRules(rules []monitoringv1.Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		ri, rj := rules[i], rules[j]
+		if ri.Alert != rj.Alert {
+			return ri.Alert < rj.Alert
+		}
+		if ei, ej := ri.Expr.String(), rj.Expr.String(); ei != ej {
+			return ei < ej
+		}
+		return ri.Annotations["description"] < rj.Annotations["description"]
+	})
+}
+
+// truncateRules returns at most n of the given absence alert rules. The stages of an
+// escalated absence alert rule, i.e. absence alert rules with the same name and
+// expression, are kept or dropped together so that the first stage is never kept
+// without its escalation. The order of the absence alert rules is preserved.
+func truncateRules(rules []monitoringv1.Rule, n int) []monitoringv1.Rule {
+	ruleKey := func(r monitoringv1.Rule) string {
+		return r.Alert + "\x00" + r.Expr.String()
+	}
+	sizes := make(map[string]int, len(rules))
+	var keys []string // in the order of their first occurrence
+	for _, r := range rules {
+		k := ruleKey(r)
+		if sizes[k] == 0 {
+			keys = append(keys, k)
+		}
+		sizes[k]++
+	}
+
+	kept := make(map[string]bool, len(keys))
+	count := 0
+	for _, k := range keys {
+		if count+sizes[k] > n {
+			break
+		}
+		kept[k] = true
+		count += sizes[k]
+	}
+
+	result := make([]monitoringv1.Rule, 0, count)
+	for _, r := range rules {
+		if kept[ruleKey(r)] {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// mergeBySeverity merges absence alert rules that have the same expression and duration.
+// The merged rule is the one whose original alert rule has the highest severity according
+// to the given order, severities that are not part of the order rank lowest. On a tie,
+// the first rule is used.
+//
+// Escalated absence alert rules have a different duration and are therefore merged
+// separately.
+func mergeBySeverity(rules []monitoringv1.Rule, severities, order []string) []monitoringv1.Rule {
+	rank := func(severity string) int {
+		for i, s := range order {
+			if s == severity {
+				return i
+			}
+		}
+		return len(order)
+	}
+
+	result := make([]monitoringv1.Rule, 0, len(rules))
+	resultRanks := make([]int, 0, len(rules))
+	idx := make(map[string]int, len(rules)) // expression and duration -> index in result
+	for i, r := range rules {
+		key := r.Expr.String()
+		if r.For != nil {
+			key += "\x00" + string(*r.For)
+		}
+		rk := rank(severities[i])
+		j, ok := idx[key]
+		if !ok {
+			idx[key] = len(result)
+			result = append(result, r)
+			resultRanks = append(resultRanks, rk)
+			continue
+		}
+		if rk < resultRanks[j] {
+			result[j] = r
+			resultRanks[j] = rk
+		}
+	}
+	return result
+}
+
+// recordedMetrics returns the names of the metrics that are recorded by the recording
+// rules in the given RuleGroups.
+func recordedMetrics(in []monitoringv1.RuleGroup) map[string]bool {
+	result := make(map[string]bool)
+	for _, g := range in {
+		for _, r := range g.Rules {
+			if r.Record != "" {
+				result[r.Record] = true
+			}
+		}
+	}
+	return result
+}
+
+// uniqueRuleGroupNames returns the names of the given RuleGroups. If multiple RuleGroups
+// have the same name then an index is appended to the names of the subsequent ones, e.g.
+// "foo.alerts", "foo.alerts-2", "foo.alerts-3". This ensures that the corresponding
+// AbsenceRuleGroups do not collide and get merged.
+func uniqueRuleGroupNames(in []monitoringv1.RuleGroup) []string {
+	taken := make(map[string]bool, len(in))
+	for _, g := range in {
+		taken[g.Name] = true
+	}
+
+	result := make([]string, len(in))
+	seen := make(map[string]bool, len(in))
+	for i, g := range in {
+		name := g.Name
+		if seen[name] {
+			for n := 2; ; n++ {
+				name = fmt.Sprintf("%s-%d", g.Name, n)
+				if !taken[name] {
+					break
+				}
+			}
+			taken[name] = true
+		}
+		seen[g.Name] = true
+		result[i] = name
+	}
+	return result
+}
+
+// hasVectorSelector reports whether an expression contains a VectorSelector, i.e. whether
+// it references any time series.
+func hasVectorSelector(node parser.Node) bool {
+	found := false
+	parser.Inspect(node, func(n parser.Node, _ []parser.Node) error {
+		if _, ok := n.(*parser.VectorSelector); ok {
+			found = true
+		}
+		return nil
+	})
+	return found
 }
 
 var nonAlphaNumericRx = regexp.MustCompile(`[^a-zA-Z0-9]`)
@@ -186,15 +895,37 @@ var nonAlphaNumericRx = regexp.MustCompile(`[^a-zA-Z0-9]`)
 // an alert expression can reference multiple time series therefore a slice of
 // []monitoringv1.Rule is returned as multiple (one for each time series) absence alert
 // rules would be generated.
-func parseAlertRule(logger logr.Logger, in monitoringv1.Rule, opts LabelOpts) ([]monitoringv1.Rule, error) {
+func parseAlertRule(
+	logger logr.Logger,
+	in monitoringv1.Rule,
+	recorded map[string]bool,
+	opts ParseOpts,
+) ([]monitoringv1.Rule, error) {
+
 	exprStr := in.Expr.String()
 	mex := &metricNameExtractor{
-		logger: logger,
-		expr:   exprStr,
-		found:  map[string]struct{}{},
+		logger:           logger,
+		expr:             exprStr,
+		preserveMatchers: opts.PreserveMatchers,
+		skipLabels:       opts.SkipLabels,
+		excludeMetrics:   opts.ExcludeMetrics,
+		recordedMetrics:  recorded,
+		optionalMetrics:  opts.OptionalMetrics,
+		handWritten:      opts.HandWrittenAbsence,
+		skipColonMetrics: opts.SkipColonMetrics,
+		alertOnUp:        opts.AlertOnUp,
+		liftLabels:       opts.LiftMatcherLabels,
+		lifted:           map[string]map[string]string{},
+		found:            map[string]string{},
 	}
 	exprNode, err := parser.ParseExpr(exprStr)
 	if err == nil {
+		mex.covered = absentSelectorKeys(mex, exprNode)
+		if opts.SkipVectorUnless {
+			for k := range vectorUnlessSelectorKeys(mex, exprNode) {
+				mex.covered[k] = true
+			}
+		}
 		err = parser.Walk(mex, exprNode, nil)
 	}
 	if err != nil {
@@ -204,18 +935,38 @@ func parseAlertRule(logger logr.Logger, in monitoringv1.Rule, opts LabelOpts) ([
 		return nil, fmt.Errorf("could not parse rule expression: %s: %s", err.Error(), exprStr)
 	}
 	if len(mex.found) == 0 {
+		if !hasVectorSelector(exprNode) {
+			// The expression does not reference any time series, e.g. "vector(1) > 0".
+			// This is often a mistake.
+			logger.V(logLevelDebug).Info("alert rule does not reference any time series",
+				"alert", in.Alert, "expr", exprStr)
+			rulesWithoutMetrics.WithLabelValues(opts.Namespace).Inc()
+		}
 		return nil, nil
 	}
 
 	// Default labels.
+	severity := opts.DefaultSeverity
+	if severity == "" {
+		severity = defaultSeverity
+	}
 	absenceRuleLabels := map[string]string{
 		"context":  "absent-metrics",
-		"severity": "info",
+		"severity": severity,
+	}
+
+	// Static labels override the default labels but not the labels that are retained
+	// from the original alert rule.
+	for k, v := range opts.StaticLabels {
+		absenceRuleLabels[k] = v
 	}
 
 	// Retain labels from the original alert rule.
 	if ruleLabels := in.Labels; ruleLabels != nil {
 		for k := range opts.Keep {
+			if !opts.carriesOver(k) {
+				continue
+			}
 			v := ruleLabels[k]
 			emptyOrTmplVal := (v == "" || strings.Contains(v, "$labels"))
 			if k == LabelSupportGroup && emptyOrTmplVal {
@@ -233,59 +984,530 @@ func parseAlertRule(logger logr.Logger, in monitoringv1.Rule, opts LabelOpts) ([
 		}
 	}
 
-	out := make([]monitoringv1.Rule, 0, len(mex.found))
-	for m := range mex.found {
-		// Generate an alert name from metric name. Example:
-		//   network:tis_a_metric:rate5m -> Absent(Support Group|Tier)ServiceNetworkTisAMetricRate5m
-		supportGroup := absenceRuleLabels[LabelSupportGroup]
-		if supportGroup == "" {
-			supportGroup = absenceRuleLabels[LabelTier] // use tier in case there is no support group
-		}
-		var words []string
-		for _, v := range []string{"absent", supportGroup, absenceRuleLabels[LabelService], m} {
-			s := nonAlphaNumericRx.Split(v, -1) // remove non-alphanumeric characters
-			words = append(words, s...)
-		}
-		// Avoid name stuttering
-		//
-		// TODO: fix edge case when support_group or service label value has non-numeric
-		// character and splitting it will still result in name stuttering because
-		// matching with previous word (as we do below) does not work as the original word
-		// has been split into multiple words.
-		// Example: support_group = "containers", service = "go-pmtud",
-		// and metric = "go_pmtud_sent_error_peer_total" will result in
-		// "AbsentContainersGoPmtudGoPmtudSentErrorPeerTotal" as the alert name.
-		var alertName string
-		var prevW string
-		for _, v := range words {
-			w := strings.ToLower(v) // convert to lowercase for comparison
-			if w != prevW {
-				alertName += cases.Title(language.English).String(w)
-				prevW = w
+	// Route absence alerts to the owner of the namespace if the alert rule does not
+	// specify one itself.
+	if opts.OwnerLabel != "" && opts.Owner != "" && absenceRuleLabels[opts.OwnerLabel] == "" {
+		absenceRuleLabels[opts.OwnerLabel] = opts.Owner
+	}
+
+	// The absence alert rule has its own severity, the original one is only recorded.
+	if opts.OriginalSeverity && opts.carriesOver("severity") {
+		v := in.Labels["severity"]
+		if v != "" && !strings.Contains(v, "$labels") && !strings.Contains(v, "{{") {
+			absenceRuleLabels[labelOriginalSeverity] = v
+		}
+	}
+
+	if opts.SanitizeLabelValues {
+		for k, v := range absenceRuleLabels {
+			v = sanitizeLabelValue(v)
+			if v == "" {
+				delete(absenceRuleLabels, k)
+				continue
+			}
+			absenceRuleLabels[k] = v
+		}
+	}
+
+	// Absence alert rules can escalate to a second stage with a different severity after
+	// a longer duration.
+	var escalateAfter *time.Duration
+	escalateKey := annotationKey(opts.AnnotationPrefix, annotationEscalateAfter)
+	if v, ok := in.Annotations[escalateKey]; ok {
+		d, err := model.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %q annotation of alert rule %q: %w", escalateKey, in.Alert, err)
+		}
+		escalateAfter = (*time.Duration)(&d)
+	}
+
+	// The 'for' duration of absence alert rules can be derived from the original alert
+	// rule.
+	forStrategyKey := annotationKey(opts.AnnotationPrefix, annotationForStrategy)
+	forStrategy := in.Annotations[forStrategyKey]
+	var sourceFor *time.Duration
+	switch forStrategy {
+	case "", ForStrategyDefault:
+	case ForStrategyMax, ForStrategySource:
+		if in.For != nil {
+			d, err := model.ParseDuration(string(*in.For))
+			if err != nil {
+				return nil, fmt.Errorf("invalid 'for' duration of alert rule %q: %w", in.Alert, err)
 			}
+			sourceFor = (*time.Duration)(&d)
+		}
+	default:
+		return nil, fmt.Errorf("invalid value for %q annotation of alert rule %q: unknown strategy %q",
+			forStrategyKey, in.Alert, forStrategy)
+	}
+
+	// Handle tier and service labels whose value could neither be retained from the
+	// original alert rule nor be determined from the defaults.
+	for _, k := range []string{LabelTier, LabelService} {
+		if !opts.Keep[k] || !opts.carriesOver(k) || absenceRuleLabels[k] != "" {
+			continue
 		}
+		switch opts.MissingLabels {
+		case MissingLabelsSkip:
+			logger.V(logLevelDebug).Info("skipping alert rule since a label could not be determined",
+				"alert", in.Alert, "label", k)
+			return nil, nil
+		case MissingLabelsPlaceholder:
+			absenceRuleLabels[k] = opts.MissingLabelPlaceholder
+		}
+	}
+
+	// The Prometheus server always refers to the PrometheusRule of the original alert rule.
+	if opts.PrometheusServer != "" {
+		key := opts.PrometheusServerLabelKey
+		if key == "" {
+			key = DefaultPrometheusServerLabelKey
+		}
+		absenceRuleLabels[key] = opts.PrometheusServer
+	}
+
+	// The grouping label is the same for all absence alert rules of a Prometheus server
+	// and therefore overrides labels that are retained from the original alert rule.
+	if opts.GroupingLabel != "" {
+		absenceRuleLabels[opts.GroupingLabel] = opts.GroupingLabelValue
+	}
+
+	prefix := opts.AlertNamePrefix
+	if prefix == "" {
+		prefix = defaultAlertNamePrefix
+	}
+	supportGroup := absenceRuleLabels[LabelSupportGroup]
+	if supportGroup == "" {
+		supportGroup = absenceRuleLabels[LabelTier] // use tier in case there is no support group
+	}
+	metrics := make([]string, 0, len(mex.order))
+	for _, arg := range mex.order {
+		metrics = append(metrics, mex.found[arg])
+	}
+	alertNames := validAlertNames(metrics, func(m string) string {
+		return alertNameFuncs[opts.AlertNameStyle](prefix, supportGroup, absenceRuleLabels[LabelService], m)
+	})
+
+	out := make([]monitoringv1.Rule, 0, len(mex.found))
+	for _, arg := range mex.order {
+		m := mex.found[arg]
+		alertName := alertNames[m]
 
 		// TODO: remove the link from description and add a 'playbook' label,
 		// when our upstream solution gets the ability to process hardcoded
 		// links in the 'playbook' label.
-		ann := map[string]string{
-			"summary": fmt.Sprintf("missing %s", m),
-			"description": fmt.Sprintf(
-				"The metric '%s' is missing. '%s' alert using it may not fire as intended. "+
-					"See <https://github.com/sapcc/absent-metrics-operator/blob/master/docs/playbook.md|the operator playbook>.",
-				m, in.Alert,
-			),
+		ann := make(map[string]string, len(opts.Annotations)+2)
+		for k, v := range opts.Annotations {
+			ann[k] = v
+		}
+		ann["summary"] = fmt.Sprintf("missing %s", arg)
+		ann["description"] = fmt.Sprintf(
+			"The metric '%s' is missing. '%s' alert using it may not fire as intended. "+
+				"See <https://github.com/sapcc/absent-metrics-operator/blob/master/docs/playbook.md|the operator playbook>.",
+			arg, in.Alert,
+		)
+		if opts.IncludeSourceExpr {
+			ann[annotationKey(opts.AnnotationPrefix, annotationSourceExpr)] = sanitizeSourceExpr(exprStr)
+		}
+		if opts.IncludeSourceGroup {
+			ann[annotationKey(opts.AnnotationPrefix, annotationSourceGroup)] = opts.SourceGroup
+		}
+		if opts.GeneratedByVersion != "" {
+			ann[annotationKey(opts.AnnotationPrefix, annotationGeneratedByVersion)] = opts.GeneratedByVersion
+		}
+		if opts.SourceURL != "" {
+			ann[annotationKey(opts.AnnotationPrefix, annotationSourceURL)] = opts.SourceURL
+		}
+		if opts.QueryURLTemplate != nil {
+			u, err := RenderQueryURL(opts.QueryURLTemplate, arg, opts.PrometheusServer)
+			if err != nil {
+				return nil, fmt.Errorf("could not render query URL for metric %q of alert rule %q: %w", arg, in.Alert, err)
+			}
+			ann[annotationKey(opts.AnnotationPrefix, annotationQueryURL)] = u
 		}
 
-		duration := monitoringv1.Duration("10m")
+		labels := absenceRuleLabels
+		if lifted := mex.lifted[arg]; len(lifted) > 0 {
+			labels = liftLabels(absenceRuleLabels, lifted, opts.carriesOver, opts.SanitizeLabelValues)
+		}
+
+		forDuration := absenceRuleFor(opts, labels["severity"], forStrategy, sourceFor)
+		duration := monitoringv1.Duration(model.Duration(forDuration).String())
 		out = append(out, monitoringv1.Rule{
 			Alert:       alertName,
-			Expr:        intstr.FromString(fmt.Sprintf("absent(%s)", m)),
+			Expr:        intstr.FromString(fmt.Sprintf("absent(%s)", arg)),
 			For:         &duration,
-			Labels:      absenceRuleLabels,
+			Labels:      renameLabels(labels, opts.RenameLabels),
 			Annotations: ann,
 		})
+
+		if escalateAfter != nil {
+			escalatedLabels := make(map[string]string, len(labels))
+			for k, v := range labels {
+				escalatedLabels[k] = v
+			}
+			escalatedLabels["severity"] = opts.EscalationSeverity
+			if escalatedLabels["severity"] == "" {
+				escalatedLabels["severity"] = defaultEscalationSeverity
+			}
+			escalatedDuration := *escalateAfter
+			if escalatedDuration < opts.MinFor {
+				escalatedDuration = opts.MinFor
+			}
+			// Otherwise the escalated alert would fire before the first stage. Only the
+			// second stage is skipped so that the metric is still covered, e.g. when
+			// MinFor is raised above the value of the annotation.
+			if escalatedDuration <= forDuration {
+				logger.Error(errors.New("escalation is not later than the first stage"),
+					"skipping the second stage of absence alert rule", "alert", in.Alert, "metric", m,
+					"escalateAfter", model.Duration(escalatedDuration).String(), "for", model.Duration(forDuration).String())
+				continue
+			}
+			escalatedFor := monitoringv1.Duration(model.Duration(escalatedDuration).String())
+			out = append(out, monitoringv1.Rule{
+				Alert:       alertName,
+				Expr:        intstr.FromString(fmt.Sprintf("absent(%s)", arg)),
+				For:         &escalatedFor,
+				Labels:      renameLabels(escalatedLabels, opts.RenameLabels),
+				Annotations: ann,
+			})
+		}
 	}
 
 	return out, nil
 }
+
+// carriesOver reports whether the label with the given name may be carried over from the
+// original alert rule (see AllowedLabels and StripLabels).
+func (opts *ParseOpts) carriesOver(name string) bool {
+	if opts.StripLabels[name] {
+		return false
+	}
+	return len(opts.AllowedLabels) == 0 || opts.AllowedLabels[name]
+}
+
+// liftLabels returns a copy of the labels with the lifted labels added. Lifted labels do
+// not override existing labels and labels that are not carried over are not added.
+func liftLabels(labels, lifted map[string]string, carriesOver func(string) bool, sanitize bool) map[string]string {
+	result := make(map[string]string, len(labels)+len(lifted))
+	for k, v := range lifted {
+		if !carriesOver(k) {
+			continue
+		}
+		if sanitize {
+			v = sanitizeLabelValue(v)
+		}
+		if v != "" {
+			result[k] = v
+		}
+	}
+	for k, v := range labels {
+		result[k] = v
+	}
+	return result
+}
+
+// sanitizeLabelValue trims the whitespace of a label value and replaces commas and
+// control characters with underscores, since these are problematic for some downstream
+// consumers of alerts, e.g. for routing.
+func sanitizeLabelValue(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ',' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(v))
+}
+
+// renameLabels returns the labels with the names from the given rename map. Renamed
+// labels take precedence over existing labels with the same name.
+func renameLabels(labels, rename map[string]string) map[string]string {
+	if len(rename) == 0 {
+		return labels
+	}
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if _, ok := rename[k]; !ok {
+			result[k] = v
+		}
+	}
+	for k, v := range labels {
+		if newName, ok := rename[k]; ok {
+			result[newName] = v
+		}
+	}
+	return result
+}
+
+// alertNameFunc generates the name of an absence alert rule for a metric.
+type alertNameFunc func(prefix, supportGroup, service, metric string) string
+
+// alertNameFuncs maps the valid values of ParseOpts.AlertNameStyle to their alertNameFunc.
+var alertNameFuncs = map[string]alertNameFunc{
+	"":                      titleCaseAlertName,
+	AlertNameStyleTitleCase: titleCaseAlertName,
+	AlertNameStyleVerbatim:  verbatimAlertName,
+	AlertNameStylePrefixed:  prefixedAlertName,
+}
+
+// titleCaseAlertName generates an alert name from the title-cased words of the prefix,
+// the support group (or tier), the service, and the metric name. Example:
+//
+//	network:tis_a_metric:rate5m -> Absent(Support Group|Tier)ServiceNetworkTisAMetricRate5m
+func titleCaseAlertName(prefix, supportGroup, service, metric string) string {
+	var words []string
+	for _, v := range []string{prefix, supportGroup, service, metric} {
+		s := nonAlphaNumericRx.Split(v, -1) // remove non-alphanumeric characters
+		words = append(words, s...)
+	}
+	// Avoid name stuttering
+	//
+	// TODO: fix edge case when support_group or service label value has non-numeric
+	// character and splitting it will still result in name stuttering because
+	// matching with previous word (as we do below) does not work as the original word
+	// has been split into multiple words.
+	// Example: support_group = "containers", service = "go-pmtud",
+	// and metric = "go_pmtud_sent_error_peer_total" will result in
+	// "AbsentContainersGoPmtudGoPmtudSentErrorPeerTotal" as the alert name.
+	var alertName string
+	var prevW string
+	for _, v := range words {
+		w := strings.ToLower(v) // convert to lowercase for comparison
+		if w != prevW {
+			alertName += cases.Title(language.English).String(w)
+			prevW = w
+		}
+	}
+	return alertName
+}
+
+// invalidAlertNameRx matches the characters that are not allowed in alert names. Like
+// metric names, alert names may only contain ASCII letters, digits, underscores, and
+// colons.
+var invalidAlertNameRx = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// sanitizeAlertName replaces the characters of an alert name that are not allowed with
+// underscores. Names that are empty or start with a digit are prefixed with an
+// underscore. Example:
+//
+//	Absent_foo-bar.total -> Absent_foo_bar_total
+func sanitizeAlertName(name string) string {
+	s := invalidAlertNameRx.ReplaceAllString(name, "_")
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	return s
+}
+
+// validAlertNames returns the names of the absence alert rules for the given metrics
+// (see alertNameFunc) by metric. The names are generated from metric names, label
+// values, and the alert name prefix, any of which can contain characters that are not
+// allowed in alert names. Such names are sanitized (see sanitizeAlertName()). If a
+// sanitized name is the same as the name for a different metric, a hash of the metric
+// name is appended to it so that the absence alert rules of different metrics can still
+// be told apart.
+func validAlertNames(metrics []string, nameFunc func(metric string) string) map[string]string {
+	result := make(map[string]string, len(metrics))
+	// taken maps alert names to the metric that they belong to.
+	taken := make(map[string]string, len(metrics))
+	var invalid []string
+	for _, m := range metrics {
+		if _, ok := result[m]; ok {
+			continue
+		}
+		name := nameFunc(m)
+		result[m] = name
+		if sanitizeAlertName(name) != name {
+			invalid = append(invalid, m)
+			continue
+		}
+		taken[name] = m
+	}
+
+	for _, m := range invalid {
+		name := sanitizeAlertName(result[m])
+		if other, ok := taken[name]; ok && other != m {
+			sum := sha256.Sum256([]byte(m))
+			name += "_" + hex.EncodeToString(sum[:])[:8]
+		}
+		taken[name] = m
+		result[m] = name
+	}
+	return result
+}
+
+// verbatimAlertName generates an alert name from the title-cased prefix and the metric
+// name as is. Example:
+//
+//	foo_bar -> Absent_foo_bar
+func verbatimAlertName(prefix, _, _, metric string) string {
+	return cases.Title(language.English).String(strings.ToLower(prefix)) + "_" + metric
+}
+
+// prefixedAlertName generates an alert name from the lowercase prefix and the metric
+// name as is. Example:
+//
+//	foo_bar -> absent_foo_bar
+func prefixedAlertName(prefix, _, _, metric string) string {
+	return strings.ToLower(prefix) + "_" + metric
+}
+
+// defaultAlertNamePrefix is the default prefix of the names of absence alert rules.
+const defaultAlertNamePrefix = "absent"
+
+// defaultSeverity is the default value of the `severity` label of absence alert rules.
+const defaultSeverity = "info"
+
+// defaultEscalationSeverity is the default value of the `severity` label of escalated
+// absence alert rules.
+const defaultEscalationSeverity = "critical"
+
+// defaultFor is the default duration for the `for` field of absence alert rules.
+const defaultFor = 10 * time.Minute
+
+// Valid values for the 'absent-metrics-operator/for-strategy' annotation of alert rules.
+// The annotation specifies how the `for` duration of their absence alert rules is derived
+// from the `for` duration of the alert rule itself. If the alert rule does not have a
+// `for` duration then the default is used regardless of the strategy.
+const (
+	// ForStrategyDefault uses the default of the operator (see absenceRuleFor()).
+	ForStrategyDefault = "default"
+	// ForStrategyMax uses the longer one of the default and the `for` duration of the
+	// alert rule.
+	ForStrategyMax = "max"
+	// ForStrategySource uses the `for` duration of the alert rule.
+	ForStrategySource = "source"
+)
+
+// absenceRuleFor returns the duration for the `for` field of an absence alert rule with
+// the given severity. The sourceFor is the `for` duration of the original alert rule,
+// which is used according to the given strategy if it is not nil. The result is raised to
+// MinFor unless it is the duration for the severity (see ForBySeverity).
+func absenceRuleFor(opts ParseOpts, severity, strategy string, sourceFor *time.Duration) time.Duration {
+	d, perSeverity := opts.ForBySeverity[severity]
+	if !perSeverity {
+		d = defaultFor
+	}
+	if sourceFor != nil {
+		switch {
+		case strategy == ForStrategySource:
+			d, perSeverity = *sourceFor, false
+		case strategy == ForStrategyMax && *sourceFor > d:
+			d, perSeverity = *sourceFor, false
+		}
+	}
+	if !perSeverity && d < opts.MinFor {
+		d = opts.MinFor
+	}
+	return d
+}
+
+// absenceRuleGroupInterval returns the evaluation interval of an absence rule group for
+// the interval of its original rule group. The interval is raised to minInterval if it is
+// lower. Rule groups without an interval use the global evaluation interval of
+// Prometheus, which is not known here, therefore nil is returned for them.
+func absenceRuleGroupInterval(in *monitoringv1.Duration, minInterval time.Duration) (*monitoringv1.Duration, error) {
+	if in == nil {
+		return nil, nil
+	}
+	d, err := model.ParseDuration(string(*in))
+	if err != nil {
+		return nil, err
+	}
+	if time.Duration(d) < minInterval {
+		d = model.Duration(minInterval)
+	}
+	result := monitoringv1.Duration(d.String())
+	return &result, nil
+}
+
+// maxSourceExprLen is the maximum number of characters of the original expression that
+// are included in the source expression annotation.
+const maxSourceExprLen = 500
+
+// sanitizeSourceExpr collapses all whitespace (including newlines) in an expression
+// and truncates it to maxSourceExprLen characters.
+func sanitizeSourceExpr(expr string) string {
+	s := []rune(strings.Join(strings.Fields(expr), " "))
+	if len(s) <= maxSourceExprLen {
+		return string(s)
+	}
+	return string(s[:maxSourceExprLen]) + "..."
+}
+
+// ParseSourceURLTemplate parses a template for the 'absent-metrics-operator/source-url'
+// annotation. The template can use the {{.Namespace}} and {{.Name}} of a PrometheusRule.
+// It is rendered once so that invalid templates are detected at startup.
+func ParseSourceURLTemplate(in string) (*template.Template, error) {
+	tmpl, err := template.New("source-url").Option("missingkey=error").Parse(in)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := RenderSourceURL(tmpl, "namespace", "name"); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// RenderSourceURL renders the source URL for the PrometheusRule with the given namespace
+// and name.
+func RenderSourceURL(tmpl *template.Template, namespace, name string) (string, error) {
+	var buf strings.Builder
+	err := tmpl.Execute(&buf, struct{ Namespace, Name string }{namespace, name})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ParseQueryURLTemplate parses a template for the 'absent-metrics-operator/query-url'
+// annotation. The template can use the {{.Metric}} of an absence alert rule, i.e. the
+// argument of its absent() function, and the {{.PrometheusServer}}. Use the urlquery
+// function for query parameters, e.g. {{urlquery .Metric}}. It is rendered once so that
+// invalid templates are detected at startup.
+func ParseQueryURLTemplate(in string) (*template.Template, error) {
+	tmpl, err := template.New("query-url").Option("missingkey=error").Parse(in)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := RenderQueryURL(tmpl, "metric", "prometheus"); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// RenderQueryURL renders the query URL for the given metric of an absence alert rule.
+func RenderQueryURL(tmpl *template.Template, metric, promServer string) (string, error) {
+	var buf strings.Builder
+	err := tmpl.Execute(&buf, struct{ Metric, PrometheusServer string }{metric, promServer})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ParseGroupingLabelTemplate parses a template for the value of the grouping label (see
+// ParseOpts.GroupingLabel). The template can use the {{.PrometheusServer}} and
+// {{.Namespace}} of a PrometheusRule. It is rendered once so that invalid templates are
+// detected at startup.
+func ParseGroupingLabelTemplate(in string) (*template.Template, error) {
+	tmpl, err := template.New("grouping-label").Option("missingkey=error").Parse(in)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := RenderGroupingLabel(tmpl, "prometheus", "namespace"); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// RenderGroupingLabel renders the value of the grouping label for a PrometheusRule with
+// the given Prometheus server and namespace.
+func RenderGroupingLabel(tmpl *template.Template, promServer, namespace string) (string, error) {
+	var buf strings.Builder
+	err := tmpl.Execute(&buf, struct{ PrometheusServer, Namespace string }{promServer, namespace})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
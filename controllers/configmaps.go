@@ -0,0 +1,114 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// getConfigMapData returns the data of a ConfigMap. A nil map is returned if the key is
+// empty or if the ConfigMap does not exist.
+func (r *PrometheusRuleReconciler) getConfigMapData(ctx context.Context, key types.NamespacedName) (map[string]string, error) {
+	if key.Name == "" {
+		return nil, nil
+	}
+
+	var cm corev1.ConfigMap
+	err := r.Get(ctx, key, &cm)
+	switch {
+	case err == nil:
+		return cm.Data, nil
+	case apierrors.IsNotFound(err):
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// namespaceSeverity returns the severity for absence alert rules in a specific namespace
+// as defined in the SeverityConfigMap. An empty string is returned if no severity is
+// defined for the namespace.
+func (r *PrometheusRuleReconciler) namespaceSeverity(ctx context.Context, namespace string) (string, error) {
+	data, err := r.getConfigMapData(ctx, r.SeverityConfigMap)
+	if err != nil {
+		return "", err
+	}
+	return data[namespace], nil
+}
+
+// optionalMetrics returns the names of the metrics that are listed in the
+// OptionalMetricsConfigMap. Each value of the ConfigMap contains one metric name per line.
+func (r *PrometheusRuleReconciler) optionalMetrics(ctx context.Context) (map[string]bool, error) {
+	data, err := r.getConfigMapData(ctx, r.OptionalMetricsConfigMap)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	result := make(map[string]bool)
+	for _, v := range data {
+		for _, line := range strings.Split(v, "\n") {
+			if name := strings.TrimSpace(line); name != "" {
+				result[name] = true
+			}
+		}
+	}
+	return result, nil
+}
+
+// isConfigMap returns a predicate that only accepts the ConfigMaps with the given keys.
+// Keys with an empty name are ignored.
+func isConfigMap(keys ...types.NamespacedName) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		for _, key := range keys {
+			if key.Name != "" && obj.GetNamespace() == key.Namespace && obj.GetName() == key.Name {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// enqueueAllPrometheusRules returns reconcile requests for all PrometheusRules (excluding
+// AbsencePrometheusRules) in the cluster. It is used to process all PrometheusRules again
+// when a ConfigMap that affects the generation of absence alert rules is changed.
+func (r *PrometheusRuleReconciler) enqueueAllPrometheusRules(ctx context.Context, _ client.Object) []reconcile.Request {
+	var promRules monitoringv1.PrometheusRuleList
+	if err := r.List(ctx, &promRules); err != nil {
+		r.Log.Error(err, "could not list PrometheusRules")
+		return nil
+	}
+
+	result := make([]reconcile.Request, 0, len(promRules.Items))
+	for _, pr := range promRules.Items {
+		if r.isManaged(pr) || r.isManagedByOtherInstance(pr) {
+			continue
+		}
+		result = append(result, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: pr.GetNamespace(), Name: pr.GetName()},
+		})
+	}
+	return result
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+)
+
+// Valid values for the DuplicateAlertNames field of the PrometheusRuleReconciler.
+const (
+	// DuplicateAlertNamesWarn logs absence alert rules whose names are used in more than
+	// one AbsenceRuleGroup of an AbsencePrometheusRule.
+	DuplicateAlertNamesWarn = "warn"
+	// DuplicateAlertNamesDisambiguate adds a number to the names of such absence alert
+	// rules, e.g. "AbsentFooBar2", so that each name is unique.
+	DuplicateAlertNamesDisambiguate = "disambiguate"
+)
+
+// handleDuplicateAlertNames looks for absence alert rules in different AbsenceRuleGroups
+// that have the same name and handles them according to DuplicateAlertNames. Prometheus
+// only requires unique names within a rule group but downstream tooling may expect them
+// to be unique across the whole AbsencePrometheusRule.
+//
+// The AbsenceRuleGroups are processed in the order of their names so that the result
+// does not depend on the order in which they were merged. The first occurrence of a name
+// keeps it. A copy is returned if any names are changed.
+func (r *PrometheusRuleReconciler) handleDuplicateAlertNames(
+	log logr.Logger,
+	ruleGroups []monitoringv1.RuleGroup,
+) []monitoringv1.RuleGroup {
+
+	order := make([]int, len(ruleGroups))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return ruleGroups[order[i]].Name < ruleGroups[order[j]].Name
+	})
+
+	used := make(map[string]bool)
+	for _, g := range ruleGroups {
+		for _, rule := range g.Rules {
+			used[rule.Alert] = true
+		}
+	}
+
+	var result []monitoringv1.RuleGroup
+	copied := make(map[int]bool)
+	firstGroup := make(map[string]string)
+	for _, i := range order {
+		g := ruleGroups[i]
+		for j, rule := range g.Rules {
+			if rule.Alert == "" {
+				continue // recording rule
+			}
+			first, exists := firstGroup[rule.Alert]
+			if !exists {
+				firstGroup[rule.Alert] = g.Name
+				continue
+			}
+			if first == g.Name {
+				continue
+			}
+
+			if r.DuplicateAlertNames != DuplicateAlertNamesDisambiguate {
+				log.Info("absence alert rule name is used in multiple rule groups",
+					"alert", rule.Alert, "ruleGroup", g.Name, "firstRuleGroup", first)
+				continue
+			}
+			name := rule.Alert
+			for n := 2; used[name]; n++ {
+				name = rule.Alert + strconv.Itoa(n)
+			}
+			used[name] = true
+			firstGroup[name] = g.Name
+
+			if result == nil {
+				result = make([]monitoringv1.RuleGroup, len(ruleGroups))
+				copy(result, ruleGroups)
+			}
+			// The rules can be shared with the existing AbsenceRuleGroups, therefore we
+			// copy them before the first change.
+			if !copied[i] {
+				result[i].Rules = append([]monitoringv1.Rule(nil), ruleGroups[i].Rules...)
+				copied[i] = true
+			}
+			result[i].Rules[j].Alert = name
+		}
+	}
+	if result == nil {
+		return ruleGroups
+	}
+	return result
+}
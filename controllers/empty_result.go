@@ -0,0 +1,60 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// emptyResultCounter counts the consecutive reconciles of PrometheusRules in which no
+// absence alert rules were generated even though their AbsencePrometheusRule still
+// contains absence alert rules for them. The count is kept per Prometheus server since
+// each server has its own AbsencePrometheusRule. The zero value is ready to use.
+type emptyResultCounter struct {
+	mu     sync.Mutex
+	counts map[emptyResultKey]int
+}
+
+type emptyResultKey struct {
+	promRule   types.NamespacedName
+	promServer string
+}
+
+// inc increments the count for a PrometheusRule and Prometheus server and returns the
+// new count.
+func (c *emptyResultCounter) inc(key types.NamespacedName, promServer string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[emptyResultKey]int)
+	}
+	k := emptyResultKey{promRule: key, promServer: promServer}
+	c.counts[k]++
+	return c.counts[k]
+}
+
+// reset forgets the count for a PrometheusRule and Prometheus server. If promServer is
+// empty then the counts for all Prometheus servers are forgotten.
+func (c *emptyResultCounter) reset(key types.NamespacedName, promServer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.counts {
+		if k.promRule == key && (promServer == "" || k.promServer == promServer) {
+			delete(c.counts, k)
+		}
+	}
+}
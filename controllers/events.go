@@ -0,0 +1,102 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// rateLimitedRecorder is a record.EventRecorder that drops events which are identical
+// to an event that was recorded for the same object within the last interval.
+type rateLimitedRecorder struct {
+	recorder record.EventRecorder
+	interval time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewRateLimitedRecorder wraps an EventRecorder so that identical events for the same
+// object are recorded at most once per interval. This avoids spamming the API server
+// with events if a resource fails to reconcile repeatedly.
+func NewRateLimitedRecorder(recorder record.EventRecorder, interval time.Duration) record.EventRecorder {
+	return &rateLimitedRecorder{
+		recorder: recorder,
+		interval: interval,
+		now:      time.Now,
+		seen:     make(map[string]time.Time),
+	}
+}
+
+// Event implements the record.EventRecorder interface.
+func (rl *rateLimitedRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if rl.allow(object, eventtype, reason, message) {
+		rl.recorder.Event(object, eventtype, reason, message)
+	}
+}
+
+// Eventf implements the record.EventRecorder interface.
+func (rl *rateLimitedRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...any) {
+	rl.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// AnnotatedEventf implements the record.EventRecorder interface.
+func (rl *rateLimitedRecorder) AnnotatedEventf(
+	object runtime.Object,
+	annotations map[string]string,
+	eventtype, reason, messageFmt string,
+	args ...any,
+) {
+
+	message := fmt.Sprintf(messageFmt, args...)
+	if rl.allow(object, eventtype, reason, message) {
+		rl.recorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", message)
+	}
+}
+
+// allow reports whether an event should be recorded and remembers it if so.
+func (rl *rateLimitedRecorder) allow(object runtime.Object, eventtype, reason, message string) bool {
+	objKey := "unknown"
+	if m, err := meta.Accessor(object); err == nil {
+		objKey = fmt.Sprintf("%s/%s/%s", m.GetNamespace(), m.GetName(), m.GetUID())
+	}
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%s", objKey, eventtype, reason, message)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	if last, ok := rl.seen[key]; ok && now.Sub(last) < rl.interval {
+		return false
+	}
+	rl.seen[key] = now
+
+	// Forget events that are older than the interval so that the map does not grow
+	// indefinitely.
+	for k, t := range rl.seen {
+		if now.Sub(t) >= rl.interval {
+			delete(rl.seen, k)
+		}
+	}
+	return true
+}
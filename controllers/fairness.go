@@ -0,0 +1,66 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// NamespaceRateLimiter limits the rate of reconciles per namespace. All resources share
+// the same workqueue, therefore a namespace with many PrometheusRules could otherwise
+// delay the reconciles of all other namespaces.
+type NamespaceRateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewNamespaceRateLimiter returns a NamespaceRateLimiter that allows limit reconciles per
+// second and bursts of up to burst reconciles for each namespace.
+func NewNamespaceRateLimiter(limit float64, burst int) *NamespaceRateLimiter {
+	return &NamespaceRateLimiter{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// Delay returns the time until the next reconcile in the given namespace is allowed. If
+// it is zero, the reconcile is allowed right away and counted towards the limit of the
+// namespace.
+func (nl *NamespaceRateLimiter) Delay(namespace string) time.Duration {
+	nl.mu.Lock()
+	l, ok := nl.limiters[namespace]
+	if !ok {
+		l = rate.NewLimiter(nl.limit, nl.burst)
+		nl.limiters[namespace] = l
+	}
+	nl.mu.Unlock()
+
+	res := l.Reserve()
+	d := res.Delay()
+	if d > 0 {
+		// The reconcile is requeued instead of waiting, so that the workers are free for
+		// the reconciles of other namespaces in the meantime. The token is returned so
+		// that the requeued reconcile does not count twice.
+		res.Cancel()
+	}
+	return d
+}
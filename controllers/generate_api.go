@@ -0,0 +1,119 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+)
+
+// maxGenerateRequestSize is the maximum size of the body of a request to the handler
+// that is returned by GenerateHandler().
+const maxGenerateRequestSize = 1 << 20
+
+// GenerateAbsencePrometheusRule returns the AbsencePrometheusRule that contains the
+// absence alert rules for a single PrometheusRule without accessing the cluster.
+// Therefore the defaults for labels are only determined from the PrometheusRule itself
+// and the SeverityConfigMap and OptionalMetricsConfigMap are not taken into account.
+// Likewise, only the hand-written absence alert rules of the PrometheusRule itself are
+// considered for SkipHandWrittenAbsence. If the PrometheusRule concerns multiple
+// Prometheus servers (see PrometheusServerDelimiter), the AbsencePrometheusRule is
+// generated for the first one.
+func (r *PrometheusRuleReconciler) GenerateAbsencePrometheusRule(
+	promRule *monitoringv1.PrometheusRule,
+) (*monitoringv1.PrometheusRule, error) {
+
+	r.optsMu.RLock()
+	defer r.optsMu.RUnlock()
+
+	promServers := r.prometheusServers(promRule)
+	if len(promServers) == 0 {
+		return nil, fmt.Errorf("no '%s' label found", r.prometheusServerLabelKey())
+	}
+	promServer := promServers[0]
+	absencePromRule := r.newAbsencePrometheusRule(promRule.GetNamespace(), promServer)
+	if err := r.updateTypeLabel(absencePromRule, promRule); err != nil {
+		return nil, err
+	}
+
+	labelOpts := LabelOpts{Keep: r.KeepLabel}
+	if keepCCloudLabels(labelOpts.Keep) {
+		var err error
+		labelOpts, err = r.labelOptsWithCCloudDefaults(context.Background(), promRule, false)
+		if err != nil {
+			return nil, err
+		}
+		updateCCloudLabels(absencePromRule, labelOpts)
+	}
+
+	var handWritten map[string]bool
+	if r.SkipHandWrittenAbsence {
+		handWritten = r.handWrittenAbsenceKeys([]*monitoringv1.PrometheusRule{promRule})
+	}
+	absenceRuleGroups, err := r.parseRuleGroups(r.Log, promRule, promServer, labelOpts, "", nil, handWritten)
+	if err != nil {
+		return nil, err
+	}
+	absencePromRule.Spec.Groups = absenceRuleGroups
+	if err := r.updateRuleGroupSources(absencePromRule, promRule, absenceRuleGroups); err != nil {
+		return nil, err
+	}
+	if r.HashRuleGroups {
+		hashes, err := computeRuleGroupHashes(absenceRuleGroups)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.updateRuleGroupHashes(absencePromRule, hashes); err != nil {
+			return nil, err
+		}
+	}
+	r.sortRuleGroups(absencePromRule)
+	return r.withWatchdogRuleGroup(r.withMissingMetricsRuleGroup(absencePromRule)), nil
+}
+
+// GenerateHandler returns an http.Handler that accepts a PrometheusRule as JSON in the
+// body of a POST request and responds with the corresponding AbsencePrometheusRule as
+// JSON (see GenerateAbsencePrometheusRule()). This allows checking the generated
+// absence alert rules without access to the cluster, e.g. in CI.
+func (r *PrometheusRuleReconciler) GenerateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var promRule monitoringv1.PrometheusRule
+		dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxGenerateRequestSize))
+		if err := dec.Decode(&promRule); err != nil {
+			http.Error(w, "could not decode PrometheusRule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		absencePromRule, err := r.GenerateAbsencePrometheusRule(&promRule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(absencePromRule); err != nil {
+			r.Log.Error(err, "could not write response")
+		}
+	})
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// handWrittenAbsence returns the keys (see selectorKey()) of the time series that
+// already have hand-written absence alert rules in the PrometheusRules of a Prometheus
+// server in a namespace (see handWrittenAbsenceKeys()). It returns nil unless
+// SkipHandWrittenAbsence is set.
+func (r *PrometheusRuleReconciler) handWrittenAbsence(
+	ctx context.Context,
+	namespace, promServer string,
+) (map[string]bool, error) {
+
+	if !r.SkipHandWrittenAbsence {
+		return nil, nil
+	}
+	promRules, err := r.listPrometheusRules(ctx, namespace, promServer)
+	if err != nil {
+		return nil, err
+	}
+	return r.handWrittenAbsenceKeys(promRules), nil
+}
+
+// handWrittenAbsenceKeys returns the keys (see selectorKey()) of the time series that
+// are checked by hand-written absence alert rules in the given PrometheusRules, i.e. by
+// alert rules whose expression only consists of absent functions, e.g.
+// absent(foo{job="bar"}). AbsencePrometheusRules are skipped.
+func (r *PrometheusRuleReconciler) handWrittenAbsenceKeys(promRules []*monitoringv1.PrometheusRule) map[string]bool {
+	result := make(map[string]bool)
+	for _, pr := range promRules {
+		if r.isManaged(pr) || r.isManagedByOtherInstance(pr) {
+			continue
+		}
+		for _, g := range pr.Spec.Groups {
+			for _, rule := range g.Rules {
+				if rule.Alert == "" {
+					continue // recording rule
+				}
+				mex := &metricNameExtractor{logger: r.Log, expr: rule.Expr.String()}
+				expr, err := parser.ParseExpr(mex.expr)
+				if err != nil {
+					continue // reported when the PrometheusRule itself is reconciled
+				}
+				addAbsenceAlertSelectorKeys(mex, expr, result)
+			}
+		}
+	}
+	return result
+}
+
+// addAbsenceAlertSelectorKeys adds the keys (see selectorKey()) of the arguments of the
+// absent functions in an expression to the result if the expression only consists of
+// absent functions that are combined with "or". Other expressions that use absent
+// functions, e.g. "foo == 0 unless absent(foo)", are not absence alert rules.
+func addAbsenceAlertSelectorKeys(mex *metricNameExtractor, expr parser.Expr, result map[string]bool) {
+	keys := make(map[string]bool)
+	var visit func(e parser.Expr) bool
+	visit = func(e parser.Expr) bool {
+		switch e := unwrapParenExpr(e).(type) {
+		case *parser.BinaryExpr:
+			return e.Op == parser.LOR && visit(e.LHS) && visit(e.RHS)
+		case *parser.Call:
+			if !absentFuncs[e.Func.Name] || len(e.Args) != 1 {
+				return false
+			}
+			arg := unwrapParenExpr(e.Args[0])
+			if ms, ok := arg.(*parser.MatrixSelector); ok {
+				arg = ms.VectorSelector
+			}
+			vs, ok := arg.(*parser.VectorSelector)
+			if !ok {
+				return false
+			}
+			name := mex.metricName(vs)
+			if name == "" {
+				return false
+			}
+			keys[selectorKey(name, vs)] = true
+			return true
+		default:
+			return false
+		}
+	}
+	if visit(expr) {
+		for k := range keys {
+			result[k] = true
+		}
+	}
+}
@@ -16,10 +16,11 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
-	"sigs.k8s.io/controller-runtime/pkg/client"
+	"github.com/prometheus/common/model"
 )
 
 // These constants are exported for reusability across packages.
@@ -32,14 +33,64 @@ const (
 	LabelService      = "service"
 )
 
+// The names of the annotations that are managed by the operator. They are prefixed with
+// the annotation prefix (see annotationKey()).
 const (
-	annotationOperatorUpdatedAt = "absent-metrics-operator/updated-at"
+	annotationOperatorUpdatedAt  = "updated-at"
+	annotationSourceExpr         = "source-expr"
+	annotationSourceGroup        = "source-group"
+	annotationRuleGroupSources   = "rule-group-sources"
+	annotationSourceURL          = "source-url"
+	annotationQueryURL           = "query-url"
+	annotationGeneratedByVersion = "generated-by-version"
+	annotationRuleGroupHashes    = "rule-group-hashes"
+	annotationOperatorOwned      = "owned"
+	annotationWatchdog           = "watchdog"
+	annotationMissingMetrics     = "missing-metrics"
+	// annotationEscalateAfter is set on alert rules by users, see
+	// ParseOpts.EscalationSeverity.
+	annotationEscalateAfter = "escalate-after"
+	// annotationForStrategy is set on alert rules by users, see ForStrategyDefault,
+	// ForStrategyMax, and ForStrategySource.
+	annotationForStrategy = "for-strategy"
+)
+
+// DefaultAnnotationPrefix is the prefix of the keys of the annotations that are managed
+// by the operator.
+const DefaultAnnotationPrefix = "absent-metrics-operator"
 
+// DefaultPrometheusServerLabelKey is the key of the label that specifies the Prometheus
+// server of a PrometheusRule.
+const DefaultPrometheusServerLabelKey = "prometheus"
+
+const (
 	labelOperatorManagedBy = "absent-metrics-operator/managed-by"
 	labelOperatorDisable   = "absent-metrics-operator/disable"
+	labelAlertNamePrefix   = "absent-metrics-operator/alert-name-prefix"
 
 	labelNoAlertOnAbsence = "no_alert_on_absence"
-	labelPrometheusServer = "prometheus"
+	labelType             = "type"
+	labelOriginalSeverity = "original_severity"
+)
+
+// disableValueFreeze is the value of the 'absent-metrics-operator/disable' label that
+// freezes the absence alert rules of a PrometheusRule: the existing ones are kept as is
+// but no longer updated. The value "true" removes them instead.
+const disableValueFreeze = "freeze"
+
+// annotationKey returns the key of an annotation that is managed by the operator. The
+// DefaultAnnotationPrefix is used if the prefix is empty.
+func annotationKey(prefix, name string) string {
+	if prefix == "" {
+		prefix = DefaultAnnotationPrefix
+	}
+	return prefix + "/" + name
+}
+
+// Valid values for the LabelPrecedence field of PrometheusRuleReconciler.
+const (
+	LabelPrecedenceResource  = "resource"
+	LabelPrecedenceInference = "inference"
 )
 
 // LabelOpts holds the options that define labels for an absence alert rule.
@@ -54,40 +105,127 @@ type LabelOpts struct {
 // KeepLabel specifies which labels to keep on an absence alert rule.
 type KeepLabel map[string]bool
 
+// ParseKeepLabel parses a comma-separated list of label names into a KeepLabel. It
+// returns an error for invalid label names and for labels that are always set by the
+// operator.
+//
+// Label names are not validated against a list of known labels since any label of the
+// original alert rules can be retained, e.g. 'instance' or 'customer'. Therefore a
+// misspelled label like 'tierr' is accepted and is simply not found on the alert rules.
+func ParseKeepLabel(in string) (KeepLabel, error) {
+	keep := make(KeepLabel)
+	for _, v := range strings.Split(in, ",") {
+		k := strings.TrimSpace(v)
+		if !model.LabelNameRE.MatchString(k) {
+			return nil, fmt.Errorf("invalid label name: %q", k)
+		}
+		if k == "context" {
+			return nil, fmt.Errorf("label %q is set by the operator and can not be retained", k)
+		}
+		keep[k] = true
+	}
+	return keep, nil
+}
+
+// ValidateRenameLabels checks that the new names in a label rename map (see
+// ParseOpts.RenameLabels) are valid Prometheus label names.
+func ValidateRenameLabels(rename map[string]string) error {
+	for oldName, newName := range rename {
+		if !model.LabelNameRE.MatchString(newName) {
+			return fmt.Errorf("invalid label name for label %q: %q", oldName, newName)
+		}
+	}
+	return nil
+}
+
 func keepCCloudLabels(keep KeepLabel) bool {
 	return keep[LabelSupportGroup] && keep[LabelTier] && keep[LabelService]
 }
 
 // defaultSupportGroupAndServiceLabels finds defaults for support group and service labels for an
-// AbsencePrometheusRule and returns the corresponding LabelOpts.
+// AbsencePrometheusRule and returns the corresponding LabelOpts. The other
+// PrometheusRules in the namespace are only considered if listNamespace is true.
+//
+// The labels of the PrometheusRule take precedence over the labels that are inferred from
+// alert rules unless LabelPrecedence is LabelPrecedenceInference.
 func (r *PrometheusRuleReconciler) labelOptsWithCCloudDefaults(
 	ctx context.Context,
 	promRule *monitoringv1.PrometheusRule,
+	listNamespace bool,
 ) (LabelOpts, error) {
 
-	opts := LabelOpts{Keep: r.KeepLabel}
-
-	newIfCurrentEmpty := func(currentVal, newVal string) string {
-		if currentVal != "" {
-			return currentVal
-		}
-		return newVal
-	}
-	foundLabels := func() bool {
-		return opts.DefaultSupportGroup != "" && opts.DefaultService != "" && opts.DefaultTier != ""
-	}
-
 	// Strategy 1: check if the PrometheusRule already has the required labels.
+	opts := LabelOpts{Keep: r.KeepLabel}
 	l := promRule.GetLabels()
 	opts.DefaultSupportGroup = l[LabelCCloudSupportGroup]
 	opts.DefaultService = l[LabelCCloudService]
 	// Try old CCloud service label naming.
 	opts.DefaultService = newIfCurrentEmpty(opts.DefaultService, l[LabelService])
 	opts.DefaultTier = l[LabelTier]
-	if foundLabels() {
-		return opts, nil
+	if r.DisableLabelInference {
+		return r.allowedLabelOpts(opts), nil
 	}
 
+	if r.LabelPrecedence == LabelPrecedenceInference {
+		// The labels of the PrometheusRule are only used for the labels that could not be
+		// inferred.
+		inferred, err := r.inferLabelOpts(ctx, promRule, LabelOpts{Keep: r.KeepLabel}, listNamespace)
+		if err != nil {
+			return opts, err
+		}
+		inferred.DefaultSupportGroup = newIfCurrentEmpty(inferred.DefaultSupportGroup, opts.DefaultSupportGroup)
+		inferred.DefaultService = newIfCurrentEmpty(inferred.DefaultService, opts.DefaultService)
+		inferred.DefaultTier = newIfCurrentEmpty(inferred.DefaultTier, opts.DefaultTier)
+		return r.allowedLabelOpts(inferred), nil
+	}
+
+	if hasAllDefaults(opts) {
+		return r.allowedLabelOpts(opts), nil
+	}
+	opts, err := r.inferLabelOpts(ctx, promRule, opts, listNamespace)
+	return r.allowedLabelOpts(opts), err
+}
+
+// allowedLabelOpts removes the defaults for labels that may not be carried over (see
+// ParseOpts.AllowedLabels) from the given LabelOpts.
+func (r *PrometheusRuleReconciler) allowedLabelOpts(opts LabelOpts) LabelOpts {
+	allowed := r.ParseOpts.AllowedLabels
+	if len(allowed) == 0 {
+		return opts
+	}
+	if !allowed[LabelSupportGroup] {
+		opts.DefaultSupportGroup = ""
+	}
+	if !allowed[LabelTier] {
+		opts.DefaultTier = ""
+	}
+	if !allowed[LabelService] {
+		opts.DefaultService = ""
+	}
+	return opts
+}
+
+// existingLabelOpts fills the empty defaults of the given LabelOpts with the labels of an
+// existing AbsencePrometheusRule (see RetainExistingLabels).
+func (r *PrometheusRuleReconciler) existingLabelOpts(opts LabelOpts, absencePromRule *monitoringv1.PrometheusRule) LabelOpts {
+	l := absencePromRule.GetLabels()
+	opts.DefaultSupportGroup = newIfCurrentEmpty(opts.DefaultSupportGroup, l[LabelCCloudSupportGroup])
+	opts.DefaultService = newIfCurrentEmpty(opts.DefaultService, l[LabelCCloudService])
+	opts.DefaultService = newIfCurrentEmpty(opts.DefaultService, l[LabelService])
+	opts.DefaultTier = newIfCurrentEmpty(opts.DefaultTier, l[LabelTier])
+	return r.allowedLabelOpts(opts)
+}
+
+// inferLabelOpts fills the empty defaults of the given LabelOpts with the labels that are
+// most common in the alert rules of a PrometheusRule and, if listNamespace is true, of the
+// other PrometheusRules in its namespace.
+func (r *PrometheusRuleReconciler) inferLabelOpts(
+	ctx context.Context,
+	promRule *monitoringv1.PrometheusRule,
+	opts LabelOpts,
+	listNamespace bool,
+) (LabelOpts, error) {
+
 	// Strategy 2: iterate through all the alert rule definitions.
 	if len(promRule.Spec.Groups) > 0 {
 		sg, s := mostCommonSupportGroupAndServiceCombo(promRule.Spec.Groups)
@@ -98,26 +236,33 @@ func (r *PrometheusRuleReconciler) labelOptsWithCCloudDefaults(
 		opts.DefaultTier = newIfCurrentEmpty(opts.DefaultTier, t)
 		opts.DefaultService = newIfCurrentEmpty(opts.DefaultService, s)
 
-		if foundLabels() {
+		if hasAllDefaults(opts) {
 			return opts, nil
 		}
 	}
+	if !listNamespace {
+		return opts, nil
+	}
 
 	// Strategy 3: iterate through all the alert rule definitions for the concerning
-	// Prometheus server in this specific namespace.
-	var listOpts client.ListOptions
-	client.InNamespace(promRule.GetNamespace()).ApplyToList(&listOpts)
-	client.MatchingLabels{labelPrometheusServer: l[labelPrometheusServer]}.ApplyToList(&listOpts)
-	var promRules monitoringv1.PrometheusRuleList
-	if err := r.List(ctx, &promRules, &listOpts); err != nil {
-		return opts, err
-	}
+	// Prometheus servers in this specific namespace.
 	var rg []monitoringv1.RuleGroup
-	for _, pr := range promRules.Items {
-		if _, ok := pr.Labels[labelOperatorManagedBy]; ok {
-			continue // skip absence alert rules
+	seen := make(map[string]bool)
+	for _, promServer := range r.prometheusServers(promRule) {
+		promRules, err := r.listPrometheusRules(ctx, promRule.GetNamespace(), promServer)
+		if err != nil {
+			return opts, err
+		}
+		for _, pr := range promRules {
+			if _, ok := pr.Labels[r.managedByLabel()]; ok {
+				continue // skip absence alert rules
+			}
+			if seen[pr.GetName()] {
+				continue // PrometheusRule for multiple Prometheus servers
+			}
+			seen[pr.GetName()] = true
+			rg = append(rg, pr.Spec.Groups...)
 		}
-		rg = append(rg, pr.Spec.Groups...)
 	}
 
 	sg, s := mostCommonSupportGroupAndServiceCombo(rg)
@@ -132,6 +277,19 @@ func (r *PrometheusRuleReconciler) labelOptsWithCCloudDefaults(
 	return opts, nil
 }
 
+func newIfCurrentEmpty(currentVal, newVal string) string {
+	if currentVal != "" {
+		return currentVal
+	}
+	return newVal
+}
+
+// hasAllDefaults reports whether defaults for all the support group, tier, and service
+// labels were found.
+func hasAllDefaults(opts LabelOpts) bool {
+	return opts.DefaultSupportGroup != "" && opts.DefaultService != "" && opts.DefaultTier != ""
+}
+
 //nolint:dupl
 func mostCommonSupportGroupAndServiceCombo(ruleGroups []monitoringv1.RuleGroup) (supportGroup, service string) {
 	// Map of support group to service to number of occurrences.
@@ -15,6 +15,8 @@
 package controllers
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
@@ -27,18 +29,102 @@ var IsTest bool
 // If IsTest is true then it will also return a *prometheus.Registry than can be used in
 // the test suite otherwise nil is returned.
 func RegisterMetrics() *prometheus.Registry {
+	// The values of counters depend on how often the resources are reconciled. They are
+	// not deterministic and therefore not part of the registry of the test suite.
+	metrics.Registry.MustRegister(rulesProcessed, rulesWithoutMetrics, coverageDropped, groupsMerged, rbacErrors,
+		truncatedSources, apiServerWriteDuration, absenceRulesDeleted)
+
 	if IsTest {
 		// We don't use `controllers.RegisterMetrics()` here as that will also include
 		// metrics related to the controller which will make testing with fixtures
 		// difficult.
 		reg := prometheus.NewPedanticRegistry()
-		reg.MustRegister(successfulReconcileTime)
+		reg.MustRegister(successfulReconcileTime, lastReconcileTimestamp, disabledRules)
 		return reg
 	}
-	metrics.Registry.MustRegister(successfulReconcileTime)
+	metrics.Registry.MustRegister(successfulReconcileTime, lastReconcileTimestamp, disabledRules)
 	return nil
 }
 
+var rulesProcessed = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "absent_metrics_operator_rules_processed_total",
+		Help: "The number of alert rules in a namespace that were processed by the operator.",
+	},
+	[]string{"namespace"},
+)
+
+var rulesWithoutMetrics = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "absent_metrics_operator_rules_without_metrics_total",
+		Help: "The number of alert rules in a namespace whose expression does not reference any time series.",
+	},
+	[]string{"namespace"},
+)
+
+var coverageDropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "absent_metrics_operator_coverage_dropped_total",
+		Help: "The number of times that a PrometheusRule stopped generating any absence alert rules.",
+	},
+	[]string{"namespace", "name"},
+)
+
+// Values of the 'action' label of the groupsMerged counter.
+const (
+	mergeActionCarriedOver = "carried_over"
+	mergeActionUpdated     = "updated"
+	mergeActionAdded       = "added"
+)
+
+var groupsMerged = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "absent_metrics_operator_groups_merged_total",
+		Help: "The number of AbsenceRuleGroups that were carried over unchanged, updated, or added when merging them into an existing AbsencePrometheusRule.",
+	},
+	[]string{"action"},
+)
+
+var rbacErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "absent_metrics_operator_rbac_errors_total",
+		Help: "The number of requests in a namespace that failed because the operator is missing RBAC permissions.",
+	},
+	[]string{"namespace"},
+)
+
+var truncatedSources = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "absent_metrics_operator_truncated_sources_total",
+		Help: "The number of times that absence alert rules for a PrometheusRule were dropped because they exceeded the maximum number of rules.",
+	},
+	[]string{"namespace", "name"},
+)
+
+var absenceRulesDeleted = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "absent_metrics_operator_absence_rules_deleted_total",
+		Help: "The number of absence alert rules in a namespace that were deleted by the clean up.",
+	},
+	[]string{"namespace"},
+)
+
+// Values of the 'verb' label of the apiServerWriteDuration histogram.
+const (
+	writeVerbCreate = "create"
+	writeVerbPatch  = "patch"
+	writeVerbDelete = "delete"
+)
+
+var apiServerWriteDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "absent_metrics_operator_apiserver_write_duration_seconds",
+		Help:    "The duration of requests to the API server that create, patch, or delete AbsencePrometheusRules.",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"verb"},
+)
+
 var successfulReconcileTime = prometheus.NewGaugeVec(
 	prometheus.GaugeOpts{
 		Name: "absent_metrics_operator_successful_reconcile_time",
@@ -47,15 +133,69 @@ var successfulReconcileTime = prometheus.NewGaugeVec(
 	[]string{"prometheusrule_namespace", "prometheusrule_name"},
 )
 
+var lastReconcileTimestamp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "absent_metrics_operator_last_reconcile_timestamp",
+		Help: "The time at which a PrometheusRule in a specific namespace was last successfully reconciled by the operator.",
+	},
+	[]string{"namespace"},
+)
+
 func setReconcileGauge(key types.NamespacedName) {
 	gauge := successfulReconcileTime.WithLabelValues(key.Namespace, key.Name)
+	nsGauge := lastReconcileTimestamp.WithLabelValues(key.Namespace)
 	if IsTest {
 		gauge.Set(1)
+		nsGauge.Set(1)
 	} else {
 		gauge.SetToCurrentTime()
+		nsGauge.SetToCurrentTime()
 	}
 }
 
 func deleteReconcileGauge(key types.NamespacedName) {
 	successfulReconcileTime.DeleteLabelValues(key.Namespace, key.Name)
 }
+
+var disabledRules = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "absent_metrics_operator_disabled_rules",
+		Help: "The number of PrometheusRules in a namespace for which the operator has been disabled.",
+	},
+	[]string{"namespace"},
+)
+
+// disabledRulesTracker keeps track of the PrometheusRules that have the
+// 'absent-metrics-operator/disable' label so that the disabledRules gauge can be
+// computed per namespace.
+var disabledRulesTracker = struct {
+	sync.Mutex
+	rules map[string]map[string]bool // namespace -> PrometheusRule name -> true
+}{
+	rules: make(map[string]map[string]bool),
+}
+
+// setDisabledRuleGauge records whether the operator is disabled for a specific
+// PrometheusRule and updates the disabledRules gauge for its namespace accordingly.
+func setDisabledRuleGauge(key types.NamespacedName, disabled bool) {
+	disabledRulesTracker.Lock()
+	defer disabledRulesTracker.Unlock()
+
+	nsRules := disabledRulesTracker.rules[key.Namespace]
+	if disabled {
+		if nsRules == nil {
+			nsRules = make(map[string]bool)
+			disabledRulesTracker.rules[key.Namespace] = nsRules
+		}
+		nsRules[key.Name] = true
+	} else {
+		delete(nsRules, key.Name)
+	}
+
+	if len(nsRules) == 0 {
+		delete(disabledRulesTracker.rules, key.Namespace)
+		disabledRules.DeleteLabelValues(key.Namespace)
+		return
+	}
+	disabledRules.WithLabelValues(key.Namespace).Set(float64(len(nsRules)))
+}
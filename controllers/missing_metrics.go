@@ -0,0 +1,140 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// missingMetricsRuleGroupName is the name of the rule group that holds the missing
+// metrics recording rule (see RecordMissingMetrics). Like the watchdog rule group, it
+// does not belong to any PrometheusRule.
+const missingMetricsRuleGroupName = "absent-metrics-operator-missing-metrics"
+
+// missingMetricsRecord is the name of the recording rule that counts the metrics of an
+// AbsencePrometheusRule that are currently missing.
+const missingMetricsRecord = "absence:missing_metrics:count"
+
+// labelAbsencePrometheusRule is the label of the missing metrics recording rule that
+// identifies its AbsencePrometheusRule so that the recorded series of different
+// AbsencePrometheusRules do not collide.
+const labelAbsencePrometheusRule = "absence_prometheus_rule"
+
+// hasMissingMetricsRuleGroup reports whether the missing metrics rule group is written
+// for an AbsencePrometheusRule, i.e. whether RecordMissingMetrics is set and the
+// AbsencePrometheusRule has any absence rule groups.
+func (r *PrometheusRuleReconciler) hasMissingMetricsRuleGroup(absencePromRule *monitoringv1.PrometheusRule) bool {
+	return r.RecordMissingMetrics && len(absencePromRule.Spec.Groups) > 0
+}
+
+// updateMissingMetricsAnnotation records the name of the missing metrics recording rule
+// that is written for an AbsencePrometheusRule in the
+// 'absent-metrics-operator/missing-metrics' annotation. Like for the watchdog rule group
+// (see updateWatchdogAnnotation()), the annotation reveals that the recording rule was
+// enabled or disabled since the AbsencePrometheusRule was last written.
+func (r *PrometheusRuleReconciler) updateMissingMetricsAnnotation(absencePromRule *monitoringv1.PrometheusRule) {
+	key := r.annotationKey(annotationMissingMetrics)
+	if !r.hasMissingMetricsRuleGroup(absencePromRule) {
+		delete(absencePromRule.Annotations, key)
+		return
+	}
+	if absencePromRule.Annotations == nil {
+		absencePromRule.Annotations = make(map[string]string)
+	}
+	absencePromRule.Annotations[key] = missingMetricsRecord
+}
+
+// withMissingMetricsRuleGroup returns the AbsencePrometheusRule that is written for the
+// given one: a copy with the 'absent-metrics-operator/missing-metrics' annotation (see
+// updateMissingMetricsAnnotation()) and, if RecordMissingMetrics is set and the
+// AbsencePrometheusRule has any absence rule groups, the missing metrics rule group.
+func (r *PrometheusRuleReconciler) withMissingMetricsRuleGroup(absencePromRule *monitoringv1.PrometheusRule) *monitoringv1.PrometheusRule {
+	out := absencePromRule.DeepCopy()
+	r.updateMissingMetricsAnnotation(out)
+	if r.hasMissingMetricsRuleGroup(absencePromRule) {
+		out.Spec.Groups = append(out.Spec.Groups, missingMetricsRuleGroup(absencePromRule))
+	}
+	return out
+}
+
+// withStoredMissingMetricsRuleGroup is the reverse of withoutMissingMetricsRuleGroup()
+// for an AbsencePrometheusRule that was read from the cluster: it returns a copy with the
+// missing metrics rule group if it was last written according to the
+// 'absent-metrics-operator/missing-metrics' annotation. This is used as the base of
+// patches so that removing the missing metrics rule group is part of the patch.
+func (r *PrometheusRuleReconciler) withStoredMissingMetricsRuleGroup(absencePromRule *monitoringv1.PrometheusRule) *monitoringv1.PrometheusRule {
+	if absencePromRule.GetAnnotations()[r.annotationKey(annotationMissingMetrics)] == "" ||
+		len(absencePromRule.Spec.Groups) == 0 {
+		return absencePromRule
+	}
+	out := absencePromRule.DeepCopy()
+	out.Spec.Groups = append(out.Spec.Groups, missingMetricsRuleGroup(absencePromRule))
+	return out
+}
+
+// missingMetricsRuleGroup returns the rule group with the recording rule that counts how
+// many of the metrics that the absence alert rules of an AbsencePrometheusRule check for
+// are currently missing, e.g. for dashboards.
+func missingMetricsRuleGroup(absencePromRule *monitoringv1.PrometheusRule) monitoringv1.RuleGroup {
+	// Each absent() expression contributes 1 if the metric is missing and 0 otherwise.
+	// The same expression can be used by multiple absence alert rules (e.g. escalated
+	// ones or the same metric in different rule groups) but is only counted once.
+	seen := make(map[string]bool)
+	var exprs []string
+	for _, g := range absencePromRule.Spec.Groups {
+		if g.Name == watchdogRuleGroupName {
+			continue
+		}
+		for _, r := range g.Rules {
+			e := r.Expr.String()
+			if r.Alert == "" || seen[e] {
+				continue
+			}
+			seen[e] = true
+			exprs = append(exprs, fmt.Sprintf("sum(%s or vector(0))", e))
+		}
+	}
+	sort.Strings(exprs)
+
+	return monitoringv1.RuleGroup{
+		Name: missingMetricsRuleGroupName,
+		Rules: []monitoringv1.Rule{{
+			Record: missingMetricsRecord,
+			Expr:   intstr.FromString(strings.Join(exprs, " + ")),
+			Labels: map[string]string{
+				labelAbsencePrometheusRule: fmt.Sprintf("%s/%s", absencePromRule.GetNamespace(), absencePromRule.GetName()),
+			},
+		}},
+	}
+}
+
+// withoutMissingMetricsRuleGroup removes the missing metrics rule group from an
+// AbsencePrometheusRule that was read from the cluster. The rule group is only added when
+// the AbsencePrometheusRule is written (see withMissingMetricsRuleGroup()) so that it
+// always reflects the current absence alert rules.
+func withoutMissingMetricsRuleGroup(absencePromRule *monitoringv1.PrometheusRule) {
+	groups := absencePromRule.Spec.Groups[:0]
+	for _, g := range absencePromRule.Spec.Groups {
+		if g.Name != missingMetricsRuleGroupName {
+			groups = append(groups, g)
+		}
+	}
+	absencePromRule.Spec.Groups = groups
+}
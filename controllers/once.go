@@ -0,0 +1,56 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ReconcileAll reconciles all PrometheusRules in the cluster once and cleans up the
+// AbsencePrometheusRules of this operator instance afterwards (see SweepOrphans()). This
+// allows running the operator as a Job instead of a long-running Deployment.
+//
+// It returns the number of PrometheusRules that were reconciled. Unlike Reconcile(),
+// errors are not absorbed: the returned error joins the errors of all PrometheusRules that
+// could not be reconciled.
+func (r *PrometheusRuleReconciler) ReconcileAll(ctx context.Context) (int, error) {
+	var promRules monitoringv1.PrometheusRuleList
+	if err := r.List(ctx, &promRules); err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	count := 0
+	for _, pr := range promRules.Items {
+		if r.isManaged(pr) || r.isManagedByOtherInstance(pr) {
+			continue
+		}
+		key := types.NamespacedName{Namespace: pr.GetNamespace(), Name: pr.GetName()}
+		count++
+		if err := r.reconcileObject(ctx, key, pr); err != nil {
+			r.recordForbiddenError(key.Namespace, err)
+			errs = append(errs, fmt.Errorf("could not reconcile %s: %w", key.String(), err))
+		}
+	}
+	if err := r.SweepOrphans(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return count, errors.Join(errs...)
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=create;update;patch;delete
+
+// Valid values for the Output field of the PrometheusRuleReconciler.
+const (
+	// OutputPrometheusRule writes the absence alert rules into AbsencePrometheusRules.
+	OutputPrometheusRule = "prometheusrule"
+	// OutputConfigMap writes the absence alert rules into ConfigMaps instead, for
+	// Prometheus setups that load their rule files from ConfigMaps (e.g. using a sidecar)
+	// rather than from PrometheusRules.
+	OutputConfigMap = "configmap"
+)
+
+// ConfigMapRulesKey is the key of the rule file in the ConfigMaps that hold the absence
+// alert rules when OutputConfigMap is used.
+const ConfigMapRulesKey = "absence-rules.yaml"
+
+// outputConfigMap reports whether the absence alert rules are written into ConfigMaps
+// instead of AbsencePrometheusRules.
+func (r *PrometheusRuleReconciler) outputConfigMap() bool {
+	return r.Output == OutputConfigMap
+}
+
+// absenceRulesConfigMap converts an AbsencePrometheusRule into the ConfigMap that is
+// written for it with OutputConfigMap. The ConfigMap has the same name, labels, and
+// annotations. Its rule groups are stored in the Prometheus rule file format.
+func absenceRulesConfigMap(absencePromRule *monitoringv1.PrometheusRule) (*corev1.ConfigMap, error) {
+	// The JSON field names of the PrometheusRuleSpec match the rule file format.
+	b, err := yaml.Marshal(absencePromRule.Spec)
+	if err != nil {
+		return nil, err
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: *absencePromRule.ObjectMeta.DeepCopy(),
+		Data:       map[string]string{ConfigMapRulesKey: string(b)},
+	}
+	return cm, nil
+}
+
+// absencePrometheusRuleFromConfigMap is the reverse of absenceRulesConfigMap().
+func absencePrometheusRuleFromConfigMap(cm *corev1.ConfigMap) (*monitoringv1.PrometheusRule, error) {
+	absencePromRule := &monitoringv1.PrometheusRule{ObjectMeta: *cm.ObjectMeta.DeepCopy()}
+	if err := yaml.Unmarshal([]byte(cm.Data[ConfigMapRulesKey]), &absencePromRule.Spec); err != nil {
+		return nil, fmt.Errorf("could not parse the absence alert rules in ConfigMap %s/%s: %w",
+			cm.GetNamespace(), cm.GetName(), err)
+	}
+	return absencePromRule, nil
+}
+
+// getAbsencePrometheusRule gets an AbsencePrometheusRule from the configured output.
+func (r *PrometheusRuleReconciler) getAbsencePrometheusRule(
+	ctx context.Context,
+	key types.NamespacedName,
+) (*monitoringv1.PrometheusRule, error) {
+
+	if !r.outputConfigMap() {
+		var absencePromRule monitoringv1.PrometheusRule
+		if err := r.Get(ctx, key, &absencePromRule); err != nil {
+			return nil, err
+		}
+		withoutWatchdogRuleGroup(&absencePromRule)
+		withoutMissingMetricsRuleGroup(&absencePromRule)
+		return &absencePromRule, nil
+	}
+
+	var cm corev1.ConfigMap
+	if err := r.Get(ctx, key, &cm); err != nil {
+		return nil, err
+	}
+	absencePromRule, err := absencePrometheusRuleFromConfigMap(&cm)
+	if err != nil {
+		return nil, err
+	}
+	withoutWatchdogRuleGroup(absencePromRule)
+	withoutMissingMetricsRuleGroup(absencePromRule)
+	return absencePromRule, nil
+}
+
+// listAbsencePrometheusRules lists the AbsencePrometheusRules of the configured output.
+func (r *PrometheusRuleReconciler) listAbsencePrometheusRules(
+	ctx context.Context,
+	opts ...client.ListOption,
+) ([]*monitoringv1.PrometheusRule, error) {
+
+	if !r.outputConfigMap() {
+		var absencePromRules monitoringv1.PrometheusRuleList
+		if err := r.List(ctx, &absencePromRules, opts...); err != nil {
+			return nil, err
+		}
+		for _, aPR := range absencePromRules.Items {
+			withoutWatchdogRuleGroup(aPR)
+			withoutMissingMetricsRuleGroup(aPR)
+		}
+		return absencePromRules.Items, nil
+	}
+
+	var cms corev1.ConfigMapList
+	if err := r.List(ctx, &cms, opts...); err != nil {
+		return nil, err
+	}
+	result := make([]*monitoringv1.PrometheusRule, 0, len(cms.Items))
+	for i := range cms.Items {
+		aPR, err := absencePrometheusRuleFromConfigMap(&cms.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		withoutWatchdogRuleGroup(aPR)
+		withoutMissingMetricsRuleGroup(aPR)
+		result = append(result, aPR)
+	}
+	return result, nil
+}
+
+// outputObject returns the object that is written for an AbsencePrometheusRule to the
+// configured output.
+func (r *PrometheusRuleReconciler) outputObject(absencePromRule *monitoringv1.PrometheusRule) (client.Object, error) {
+	if !r.outputConfigMap() {
+		return absencePromRule, nil
+	}
+	return absenceRulesConfigMap(absencePromRule)
+}
+
+// cleanUpAbsenceRulesConfigMap does a clean up of the AbsencePrometheusRule that is
+// stored in a ConfigMap (see cleanUpAbsencePrometheusRule()).
+func (r *PrometheusRuleReconciler) cleanUpAbsenceRulesConfigMap(ctx context.Context, key types.NamespacedName) error {
+	absencePromRule, err := r.getAbsencePrometheusRule(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !r.isManaged(absencePromRule) || r.isUnadopted(absencePromRule) {
+		return nil
+	}
+	return r.cleanUpAbsencePrometheusRule(ctx, absencePromRule)
+}
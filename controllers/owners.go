@@ -0,0 +1,85 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// OwnerMapping maps namespaces to the teams that own them. The mapping is loaded from a
+// YAML file with namespaces as keys and teams as values, e.g.:
+//
+//	swift: storage
+//	resmgmt: containers
+//
+// The file is reloaded when it changes, so that changes take effect without a restart.
+type OwnerMapping struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	owners  map[string]string
+}
+
+// NewOwnerMapping loads an OwnerMapping from the file at the given path.
+func NewOwnerMapping(path string) (*OwnerMapping, error) {
+	m := &OwnerMapping{path: path}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Owner returns the team that owns a namespace. An empty string is returned if the
+// namespace is not part of the mapping. The file is reloaded first if it has changed.
+func (m *OwnerMapping) Owner(namespace string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.reload(); err != nil {
+		return "", err
+	}
+	return m.owners[namespace], nil
+}
+
+// reload loads the file again if its modification time or size have changed since it was
+// last loaded. The caller must hold the lock unless the mapping is not shared yet.
+func (m *OwnerMapping) reload() error {
+	fi, err := os.Stat(m.path)
+	if err != nil {
+		return err
+	}
+	if m.owners != nil && fi.ModTime().Equal(m.modTime) && fi.Size() == m.size {
+		return nil
+	}
+
+	b, err := os.ReadFile(m.path)
+	if err != nil {
+		return err
+	}
+	owners := make(map[string]string)
+	if err := yaml.Unmarshal(b, &owners); err != nil {
+		return fmt.Errorf("could not parse owner mapping %s: %w", m.path, err)
+	}
+	m.owners = owners
+	m.modTime = fi.ModTime()
+	m.size = fi.Size()
+	return nil
+}
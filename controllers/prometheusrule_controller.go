@@ -17,17 +17,30 @@ package controllers
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/go-logr/logr"
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 	"github.com/sapcc/go-bits/errext"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const logLevelDebug int = 1
@@ -50,6 +63,233 @@ type PrometheusRuleReconciler struct {
 	// KeepLabel is a map of labels that will be retained from the original alert rule and
 	// passed on to its corresponding absent alert rule.
 	KeepLabel KeepLabel
+
+	// FallbackPrometheusServer is the name of the Prometheus server that is used for
+	// PrometheusRules that do not have a 'prometheus' label. If empty, such resources
+	// are not processed.
+	FallbackPrometheusServer string
+
+	// KeepEmptyAbsencePrometheusRules specifies whether AbsencePrometheusRules that no
+	// longer have any absence alert rules are kept instead of being deleted.
+	KeepEmptyAbsencePrometheusRules bool
+
+	// SeverityConfigMap references a ConfigMap that maps namespaces to the severity that
+	// is used for absence alert rules in that namespace. It is optional.
+	SeverityConfigMap types.NamespacedName
+
+	// OptionalMetricsConfigMap references a ConfigMap that lists the names of metrics
+	// that are known to be optional, one per line in each of its values. These metrics
+	// do not get absence alert rules. It is optional.
+	OptionalMetricsConfigMap types.NamespacedName
+
+	// WatchdogAlertName is the name of an alert rule that always fires which is added to
+	// each AbsencePrometheusRule in a separate rule group. Like the Watchdog alert of
+	// Alertmanager setups, it confirms that the absence alert rules are loaded and
+	// evaluated. If empty, no watchdog alert rule is added.
+	WatchdogAlertName string
+
+	// RecordMissingMetrics specifies whether a recording rule is added to each
+	// AbsencePrometheusRule in a separate rule group that records the number of its
+	// metrics that are currently missing as "absence:missing_metrics:count", e.g. for
+	// dashboards.
+	RecordMissingMetrics bool
+
+	// SkipHandWrittenAbsence specifies whether the PrometheusRules of the same Prometheus
+	// server in the namespace of a PrometheusRule are checked for hand-written absence
+	// alert rules. No duplicates of these are generated (see
+	// ParseOpts.HandWrittenAbsence).
+	SkipHandWrittenAbsence bool
+
+	// OwnerMapping maps namespaces to the teams that own them. The owner of a namespace is
+	// used for the ParseOpts.OwnerLabel of absence alert rules that do not have this label
+	// otherwise. It is optional.
+	OwnerMapping *OwnerMapping
+
+	// ManagedByLabel is the key of the label that identifies AbsencePrometheusRules which
+	// are created and managed by the operator. If empty, the default key
+	// "absent-metrics-operator/managed-by" is used.
+	ManagedByLabel string
+
+	// RecordRuleGroupSources specifies whether the PrometheusRule that an AbsenceRuleGroup
+	// was generated for is recorded in the 'absent-metrics-operator/rule-group-sources'
+	// annotation of the AbsencePrometheusRule. Clean up prefers these records over
+	// parsing the names of the AbsenceRuleGroups.
+	RecordRuleGroupSources bool
+
+	// HashRuleGroups specifies whether the hashes of the AbsenceRuleGroups are recorded in
+	// the 'absent-metrics-operator/rule-group-hashes' annotation of the
+	// AbsencePrometheusRule. If the hashes of newly generated AbsenceRuleGroups match the
+	// hashes of the stored ones then the rule groups are kept as is. The hashes are those
+	// of the AbsenceRuleGroups as they are stored, i.e. after disambiguation (see
+	// DuplicateAlertNames). Manual changes to AbsenceRuleGroups change their hashes and
+	// are therefore reverted.
+	HashRuleGroups bool
+
+	// MaxAbsencePrometheusRuleSize is the maximum size (in bytes) of the rule groups of an
+	// AbsencePrometheusRule. Larger AbsencePrometheusRules are split into numbered parts,
+	// e.g. "<name>-1", "<name>-2", etc. Zero disables splitting.
+	MaxAbsencePrometheusRuleSize int
+
+	// CopyAnnotations specifies the keys of the annotations of a PrometheusRule that are
+	// copied to its absence alert rules.
+	CopyAnnotations map[string]bool
+
+	// Recorder is used to record events for PrometheusRules that could not be
+	// reconciled or whose absence alert rules exceed ParseOpts.MaxRules. It is optional.
+	Recorder record.EventRecorder
+
+	// SourceURLTemplate is used to render the 'absent-metrics-operator/source-url'
+	// annotation for the absence alert rules of a PrometheusRule. It is optional.
+	SourceURLTemplate *template.Template
+
+	// GroupingLabel is the key of a label that is added to all absence alert rules (see
+	// ParseOpts.GroupingLabel). It is optional.
+	GroupingLabel string
+
+	// GroupingLabelTemplate is used to render the value of the GroupingLabel for the
+	// absence alert rules of a PrometheusRule.
+	GroupingLabelTemplate *template.Template
+
+	// TypeLabelTemplate is used to render the value of the 'type' label of the
+	// AbsencePrometheusRule for a PrometheusRule (see RenderTypeLabel()). If nil, the
+	// label is "alerting-rules". The template should render the same value for all
+	// PrometheusRules that share an AbsencePrometheusRule.
+	TypeLabelTemplate *template.Template
+
+	// CreateDelay is the minimum age of a PrometheusRule before absence alert rules are
+	// generated for it. This gives other controllers time to populate newly created
+	// resources. It is optional.
+	CreateDelay time.Duration
+
+	// Aggregation specifies whether absence alert rules are aggregated per Prometheus
+	// server (AggregationPrometheusServer, the default) or per namespace
+	// (AggregationNamespace).
+	Aggregation string
+
+	// DisableLabelInference specifies whether the inference of defaults for the support
+	// group, tier, and service labels from the alert rules of a PrometheusRule and the
+	// other PrometheusRules in its namespace is disabled. Only the labels of the
+	// PrometheusRule itself are used as defaults then. This avoids the List requests
+	// that are needed for the inference.
+	DisableLabelInference bool
+
+	// LabelPrecedence specifies whether the labels of a PrometheusRule
+	// (LabelPrecedenceResource, the default) or the labels that are inferred from alert
+	// rules (LabelPrecedenceInference) take precedence as defaults for the support group,
+	// tier, and service labels. Inference avoids stale labels on PrometheusRules but
+	// always requires going through the alert rules.
+	LabelPrecedence string
+
+	// RetainExistingLabels specifies whether the support group, tier, and service labels
+	// of an existing AbsencePrometheusRule are used as defaults for the labels that could
+	// not be determined otherwise. This avoids losing the labels that are used for
+	// routing if they can temporarily not be inferred, e.g. while the alert rules are
+	// being changed.
+	RetainExistingLabels bool
+
+	// OrphanSweepInterval is the interval at which all AbsencePrometheusRules are cleaned
+	// up independently of the reconciliation (see SweepOrphans()). The sweep is disabled
+	// if it is zero.
+	OrphanSweepInterval time.Duration
+
+	// PrometheusServerDelimiter separates the names of multiple Prometheus servers in the
+	// 'prometheus' label of a PrometheusRule, e.g. "." for "prometheus: a.b". The
+	// absence alert rules of such a PrometheusRule are generated in the
+	// AbsencePrometheusRule of each of these servers. If empty, the label always contains
+	// the name of a single Prometheus server.
+	PrometheusServerDelimiter string
+
+	// PrometheusServerLabel specifies whether the Prometheus server of a PrometheusRule
+	// is added as the 'prometheus' label to each of its absence alert rules.
+	PrometheusServerLabel bool
+
+	// PrometheusServerLabelKey is the key of the label that specifies the Prometheus
+	// server of PrometheusRules and AbsencePrometheusRules, and of the absence alert rules
+	// (see PrometheusServerLabel). If empty, DefaultPrometheusServerLabelKey is used.
+	PrometheusServerLabelKey string
+
+	// InstanceID identifies this operator instance. If set, it is used as the value of the
+	// managed-by label and as the field manager instead of the defaults so that multiple
+	// instances can coexist in the same namespaces. It is optional.
+	InstanceID string
+
+	// AnnotationPrefix is the prefix of the keys of the annotations that are managed by
+	// the operator, e.g. "<prefix>/updated-at". If empty, the DefaultAnnotationPrefix is
+	// used.
+	AnnotationPrefix string
+
+	// DisableAdoption specifies whether AbsencePrometheusRules with the managed-by label
+	// that were not created by this version of the operator (e.g. by an older version
+	// with different conventions) are left alone instead of being adopted and
+	// reconciled. See isUnadopted().
+	DisableAdoption bool
+
+	// RequeueAfter is the interval after which a resource is reconciled again after it
+	// was reconciled successfully. This corrects drift, e.g. manual changes to
+	// AbsencePrometheusRules. If zero, the default of 5 minutes is used.
+	RequeueAfter time.Duration
+
+	// MaxConcurrentReconciles is the maximum number of resources that are reconciled
+	// concurrently. If zero, the default of controller-runtime (1) is used.
+	MaxConcurrentReconciles int
+
+	// NamespaceRateLimiter limits the rate of reconciles per namespace so that a namespace
+	// with many PrometheusRules does not delay the reconciles of other namespaces. Requests
+	// that exceed the limit are requeued. It is optional.
+	NamespaceRateLimiter *NamespaceRateLimiter
+
+	// EmptyResultThreshold is the number of consecutive reconciles in which no absence
+	// alert rules are generated for a PrometheusRule before its existing absence alert
+	// rules are removed. This guards against transient empty results, e.g. due to a
+	// broken expression that is fixed shortly after. Values below 2 remove them right
+	// away.
+	EmptyResultThreshold int
+
+	// emptyResults counts the consecutive empty results for the EmptyResultThreshold.
+	emptyResults emptyResultCounter
+
+	// ExcludeOwnerKinds contains the kinds of owner references (e.g. "Alertmanager") that
+	// exclude a PrometheusRule from processing, e.g. because it is generated by another
+	// operator. Existing absence alert rules for excluded PrometheusRules are removed.
+	ExcludeOwnerKinds map[string]bool
+
+	// ExcludeLabels and ExcludeAnnotations contain key/value pairs of labels and
+	// annotations that exclude a PrometheusRule from processing, e.g.
+	// "app.kubernetes.io/managed-by: Helm". See ExcludeOwnerKinds.
+	ExcludeLabels      map[string]string
+	ExcludeAnnotations map[string]string
+
+	// ExcludeWithoutPrometheusLabel specifies whether PrometheusRules that do not have a
+	// 'prometheus' label are excluded from processing (see ExcludeOwnerKinds). Unlike
+	// with an empty FallbackPrometheusServer, reconciling such resources does not fail.
+	ExcludeWithoutPrometheusLabel bool
+
+	// ForceRelabel specifies whether the labels of AbsencePrometheusRules are re-derived
+	// even if they would otherwise be left as is, i.e. labels that are no longer kept are
+	// removed and the RuleGroupHashes are ignored. This is used to apply changes of the
+	// label configuration with ReconcileAll().
+	ForceRelabel bool
+
+	// Output specifies whether the absence alert rules are written into
+	// AbsencePrometheusRules (OutputPrometheusRule, the default) or into ConfigMaps with
+	// the same name and labels (OutputConfigMap).
+	Output string
+
+	// DuplicateAlertNames specifies whether absence alert rules in different
+	// AbsenceRuleGroups of an AbsencePrometheusRule that have the same name are logged
+	// (DuplicateAlertNamesWarn, the default) or renamed (DuplicateAlertNamesDisambiguate).
+	DuplicateAlertNames string
+
+	// ParseOpts holds the options for generating absence alert rules. Its LabelOpts are
+	// determined separately for each PrometheusRule.
+	ParseOpts ParseOpts
+
+	// optsMu guards the options that can be changed with Reload() against concurrent
+	// reconciles.
+	optsMu sync.RWMutex
+
+	// resync triggers a reconcile of all PrometheusRules after Reload().
+	resync chan event.GenericEvent
 }
 
 //+kubebuilder:rbac:groups=monitoring.coreos.com,resources=prometheusrules,verbs=get;list;watch;create;update;patch;delete
@@ -62,12 +302,29 @@ type PrometheusRuleReconciler struct {
 func (r *PrometheusRuleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("name", req.Name, "namespace", req.Namespace)
 
+	r.optsMu.RLock()
+	defer r.optsMu.RUnlock()
+
+	if r.NamespaceRateLimiter != nil {
+		if d := r.NamespaceRateLimiter.Delay(req.Namespace); d > 0 {
+			log.V(logLevelDebug).Info("delaying reconcile due to namespace rate limit", "delay", d.String())
+			return ctrl.Result{RequeueAfter: d}, nil
+		}
+	}
+
 	// Get the current PrometheusRule from the API server.
 	var promRule monitoringv1.PrometheusRule
 	err := r.Get(ctx, req.NamespacedName, &promRule)
 	switch {
 	case err == nil:
+		if d := r.remainingCreateDelay(&promRule); d > 0 {
+			log.V(logLevelDebug).Info("delaying processing of new PrometheusRule", "delay", d.String())
+			return ctrl.Result{RequeueAfter: d}, nil
+		}
 		err = r.reconcileObject(ctx, req.NamespacedName, &promRule)
+		if err != nil && r.Recorder != nil {
+			r.Recorder.Event(&promRule, corev1.EventTypeWarning, "ReconcileFailed", err.Error())
+		}
 	case apierrors.IsNotFound(err):
 		// Could not find object on the API server, maybe it has been deleted?
 		return r.handleObjectNotFound(ctx, req.NamespacedName)
@@ -75,6 +332,7 @@ func (r *PrometheusRuleReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		// Handle err down below.
 	}
 	if err != nil {
+		r.recordForbiddenError(req.Namespace, err)
 		if perr, ok := errext.As[*ruleGroupParseError](err); ok {
 			// We choose to absorb the error here as returning the error would requeue the
 			// resource for immediate processing and we'll be stuck parsing broken alert
@@ -87,18 +345,94 @@ func (r *PrometheusRuleReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		return ctrl.Result{Requeue: true}, err
 	}
 
-	if parseBool(promRule.Labels[labelOperatorDisable]) {
+	if parseBool(promRule.Labels[labelOperatorDisable]) || isFrozen(&promRule) || r.isExcluded(&promRule) {
 		// Do not requeue in case the operator has been disabled for this resource.
 		return ctrl.Result{}, nil
 	}
-	return ctrl.Result{RequeueAfter: requeueInterval}, nil
+	return ctrl.Result{RequeueAfter: r.requeueAfter()}, nil
+}
+
+// requeueAfter returns the interval after which a successfully reconciled resource is
+// requeued.
+func (r *PrometheusRuleReconciler) requeueAfter() time.Duration {
+	if r.RequeueAfter > 0 {
+		return r.RequeueAfter
+	}
+	return requeueInterval
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PrometheusRuleReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&monitoringv1.PrometheusRule{}).
-		Complete(r)
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(&monitoringv1.PrometheusRule{},
+			handler.Funcs{UpdateFunc: r.enqueueRelocatedAbsencePrometheusRule})
+	if r.SeverityConfigMap.Name != "" || r.OptionalMetricsConfigMap.Name != "" {
+		b = b.Watches(&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.enqueueAllPrometheusRules),
+			builder.WithPredicates(isConfigMap(r.SeverityConfigMap, r.OptionalMetricsConfigMap)))
+	}
+	r.resync = make(chan event.GenericEvent, 1)
+	b = b.WatchesRawSource(&source.Channel{Source: r.resync},
+		handler.EnqueueRequestsFromMapFunc(r.enqueueAllPrometheusRules))
+	if r.OrphanSweepInterval > 0 {
+		if err := mgr.Add(manager.RunnableFunc(r.sweepOrphansPeriodically)); err != nil {
+			return err
+		}
+	}
+	return b.Complete(r)
+}
+
+// CheckPrometheusRuleCRD checks that the PrometheusRule CRD is installed in the cluster.
+// The manager fails with an obscure error message otherwise, therefore we use this as a
+// preflight check during startup.
+func CheckPrometheusRuleCRD(mapper meta.RESTMapper) error {
+	gk := schema.GroupKind{Group: monitoringv1.SchemeGroupVersion.Group, Kind: monitoringv1.PrometheusRuleKind}
+	_, err := mapper.RESTMapping(gk, monitoringv1.SchemeGroupVersion.Version)
+	if meta.IsNoMatchError(err) {
+		return fmt.Errorf("the %s CRD (%s) is not installed in the cluster, "+
+			"install the CRDs of the Prometheus operator before starting the absent metrics operator: %w",
+			monitoringv1.PrometheusRuleKind, monitoringv1.SchemeGroupVersion.String(), err)
+	}
+	return err
+}
+
+// isExcluded reports whether a PrometheusRule is excluded from processing by its owner
+// references, labels, or annotations (see ExcludeOwnerKinds) or by a missing 'prometheus'
+// label (see ExcludeWithoutPrometheusLabel). AbsencePrometheusRules are never excluded.
+func (r *PrometheusRuleReconciler) isExcluded(promRule *monitoringv1.PrometheusRule) bool {
+	if r.isManaged(promRule) {
+		return false
+	}
+	if r.ExcludeWithoutPrometheusLabel && promRule.GetLabels()[r.prometheusServerLabelKey()] == "" {
+		return true
+	}
+	for _, ref := range promRule.GetOwnerReferences() {
+		if r.ExcludeOwnerKinds[ref.Kind] {
+			return true
+		}
+	}
+	for k, v := range r.ExcludeLabels {
+		if val, ok := promRule.GetLabels()[k]; ok && val == v {
+			return true
+		}
+	}
+	for k, v := range r.ExcludeAnnotations {
+		if val, ok := promRule.GetAnnotations()[k]; ok && val == v {
+			return true
+		}
+	}
+	return false
+}
+
+// remainingCreateDelay returns the time until a PrometheusRule is older than the
+// CreateDelay. AbsencePrometheusRules are never delayed.
+func (r *PrometheusRuleReconciler) remainingCreateDelay(promRule *monitoringv1.PrometheusRule) time.Duration {
+	if r.CreateDelay <= 0 || r.isManaged(promRule) || r.isManagedByOtherInstance(promRule) {
+		return 0
+	}
+	return r.CreateDelay - time.Since(promRule.GetCreationTimestamp().Time)
 }
 
 // handleObjectNotFound is a helper function for Reconcile(). It exists separately so that
@@ -111,6 +445,20 @@ func (r *PrometheusRuleReconciler) handleObjectNotFound(ctx context.Context, key
 		// In case that an AbsencePrometheusRule no longer exists we don't have to do any
 		// further processing. If it still exists then it will be handled the next time it
 		// is reconciled.
+		//
+		// The resource could have been an AbsencePrometheusRule of a different operator
+		// instance which is reconciled like any other PrometheusRule, hence the gauge.
+		deleteReconcileGauge(key)
+		if r.outputConfigMap() {
+			// The AbsencePrometheusRule is a ConfigMap. It is cleaned up right away since
+			// there are no watch events for it, e.g. after it was enqueued by
+			// enqueueRelocatedAbsencePrometheusRule().
+			err := r.cleanUpAbsenceRulesConfigMap(ctx, key)
+			if err != nil && !apierrors.IsNotFound(err) {
+				r.recordForbiddenError(key.Namespace, err)
+				log.Error(err, "could not clean up AbsencePrometheusRule")
+			}
+		}
 		return ctrl.Result{}, nil
 	}
 
@@ -124,8 +472,10 @@ func (r *PrometheusRuleReconciler) handleObjectNotFound(ctx context.Context, key
 	// we wait until the next time when all AbsencePrometheusRules are requeued for
 	// processing (after the requeueInterval is elapsed).
 	log.V(logLevelDebug).Info("PrometheusRule no longer exists")
+	r.emptyResults.reset(key, "")
 	err := r.cleanUpOrphanedAbsenceAlertRules(ctx, key, "")
 	if err != nil {
+		r.recordForbiddenError(key.Namespace, err)
 		if !apierrors.IsNotFound(err) && !errors.Is(err, errCorrespondingAbsencePromRuleNotExists) {
 			log.Error(err, "could not clean up orphaned absence alert rules")
 		}
@@ -133,6 +483,7 @@ func (r *PrometheusRuleReconciler) handleObjectNotFound(ctx context.Context, key
 		log.V(logLevelDebug).Info("successfully cleaned up orphaned absence alert rules")
 	}
 	deleteReconcileGauge(key)
+	setDisabledRuleGauge(key, false)
 	return ctrl.Result{}, nil
 }
 
@@ -148,15 +499,23 @@ func (r *PrometheusRuleReconciler) reconcileObject(
 	l := obj.GetLabels()
 
 	// Step 1: check if the object is a PrometheusRule or an AbsencePrometheusRule.
-	if parseBool(l[labelOperatorManagedBy]) {
+	if r.isManagedByOtherInstance(obj) || r.isUnadopted(obj) || (r.outputConfigMap() && r.isManaged(obj)) {
+		// AbsencePrometheusRules of other operator instances and the ones that we may not
+		// adopt are left alone. The same goes for the AbsencePrometheusRules that remain
+		// from before switching the output to ConfigMaps.
+		return nil
+	}
+	if r.isManaged(obj) {
 		// If it's an AbsencePrometheusRule then do a clean up, i.e. remove any absence
 		// metric alert rules from it that no longer belong to any PrometheusRule.
-		updatedAt, err := time.Parse(time.RFC3339, obj.Annotations[annotationOperatorUpdatedAt])
+		updatedAt, err := time.Parse(time.RFC3339, obj.Annotations[r.annotationKey(annotationOperatorUpdatedAt)])
 		if err != nil && time.Now().UTC().Sub(updatedAt) < requeueInterval {
 			// No need for clean up if the AbsencePrometheusRule was updated recently.
 			// We'll process it when it's next requeued.
 			return nil
 		}
+		withoutWatchdogRuleGroup(obj)
+		withoutMissingMetricsRuleGroup(obj)
 		err = r.cleanUpAbsencePrometheusRule(ctx, obj)
 		if err == nil {
 			log.V(logLevelDebug).Info("successfully cleaned up AbsencePrometheusRule")
@@ -174,10 +533,22 @@ func (r *PrometheusRuleReconciler) reconcileObject(
 	// corresponding AbsencePrometheusRule. Instead, we wait until the next time when all
 	// AbsencePrometheusRules are requeued for processing (after the requeueInterval is
 	// elapsed).
-	if parseBool(l[labelOperatorDisable]) {
-		log.V(logLevelDebug).Info("operator disabled for this PrometheusRule")
-		err := r.cleanUpOrphanedAbsenceAlertRules(ctx, key, l[labelPrometheusServer])
+	excluded := r.isExcluded(obj)
+	if excluded || parseBool(l[labelOperatorDisable]) {
+		if excluded {
+			log.V(logLevelDebug).Info("PrometheusRule is excluded")
+		} else {
+			log.V(logLevelDebug).Info("operator disabled for this PrometheusRule")
+		}
+		// The AbsencePrometheusRules of all Prometheus servers in the namespace are searched
+		// if the PrometheusRule concerns more than one.
+		var promServer string
+		if promServers := r.prometheusServers(obj); len(promServers) == 1 {
+			promServer = promServers[0]
+		}
+		err := r.cleanUpOrphanedAbsenceAlertRules(ctx, key, promServer)
 		if err != nil {
+			r.recordForbiddenError(key.Namespace, err)
 			if !apierrors.IsNotFound(err) && !errors.Is(err, errCorrespondingAbsencePromRuleNotExists) {
 				log.Error(err, "could not clean up orphaned absence alert rules")
 			}
@@ -185,8 +556,19 @@ func (r *PrometheusRuleReconciler) reconcileObject(
 			log.V(logLevelDebug).Info("successfully cleaned up orphaned absence alert rules")
 		}
 		deleteReconcileGauge(key)
+		setDisabledRuleGauge(key, parseBool(l[labelOperatorDisable]))
+		return nil
+	}
+	if isFrozen(obj) {
+		// The existing absence alert rules are neither updated nor cleaned up.
+		log.V(logLevelDebug).Info("operator frozen for this PrometheusRule")
+		deleteReconcileGauge(key)
+		// Frozen PrometheusRules keep their absence alert rules, therefore they are not
+		// counted as disabled.
+		setDisabledRuleGauge(key, false)
 		return nil
 	}
+	setDisabledRuleGauge(key, false)
 
 	// Step 3: Generate the corresponding absence alert rules for this resource.
 	err := r.updateAbsenceAlertRules(ctx, obj)
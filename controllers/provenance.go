@@ -0,0 +1,116 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"encoding/json"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ruleGroupSource identifies the PrometheusRule for which an AbsenceRuleGroup was
+// generated.
+type ruleGroupSource struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid,omitempty"`
+}
+
+// ruleGroupSources returns the sources of the AbsenceRuleGroups of an
+// AbsencePrometheusRule that are stored in the 'absent-metrics-operator/rule-group-sources'
+// annotation. The keys of the map are the names of the AbsenceRuleGroups.
+//
+// An empty map is returned if the annotation does not exist or can not be decoded, in
+// which case the sources are determined from the names of the AbsenceRuleGroups.
+func (r *PrometheusRuleReconciler) ruleGroupSources(absencePromRule *monitoringv1.PrometheusRule) map[string]ruleGroupSource {
+	result := make(map[string]ruleGroupSource)
+	v := absencePromRule.GetAnnotations()[r.annotationKey(annotationRuleGroupSources)]
+	if v == "" {
+		return result
+	}
+	if err := json.Unmarshal([]byte(v), &result); err != nil {
+		return make(map[string]ruleGroupSource)
+	}
+	return result
+}
+
+// setRuleGroupSources stores the sources of the AbsenceRuleGroups in the
+// 'absent-metrics-operator/rule-group-sources' annotation of an AbsencePrometheusRule.
+// Sources for AbsenceRuleGroups that no longer exist are dropped.
+func (r *PrometheusRuleReconciler) setRuleGroupSources(absencePromRule *monitoringv1.PrometheusRule, sources map[string]ruleGroupSource) error {
+	result := make(map[string]ruleGroupSource, len(sources))
+	for _, g := range absencePromRule.Spec.Groups {
+		if s, ok := sources[g.Name]; ok {
+			result[g.Name] = s
+		}
+	}
+	if len(result) == 0 {
+		delete(absencePromRule.Annotations, r.annotationKey(annotationRuleGroupSources))
+		return nil
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if absencePromRule.Annotations == nil {
+		absencePromRule.Annotations = make(map[string]string)
+	}
+	absencePromRule.Annotations[r.annotationKey(annotationRuleGroupSources)] = string(b)
+	return nil
+}
+
+// pruneRuleGroupSources drops the sources for AbsenceRuleGroups that no longer exist
+// from the 'absent-metrics-operator/rule-group-sources' annotation.
+func (r *PrometheusRuleReconciler) pruneRuleGroupSources(absencePromRule *monitoringv1.PrometheusRule) error {
+	if _, ok := absencePromRule.GetAnnotations()[r.annotationKey(annotationRuleGroupSources)]; !ok {
+		return nil
+	}
+	return r.setRuleGroupSources(absencePromRule, r.ruleGroupSources(absencePromRule))
+}
+
+// updateRuleGroupSources records the given PrometheusRule as the source of the
+// AbsenceRuleGroups that were generated for it. It is a no-op unless
+// RecordRuleGroupSources is set, in which case the sources of the other
+// AbsenceRuleGroups are kept as is.
+func (r *PrometheusRuleReconciler) updateRuleGroupSources(
+	absencePromRule, promRule *monitoringv1.PrometheusRule,
+	ruleGroups []monitoringv1.RuleGroup,
+) error {
+
+	if !r.RecordRuleGroupSources {
+		return nil
+	}
+	sources := r.ruleGroupSources(absencePromRule)
+	for _, g := range ruleGroups {
+		sources[g.Name] = ruleGroupSource{
+			Namespace: promRule.GetNamespace(),
+			Name:      promRule.GetName(),
+			UID:       promRule.GetUID(),
+		}
+	}
+	return r.setRuleGroupSources(absencePromRule, sources)
+}
+
+// promRuleFromAbsenceRuleGroup returns the name of the PrometheusRule for which an
+// AbsenceRuleGroup was generated. The stored source is preferred, the name of the
+// AbsenceRuleGroup is only parsed for AbsenceRuleGroups that do not have one.
+func promRuleFromAbsenceRuleGroup(sources map[string]ruleGroupSource, ruleGroup string) string {
+	if s, ok := sources[ruleGroup]; ok {
+		return s.Name
+	}
+	return promRulefromAbsenceRuleGroupName(ruleGroup)
+}
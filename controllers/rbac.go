@@ -0,0 +1,79 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// forbiddenHints keeps track of the namespaces and resources for which a Forbidden error
+// has already been logged so that the hint about missing RBAC permissions is only logged
+// once per namespace and resource.
+var forbiddenHints = struct {
+	sync.Mutex
+	logged map[string]bool
+}{
+	logged: make(map[string]bool),
+}
+
+// recordForbiddenError checks whether an error was caused by missing RBAC permissions
+// (i.e. a Forbidden error from the API server). If so, it increments the rbacErrors
+// counter for the namespace and logs a hint the first time that this happens for the
+// namespace and the resource of the request (see forbiddenResource()). Other errors are
+// ignored.
+//
+// Without this, missing permissions in a namespace only surface as opaque errors for each
+// reconciliation of its PrometheusRules.
+func (r *PrometheusRuleReconciler) recordForbiddenError(namespace string, err error) {
+	if !apierrors.IsForbidden(err) {
+		return
+	}
+	rbacErrors.WithLabelValues(namespace).Inc()
+
+	resource := forbiddenResource(err)
+	forbiddenHints.Lock()
+	defer forbiddenHints.Unlock()
+	key := namespace + "/" + resource
+	if forbiddenHints.logged[key] {
+		return
+	}
+	forbiddenHints.logged[key] = true
+	r.Log.Error(err, fmt.Sprintf("missing RBAC permissions for %s in namespace: "+
+		"the error shows the request that was denied "+
+		"(this is only logged once per namespace and resource)", resource),
+		"namespace", namespace, "resource", resource)
+}
+
+// forbiddenResource returns the resource of a Forbidden error from its details, e.g.
+// "prometheusrules.monitoring.coreos.com" or "configmaps" for the core group. If the
+// error does not have any details then "resources" is returned.
+func forbiddenResource(err error) string {
+	var statusErr *apierrors.StatusError
+	if !errors.As(err, &statusErr) {
+		return "resources"
+	}
+	details := statusErr.ErrStatus.Details
+	if details == nil || details.Kind == "" {
+		return "resources"
+	}
+	if details.Group == "" {
+		return details.Kind
+	}
+	return details.Kind + "." + details.Group
+}
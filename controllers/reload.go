@@ -0,0 +1,62 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"regexp"
+	"text/template"
+	"time"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// ReloadableOpts contains the options of the PrometheusRuleReconciler that can be
+// changed while the operator is running (see Reload()). The fields correspond to the
+// fields of the same name of the PrometheusRuleReconciler and its ParseOpts.
+type ReloadableOpts struct {
+	MinFor                time.Duration
+	DefaultSeverity       string
+	ExcludeMetrics        *regexp.Regexp
+	QueryURLTemplate      *template.Template
+	SourceURLTemplate     *template.Template
+	GroupingLabelTemplate *template.Template
+	TypeLabelTemplate     *template.Template
+}
+
+// Reload replaces the ReloadableOpts of the reconciler. It waits for running reconciles
+// to finish and then triggers a reconcile of all PrometheusRules so that the new
+// options take effect right away.
+func (r *PrometheusRuleReconciler) Reload(opts ReloadableOpts) {
+	r.optsMu.Lock()
+	r.ParseOpts.MinFor = opts.MinFor
+	r.ParseOpts.DefaultSeverity = opts.DefaultSeverity
+	r.ParseOpts.ExcludeMetrics = opts.ExcludeMetrics
+	r.ParseOpts.QueryURLTemplate = opts.QueryURLTemplate
+	r.SourceURLTemplate = opts.SourceURLTemplate
+	r.GroupingLabelTemplate = opts.GroupingLabelTemplate
+	r.TypeLabelTemplate = opts.TypeLabelTemplate
+	r.optsMu.Unlock()
+
+	if r.resync == nil {
+		return // not set up with a manager
+	}
+	// The object is only used to trigger enqueueAllPrometheusRules(). A pending resync
+	// already covers this one.
+	select {
+	case r.resync <- event.GenericEvent{Object: &monitoringv1.PrometheusRule{}}:
+	default:
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"slices"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// enqueueRelocatedAbsencePrometheusRule enqueues the AbsencePrometheusRule of the previous
+// Prometheus server when the 'prometheus' label of a PrometheusRule changes. For
+// PrometheusRules with multiple Prometheus servers (see PrometheusServerDelimiter), the
+// AbsencePrometheusRules of the servers that were removed from the label are enqueued.
+//
+// The absence alert rules of the PrometheusRule are generated in the AbsencePrometheusRule
+// of the new Prometheus server when the PrometheusRule itself is reconciled. The clean up
+// of the previous AbsencePrometheusRule then removes the absence alert rules that no
+// longer belong to any of its PrometheusRules right away instead of waiting for the next
+// requeue.
+func (r *PrometheusRuleReconciler) enqueueRelocatedAbsencePrometheusRule(
+	_ context.Context,
+	e event.UpdateEvent,
+	q workqueue.RateLimitingInterface,
+) {
+
+	if r.aggregatePerNamespace() {
+		// All Prometheus servers share the same AbsencePrometheusRule.
+		return
+	}
+	oldPromRule, ok := e.ObjectOld.(*monitoringv1.PrometheusRule)
+	if !ok {
+		return
+	}
+	newPromRule, ok := e.ObjectNew.(*monitoringv1.PrometheusRule)
+	if !ok || r.isManaged(newPromRule) || r.isManagedByOtherInstance(newPromRule) {
+		return
+	}
+	newPromServers := r.prometheusServers(newPromRule)
+	for _, oldPromServer := range r.prometheusServers(oldPromRule) {
+		if slices.Contains(newPromServers, oldPromServer) {
+			continue
+		}
+		r.Log.V(logLevelDebug).Info("Prometheus server of PrometheusRule changed",
+			"name", newPromRule.GetName(), "namespace", newPromRule.GetNamespace(),
+			"oldPrometheusServer", oldPromServer)
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: newPromRule.GetNamespace(),
+			Name:      r.absencePrometheusRuleName(oldPromServer),
+		}})
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+)
+
+// ruleGroupHash returns a hash of the content of an AbsenceRuleGroup.
+func ruleGroupHash(g monitoringv1.RuleGroup) (string, error) {
+	b, err := json.Marshal(g)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ruleGroupHashes returns the hashes of the AbsenceRuleGroups of an
+// AbsencePrometheusRule that are stored in the 'absent-metrics-operator/rule-group-hashes'
+// annotation. The keys of the map are the names of the AbsenceRuleGroups.
+//
+// An empty map is returned if the annotation does not exist or can not be decoded.
+func (r *PrometheusRuleReconciler) ruleGroupHashes(absencePromRule *monitoringv1.PrometheusRule) map[string]string {
+	result := make(map[string]string)
+	v := absencePromRule.GetAnnotations()[r.annotationKey(annotationRuleGroupHashes)]
+	if v == "" {
+		return result
+	}
+	if err := json.Unmarshal([]byte(v), &result); err != nil {
+		return make(map[string]string)
+	}
+	return result
+}
+
+// setRuleGroupHashes stores the hashes of the AbsenceRuleGroups in the
+// 'absent-metrics-operator/rule-group-hashes' annotation of an AbsencePrometheusRule.
+// Hashes for AbsenceRuleGroups that no longer exist are dropped.
+func (r *PrometheusRuleReconciler) setRuleGroupHashes(absencePromRule *monitoringv1.PrometheusRule, hashes map[string]string) error {
+	result := make(map[string]string, len(hashes))
+	for _, g := range absencePromRule.Spec.Groups {
+		if h, ok := hashes[g.Name]; ok {
+			result[g.Name] = h
+		}
+	}
+	if len(result) == 0 {
+		delete(absencePromRule.Annotations, r.annotationKey(annotationRuleGroupHashes))
+		return nil
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if absencePromRule.Annotations == nil {
+		absencePromRule.Annotations = make(map[string]string)
+	}
+	absencePromRule.Annotations[r.annotationKey(annotationRuleGroupHashes)] = string(b)
+	return nil
+}
+
+// pruneRuleGroupHashes drops the hashes for AbsenceRuleGroups that no longer exist from
+// the 'absent-metrics-operator/rule-group-hashes' annotation.
+func (r *PrometheusRuleReconciler) pruneRuleGroupHashes(absencePromRule *monitoringv1.PrometheusRule) error {
+	if _, ok := absencePromRule.GetAnnotations()[r.annotationKey(annotationRuleGroupHashes)]; !ok {
+		return nil
+	}
+	return r.setRuleGroupHashes(absencePromRule, r.ruleGroupHashes(absencePromRule))
+}
+
+// computeRuleGroupHashes returns the hashes of the given AbsenceRuleGroups. The keys of
+// the map are the names of the AbsenceRuleGroups.
+func computeRuleGroupHashes(ruleGroups []monitoringv1.RuleGroup) (map[string]string, error) {
+	result := make(map[string]string, len(ruleGroups))
+	for _, g := range ruleGroups {
+		h, err := ruleGroupHash(g)
+		if err != nil {
+			return nil, err
+		}
+		result[g.Name] = h
+	}
+	return result, nil
+}
+
+// storedRuleGroupHashes returns the hashes of the AbsenceRuleGroups of an
+// AbsencePrometheusRule as they are stored, i.e. after handleDuplicateAlertNames(), for
+// the ones that were newly generated. It returns nil if HashRuleGroups is not set.
+func (r *PrometheusRuleReconciler) storedRuleGroupHashes(ruleGroups, newRuleGroups []monitoringv1.RuleGroup) (map[string]string, error) {
+	if !r.HashRuleGroups {
+		return nil, nil
+	}
+	generated := make(map[string]bool, len(newRuleGroups))
+	for _, g := range newRuleGroups {
+		generated[g.Name] = true
+	}
+	var stored []monitoringv1.RuleGroup
+	for _, g := range ruleGroups {
+		if generated[g.Name] {
+			stored = append(stored, g)
+		}
+	}
+	return computeRuleGroupHashes(stored)
+}
+
+// hasRuleGroupHashes reports whether the given AbsenceRuleGroups, i.e. the ones that are
+// currently stored in an AbsencePrometheusRule, contain AbsenceRuleGroups with all the
+// given hashes. The stored AbsenceRuleGroups are hashed rather than trusting the
+// recorded hashes so that manual changes to them are detected and reverted.
+func hasRuleGroupHashes(ruleGroups []monitoringv1.RuleGroup, hashes map[string]string) (bool, error) {
+	if len(hashes) == 0 {
+		return false, nil
+	}
+	var stored []monitoringv1.RuleGroup
+	for _, g := range ruleGroups {
+		if _, ok := hashes[g.Name]; ok {
+			stored = append(stored, g)
+		}
+	}
+	storedHashes, err := computeRuleGroupHashes(stored)
+	if err != nil {
+		return false, err
+	}
+	for name, h := range hashes {
+		if storedHashes[name] != h {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// updateRuleGroupHashes records the given hashes of AbsenceRuleGroups in addition to
+// the existing ones. It is a no-op if there are no hashes, i.e. HashRuleGroups is not
+// set.
+func (r *PrometheusRuleReconciler) updateRuleGroupHashes(absencePromRule *monitoringv1.PrometheusRule, hashes map[string]string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	result := r.ruleGroupHashes(absencePromRule)
+	for name, h := range hashes {
+		result[name] = h
+	}
+	return r.setRuleGroupHashes(absencePromRule, result)
+}
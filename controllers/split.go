@@ -0,0 +1,233 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// absencePrometheusRulePartName returns the name of the n-th part of a split
+// AbsencePrometheusRule.
+func absencePrometheusRulePartName(name string, n int) string {
+	return name + "-" + strconv.Itoa(n)
+}
+
+// absencePrometheusRulePartNumber returns the number of the part of a split
+// AbsencePrometheusRule with the given name. It reports false if partName is not the
+// name of one of its parts.
+func absencePrometheusRulePartNumber(name, partName string) (int, bool) {
+	s, ok := strings.CutPrefix(partName, name+"-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || strconv.Itoa(n) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// splitAbsencePrometheusRules reports whether AbsencePrometheusRules that exceed
+// MaxAbsencePrometheusRuleSize are split into parts.
+func (r *PrometheusRuleReconciler) splitAbsencePrometheusRules() bool {
+	return r.MaxAbsencePrometheusRuleSize > 0
+}
+
+// listAbsencePrometheusRuleParts returns the parts of the AbsencePrometheusRule with the
+// given name, sorted by their number. It returns nothing if splitting is disabled.
+func (r *PrometheusRuleReconciler) listAbsencePrometheusRuleParts(
+	ctx context.Context,
+	namespace, name string,
+) ([]*monitoringv1.PrometheusRule, error) {
+
+	if !r.splitAbsencePrometheusRules() {
+		return nil, nil
+	}
+	absencePromRules, err := r.listAbsencePrometheusRules(ctx, client.InNamespace(namespace), r.managedBySelector())
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*monitoringv1.PrometheusRule
+	for _, aPR := range absencePromRules {
+		if _, ok := absencePrometheusRulePartNumber(name, aPR.GetName()); ok && r.isManaged(aPR) && !r.isUnadopted(aPR) {
+			result = append(result, aPR)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		ni, _ := absencePrometheusRulePartNumber(name, result[i].GetName())
+		nj, _ := absencePrometheusRulePartNumber(name, result[j].GetName())
+		return ni < nj
+	})
+	return result, nil
+}
+
+// combineAbsencePrometheusRuleParts adds the AbsenceRuleGroups of the parts of a split
+// AbsencePrometheusRule and their recorded sources and hashes to the
+// AbsencePrometheusRule, so that it can be updated as a whole.
+func (r *PrometheusRuleReconciler) combineAbsencePrometheusRuleParts(
+	absencePromRule *monitoringv1.PrometheusRule,
+	parts []*monitoringv1.PrometheusRule,
+) error {
+
+	sources := r.ruleGroupSources(absencePromRule)
+	hashes := r.ruleGroupHashes(absencePromRule)
+	for _, p := range parts {
+		absencePromRule.Spec.Groups = append(absencePromRule.Spec.Groups, p.Spec.Groups...)
+		for k, v := range r.ruleGroupSources(p) {
+			sources[k] = v
+		}
+		for k, v := range r.ruleGroupHashes(p) {
+			hashes[k] = v
+		}
+	}
+	if len(sources) > 0 {
+		if err := r.setRuleGroupSources(absencePromRule, sources); err != nil {
+			return err
+		}
+	}
+	if len(hashes) > 0 {
+		return r.setRuleGroupHashes(absencePromRule, hashes)
+	}
+	return nil
+}
+
+// splitRuleGroups splits AbsenceRuleGroups into chunks whose JSON encoding does not
+// exceed maxSize bytes. An AbsenceRuleGroup that exceeds maxSize on its own gets a chunk
+// of its own.
+func splitRuleGroups(ruleGroups []monitoringv1.RuleGroup, maxSize int) ([][]monitoringv1.RuleGroup, error) {
+	var result [][]monitoringv1.RuleGroup
+	var chunk []monitoringv1.RuleGroup
+	size := 0
+	for _, g := range ruleGroups {
+		b, err := json.Marshal(g)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunk) > 0 && size+len(b) > maxSize {
+			result = append(result, chunk)
+			chunk = nil
+			size = 0
+		}
+		chunk = append(chunk, g)
+		size += len(b)
+	}
+	if len(chunk) > 0 {
+		result = append(result, chunk)
+	}
+	return result, nil
+}
+
+// writeSplitAbsencePrometheusRule splits an AbsencePrometheusRule into parts that do not
+// exceed MaxAbsencePrometheusRuleSize and creates or updates them. The first chunk stays
+// in the AbsencePrometheusRule itself. Parts that are no longer needed are deleted.
+//
+// The existing resources are the unmodified AbsencePrometheusRule (if it exists) and its
+// parts.
+func (r *PrometheusRuleReconciler) writeSplitAbsencePrometheusRule(
+	ctx context.Context,
+	absencePromRule *monitoringv1.PrometheusRule,
+	existing []*monitoringv1.PrometheusRule,
+) error {
+
+	existingByName := make(map[string]*monitoringv1.PrometheusRule, len(existing))
+	for _, aPR := range existing {
+		existingByName[aPR.GetName()] = aPR
+	}
+
+	r.sortRuleGroups(absencePromRule)
+	chunks, err := splitRuleGroups(absencePromRule.Spec.Groups, r.MaxAbsencePrometheusRuleSize)
+	if err != nil {
+		return err
+	}
+	updatedAtKey := r.annotationKey(annotationOperatorUpdatedAt)
+	for i, ruleGroups := range chunks {
+		name := absencePromRule.GetName()
+		if i > 0 {
+			name = absencePrometheusRulePartName(name, i)
+		}
+		old := existingByName[name]
+		delete(existingByName, name)
+
+		var aPR *monitoringv1.PrometheusRule
+		if old != nil {
+			aPR = old.DeepCopy()
+		} else {
+			aPR = &monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: absencePromRule.GetNamespace()},
+			}
+		}
+		aPR.Labels = make(map[string]string, len(absencePromRule.Labels))
+		for k, v := range absencePromRule.Labels {
+			aPR.Labels[k] = v
+		}
+		aPR.Annotations = make(map[string]string, len(absencePromRule.Annotations))
+		for k, v := range absencePromRule.Annotations {
+			aPR.Annotations[k] = v
+		}
+		// Each part keeps its own update time.
+		delete(aPR.Annotations, updatedAtKey)
+		if old != nil {
+			if v, ok := old.Annotations[updatedAtKey]; ok {
+				aPR.Annotations[updatedAtKey] = v
+			}
+		}
+		aPR.Spec.Groups = ruleGroups
+		if err := r.pruneRuleGroupSources(aPR); err != nil {
+			return err
+		}
+		if err := r.pruneRuleGroupHashes(aPR); err != nil {
+			return err
+		}
+
+		switch {
+		case old == nil:
+			err = r.createAbsencePrometheusRule(ctx, aPR)
+		case reflect.DeepEqual(old.Labels, aPR.Labels) &&
+			reflect.DeepEqual(old.Annotations, aPR.Annotations) &&
+			reflect.DeepEqual(old.Spec.Groups, aPR.Spec.Groups):
+			continue
+		default:
+			err = r.patchAbsencePrometheusRule(ctx, aPR, old)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete the parts that are no longer needed.
+	for _, aPR := range existing {
+		if _, ok := existingByName[aPR.GetName()]; !ok {
+			continue
+		}
+		// The absence alert rules of the part were moved to the other parts, therefore
+		// they do not count as deleted.
+		obsolete := aPR.DeepCopy()
+		obsolete.Spec.Groups = nil
+		if err := r.deleteAbsencePrometheusRule(ctx, obsolete); err != nil {
+			return err
+		}
+	}
+	return nil
+}
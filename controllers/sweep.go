@@ -0,0 +1,59 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"time"
+)
+
+// SweepOrphans cleans up all AbsencePrometheusRules that are managed by this operator
+// instance, i.e. it removes the absence alert rules whose PrometheusRule no longer exists.
+// This catches orphans that were missed by the reconciliation, e.g. due to dropped watch
+// events. Errors for individual resources are logged and do not stop the sweep.
+func (r *PrometheusRuleReconciler) SweepOrphans(ctx context.Context) error {
+	absencePromRules, err := r.listAbsencePrometheusRules(ctx, r.managedBySelector())
+	if err != nil {
+		return err
+	}
+
+	for _, aPR := range absencePromRules {
+		if !r.isManaged(aPR) || r.isUnadopted(aPR) {
+			continue
+		}
+		if err := r.cleanUpAbsencePrometheusRule(ctx, aPR); err != nil {
+			r.Log.Error(err, "could not clean up AbsencePrometheusRule",
+				"name", aPR.GetName(), "namespace", aPR.GetNamespace())
+		}
+	}
+	return nil
+}
+
+// sweepOrphansPeriodically runs SweepOrphans() every OrphanSweepInterval until the
+// context is canceled.
+func (r *PrometheusRuleReconciler) sweepOrphansPeriodically(ctx context.Context) error {
+	ticker := time.NewTicker(r.OrphanSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.SweepOrphans(ctx); err != nil {
+				r.Log.Error(err, "could not sweep orphaned absence alert rules")
+			}
+		}
+	}
+}
@@ -16,6 +16,8 @@ package controllers
 
 import (
 	"strconv"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 )
 
 // parseBool is a wrapper around strconv.ParseBool() that returns false in case of an
@@ -27,3 +29,9 @@ func parseBool(str string) bool {
 	}
 	return v
 }
+
+// isFrozen reports whether the absence alert rules of a PrometheusRule are frozen (see
+// disableValueFreeze).
+func isFrozen(promRule *monitoringv1.PrometheusRule) bool {
+	return promRule.GetLabels()[labelOperatorDisable] == disableValueFreeze
+}
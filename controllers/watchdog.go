@@ -0,0 +1,138 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// watchdogRuleGroupName is the name of the rule group that holds the watchdog alert rule
+// (see WatchdogAlertName). It does not have the 'promRuleName/groupName' format of
+// absence rule groups since it does not belong to any PrometheusRule.
+const watchdogRuleGroupName = "absent-metrics-operator-watchdog"
+
+// watchdogSeverity is the severity of the watchdog alert rule. It is the same as that of
+// the Watchdog alert of the kube-prometheus stack.
+const watchdogSeverity = "none"
+
+// hasWatchdogRuleGroup reports whether the watchdog rule group is written for an
+// AbsencePrometheusRule, i.e. whether WatchdogAlertName is set and the
+// AbsencePrometheusRule has any absence rule groups.
+func (r *PrometheusRuleReconciler) hasWatchdogRuleGroup(absencePromRule *monitoringv1.PrometheusRule) bool {
+	return r.WatchdogAlertName != "" && len(absencePromRule.Spec.Groups) > 0
+}
+
+// updateWatchdogAnnotation records the name of the watchdog alert rule that is written
+// for an AbsencePrometheusRule in the 'absent-metrics-operator/watchdog' annotation.
+// Since the watchdog rule group itself is removed when an AbsencePrometheusRule is read
+// (see withoutWatchdogRuleGroup()), the annotation is what reveals that the watchdog
+// alert rule was enabled, disabled, or renamed since the AbsencePrometheusRule was last
+// written.
+func (r *PrometheusRuleReconciler) updateWatchdogAnnotation(absencePromRule *monitoringv1.PrometheusRule) {
+	key := r.annotationKey(annotationWatchdog)
+	if !r.hasWatchdogRuleGroup(absencePromRule) {
+		delete(absencePromRule.Annotations, key)
+		return
+	}
+	if absencePromRule.Annotations == nil {
+		absencePromRule.Annotations = make(map[string]string)
+	}
+	absencePromRule.Annotations[key] = r.WatchdogAlertName
+}
+
+// withWatchdogRuleGroup returns the AbsencePrometheusRule that is written for the given
+// one: a copy with the 'absent-metrics-operator/watchdog' annotation (see
+// updateWatchdogAnnotation()) and, if WatchdogAlertName is set and the
+// AbsencePrometheusRule has any absence rule groups, the watchdog rule group.
+func (r *PrometheusRuleReconciler) withWatchdogRuleGroup(absencePromRule *monitoringv1.PrometheusRule) *monitoringv1.PrometheusRule {
+	out := absencePromRule.DeepCopy()
+	r.updateWatchdogAnnotation(out)
+	if r.hasWatchdogRuleGroup(absencePromRule) {
+		out.Spec.Groups = append(out.Spec.Groups, r.watchdogRuleGroup(absencePromRule, r.WatchdogAlertName))
+	}
+	return out
+}
+
+// withStoredWatchdogRuleGroup is the reverse of withoutWatchdogRuleGroup() for an
+// AbsencePrometheusRule that was read from the cluster: it returns a copy with the
+// watchdog rule group that was last written according to the
+// 'absent-metrics-operator/watchdog' annotation, if any. This is used as the base of
+// patches so that removing the watchdog rule group is part of the patch.
+func (r *PrometheusRuleReconciler) withStoredWatchdogRuleGroup(absencePromRule *monitoringv1.PrometheusRule) *monitoringv1.PrometheusRule {
+	alertName := absencePromRule.GetAnnotations()[r.annotationKey(annotationWatchdog)]
+	if alertName == "" || len(absencePromRule.Spec.Groups) == 0 {
+		return absencePromRule
+	}
+	out := absencePromRule.DeepCopy()
+	out.Spec.Groups = append(out.Spec.Groups, r.watchdogRuleGroup(absencePromRule, alertName))
+	return out
+}
+
+// watchdogRuleGroup returns the rule group with the watchdog alert rule for an
+// AbsencePrometheusRule. The alert rule always fires so that the receivers of absence
+// alerts can confirm that the absence alert rules are loaded and evaluated.
+func (r *PrometheusRuleReconciler) watchdogRuleGroup(absencePromRule *monitoringv1.PrometheusRule, alertName string) monitoringv1.RuleGroup {
+	labels := map[string]string{
+		"context":  "absent-metrics",
+		"severity": watchdogSeverity,
+	}
+	for k, v := range r.ParseOpts.StaticLabels {
+		labels[k] = v
+	}
+	// The watchdog alert is routed like the absence alerts of the AbsencePrometheusRule.
+	l := absencePromRule.GetLabels()
+	for k, v := range map[string]string{
+		LabelSupportGroup: l[LabelCCloudSupportGroup],
+		LabelTier:         l[LabelTier],
+		LabelService:      l[LabelCCloudService],
+	} {
+		if r.KeepLabel[k] && v != "" {
+			labels[k] = v
+		}
+	}
+
+	return monitoringv1.RuleGroup{
+		Name: watchdogRuleGroupName,
+		Rules: []monitoringv1.Rule{{
+			Alert:  alertName,
+			Expr:   intstr.FromString("vector(1)"),
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary": "absence alert rules are evaluated",
+				"description": fmt.Sprintf(
+					"This alert is always firing to confirm that the absence alert rules in %s/%s are loaded and evaluated. "+
+						"See <https://github.com/sapcc/absent-metrics-operator/blob/master/docs/playbook.md|the operator playbook>.",
+					absencePromRule.GetNamespace(), absencePromRule.GetName()),
+			},
+		}},
+	}
+}
+
+// withoutWatchdogRuleGroup removes the watchdog rule group from an AbsencePrometheusRule
+// that was read from the cluster. The rule group is only added when the
+// AbsencePrometheusRule is written (see withWatchdogRuleGroup()) so that it is never
+// mistaken for an absence rule group.
+func withoutWatchdogRuleGroup(absencePromRule *monitoringv1.PrometheusRule) {
+	groups := absencePromRule.Spec.Groups[:0]
+	for _, g := range absencePromRule.Spec.Groups {
+		if g.Name != watchdogRuleGroupName {
+			groups = append(groups, g)
+		}
+	}
+	absencePromRule.Spec.Groups = groups
+}
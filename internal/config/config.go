@@ -0,0 +1,203 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config loads the options of the operator from a YAML file. The keys of the
+// file are the names of the command-line flags and the values use the same format as
+// the flags, except that lists and maps can also be written as YAML sequences and
+// mappings:
+//
+//	fallback-prometheus-server: openstack
+//	keep-labels: [support_group, service, tier]
+//	default-severity: warning
+//	min-for: 10m
+//	static-labels:
+//	  team: monitoring
+//
+// Flags that are given on the command line take precedence over the file.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Apply reads the YAML file at the given path and sets the flags of the FlagSet to its
+// values. Flags that were already set explicitly, i.e. on the command line, are left as
+// is. The FlagSet must have been parsed before. An error is returned for unknown keys
+// and invalid values.
+func Apply(fs *flag.FlagSet, path string) error {
+	values, err := Load(path)
+	if err != nil {
+		return err
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q in %s", name, path)
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("invalid value for option %q in %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
+
+// Load reads the YAML file at the given path and returns its options as flag values.
+func Load(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(path, b)
+}
+
+func parse(path string, b []byte) (map[string]string, error) {
+	var raw map[string]any
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, v := range raw {
+		s, err := flagValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for option %q in %s: %w", name, path, err)
+		}
+		values[name] = s
+	}
+	return values, nil
+}
+
+// File is a config file whose options were applied to a FlagSet (see Open()). Unlike
+// Apply(), it remembers which flags were given on the command line, so that the file can
+// be read again later (see Values() and Watch()).
+type File struct {
+	path string
+	fs   *flag.FlagSet
+	// explicit contains the values of the flags that were given on the command line.
+	explicit map[string]string
+}
+
+// Open applies the YAML file at the given path to the flags of the FlagSet like Apply()
+// does and returns the File for it.
+func Open(fs *flag.FlagSet, path string) (*File, error) {
+	f := &File{path: path, fs: fs, explicit: make(map[string]string)}
+	fs.Visit(func(fl *flag.Flag) {
+		f.explicit[fl.Name] = fl.Value.String()
+	})
+	if err := Apply(fs, path); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Values reads the file again and returns the resulting values of all flags of the
+// FlagSet: the value from the command line if the flag was given there, the value from
+// the file if it has one, and the default value of the flag otherwise. The flags
+// themselves are not changed.
+func (f *File) Values() (map[string]string, error) {
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	return f.values(b)
+}
+
+func (f *File) values(b []byte) (map[string]string, error) {
+	fileValues, err := parse(f.path, b)
+	if err != nil {
+		return nil, err
+	}
+	for name := range fileValues {
+		if f.fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown option %q in %s", name, f.path)
+		}
+	}
+
+	values := make(map[string]string)
+	f.fs.VisitAll(func(fl *flag.Flag) {
+		if v, ok := f.explicit[fl.Name]; ok {
+			values[fl.Name] = v
+		} else if v, ok := fileValues[fl.Name]; ok {
+			values[fl.Name] = v
+		} else {
+			values[fl.Name] = fl.DefValue
+		}
+	})
+	return values, nil
+}
+
+// flagValue converts a YAML value into the format of a flag value. Sequences become
+// comma-separated lists and mappings become comma-separated key=value pairs.
+func flagValue(v any) (string, error) {
+	switch v := v.(type) {
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, e := range v {
+			s, err := scalarValue(e)
+			if err != nil {
+				return "", err
+			}
+			list = append(list, s)
+		}
+		return strings.Join(list, ","), nil
+	case map[string]any:
+		list := make([]string, 0, len(v))
+		for k, e := range v {
+			s, err := scalarValue(e)
+			if err != nil {
+				return "", err
+			}
+			list = append(list, k+"="+s)
+		}
+		sort.Strings(list)
+		return strings.Join(list, ","), nil
+	default:
+		return scalarValue(v)
+	}
+}
+
+func scalarValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unsupported value: %v", v)
+	}
+}
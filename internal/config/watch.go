@@ -0,0 +1,100 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+// reloadDelay is the time that Watch() waits after the last change to the directory of
+// the file before it reads the file again. Editors and ConfigMap updates usually cause
+// several events in quick succession and the file can be empty or missing in between.
+const reloadDelay = 100 * time.Millisecond
+
+// Watch calls onChange with the new values of the flags (see Values()) whenever the
+// content of the file changes, until the context is done. The directory of the file is
+// watched so that files which are replaced rather than written, e.g. mounted ConfigMaps,
+// are also noticed. Invalid content is logged and otherwise ignored.
+func (f *File) Watch(ctx context.Context, log logr.Logger, onChange func(values map[string]string)) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.Add(filepath.Dir(f.path)); err != nil {
+		return err
+	}
+	last, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	var timer *time.Timer
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error(err, "could not watch config file", "path", f.path)
+		case _, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if timer == nil {
+				timer = time.NewTimer(reloadDelay)
+			} else {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(reloadDelay)
+			}
+			reload = timer.C
+		case <-reload:
+			reload = nil
+			b, err := os.ReadFile(f.path)
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					log.Error(err, "could not read config file", "path", f.path)
+				}
+				continue
+			}
+			if bytes.Equal(b, last) {
+				continue
+			}
+			values, err := f.values(b)
+			if err != nil {
+				log.Error(err, "could not reload config file", "path", f.path)
+				continue
+			}
+			last = b
+			onChange(values)
+		}
+	}
+}
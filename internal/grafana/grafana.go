@@ -0,0 +1,327 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grafana implements the 'grafana' subcommand of the operator. It converts the
+// absence alert rules that are generated for the alert rules in PrometheusRules into
+// Grafana-managed alert rules. The output is a file in the format of Grafana's alerting
+// provisioning files which can be written as is or wrapped in a ConfigMap.
+package grafana
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/sapcc/absent-metrics-operator/controllers"
+)
+
+const (
+	// queryRefID and conditionRefID are the refIDs of the Prometheus query and of the
+	// threshold expression that is used as the condition of an alert rule.
+	queryRefID     = "A"
+	conditionRefID = "B"
+
+	// expressionDatasourceUID is the UID of Grafana's built-in datasource for server-side
+	// expressions.
+	expressionDatasourceUID = "__expr__"
+
+	// queryTimeRange is the time range (in seconds) of the Prometheus query. Since the
+	// query is an instant query, this only needs to cover the lookback delta of
+	// Prometheus.
+	queryTimeRange = 600
+
+	// configMapKey is the key of the provisioning file in the ConfigMap.
+	configMapKey = "absence-alert-rules.json"
+)
+
+// File is a Grafana alerting provisioning file.
+type File struct {
+	APIVersion int         `json:"apiVersion"`
+	Groups     []RuleGroup `json:"groups"`
+}
+
+// RuleGroup is a group of Grafana-managed alert rules.
+type RuleGroup struct {
+	OrgID    int64       `json:"orgId"`
+	Name     string      `json:"name"`
+	Folder   string      `json:"folder"`
+	Interval string      `json:"interval"`
+	Rules    []AlertRule `json:"rules"`
+}
+
+// AlertRule is a Grafana-managed alert rule.
+type AlertRule struct {
+	UID          string            `json:"uid"`
+	Title        string            `json:"title"`
+	Condition    string            `json:"condition"`
+	Data         []AlertQuery      `json:"data"`
+	NoDataState  string            `json:"noDataState"`
+	ExecErrState string            `json:"execErrState"`
+	For          string            `json:"for,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	IsPaused     bool              `json:"isPaused"`
+}
+
+// AlertQuery is a query or an expression of a Grafana-managed alert rule.
+type AlertQuery struct {
+	RefID             string            `json:"refId"`
+	RelativeTimeRange RelativeTimeRange `json:"relativeTimeRange"`
+	DatasourceUID     string            `json:"datasourceUid"`
+	Model             map[string]any    `json:"model"`
+}
+
+// RelativeTimeRange is the time range of an AlertQuery in seconds before the evaluation
+// time.
+type RelativeTimeRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// Opts are the options for converting absence alert rules into Grafana-managed alert
+// rules.
+type Opts struct {
+	// DatasourceUID is the UID of the Prometheus datasource in Grafana that is queried
+	// by the alert rules.
+	DatasourceUID string
+	// Folder is the title of the Grafana folder that contains the alert rules.
+	Folder string
+	// OrgID is the ID of the Grafana organization.
+	OrgID int64
+	// DefaultInterval is the evaluation interval of rule groups that don't specify one.
+	DefaultInterval string
+}
+
+// ConvertRuleGroups returns the Grafana-managed alert rules for the absence alert rules
+// in an AbsencePrometheusRule.
+func ConvertRuleGroups(absencePromRule *monitoringv1.PrometheusRule, opts Opts) []RuleGroup {
+	out := make([]RuleGroup, 0, len(absencePromRule.Spec.Groups))
+	for _, g := range absencePromRule.Spec.Groups {
+		interval := opts.DefaultInterval
+		if g.Interval != nil && *g.Interval != "" {
+			interval = string(*g.Interval)
+		}
+		rg := RuleGroup{
+			OrgID:    opts.OrgID,
+			Name:     g.Name,
+			Folder:   opts.Folder,
+			Interval: interval,
+			Rules:    make([]AlertRule, 0, len(g.Rules)),
+		}
+		for _, r := range g.Rules {
+			if r.Alert == "" {
+				continue
+			}
+			rg.Rules = append(rg.Rules, convertRule(absencePromRule.GetNamespace(), g.Name, r, opts))
+		}
+		out = append(out, rg)
+	}
+	return out
+}
+
+func convertRule(namespace, groupName string, r monitoringv1.Rule, opts Opts) AlertRule {
+	ar := AlertRule{
+		UID:       ruleUID(namespace, groupName, r),
+		Title:     r.Alert,
+		Condition: conditionRefID,
+		Data: []AlertQuery{
+			{
+				RefID:             queryRefID,
+				RelativeTimeRange: RelativeTimeRange{From: queryTimeRange},
+				DatasourceUID:     opts.DatasourceUID,
+				Model: map[string]any{
+					"refId":   queryRefID,
+					"expr":    r.Expr.String(),
+					"instant": true,
+					"range":   false,
+				},
+			},
+			{
+				RefID:         conditionRefID,
+				DatasourceUID: expressionDatasourceUID,
+				Model: map[string]any{
+					"refId":      conditionRefID,
+					"type":       "threshold",
+					"expression": queryRefID,
+					"conditions": []map[string]any{{
+						"evaluator": map[string]any{"type": "gt", "params": []float64{0}},
+					}},
+				},
+			},
+		},
+		// The absent() function only returns a result if the time series is missing.
+		// Therefore no data is the normal state of an absence alert rule.
+		NoDataState:  "OK",
+		ExecErrState: "Error",
+		Labels:       r.Labels,
+		Annotations:  r.Annotations,
+	}
+	if r.For != nil {
+		ar.For = string(*r.For)
+	}
+	return ar
+}
+
+// ruleUID returns a stable UID for an alert rule so that provisioning the same file again
+// updates the existing alert rules in Grafana instead of creating new ones.
+//
+// Multiple absence alert rules in a rule group can have the same name, e.g. the stages
+// of an escalated absence alert rule or absence alert rules for the same metric with
+// different label matchers. Grafana rejects duplicate UIDs therefore the expression and
+// the 'for' duration are part of the UID as well.
+func ruleUID(namespace, groupName string, r monitoringv1.Rule) string {
+	var forDuration string
+	if r.For != nil {
+		forDuration = string(*r.For)
+	}
+	key := strings.Join([]string{namespace, groupName, r.Alert, r.Expr.String(), forDuration}, "\x00")
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Main converts the absence alert rules that are generated for the PrometheusRules in the
+// files that are given as arguments and writes the provisioning file to the given writer
+// or to the file given with the '-output' flag. The return value is the exit code for the
+// process.
+func Main(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("grafana", flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.Usage = func() {
+		fmt.Fprintln(w, "usage: absent-metrics-operator grafana -datasource-uid <uid> [flags] <prometheusrule-file>...")
+		fs.PrintDefaults()
+	}
+	var (
+		opts      Opts
+		output    string
+		configMap string
+	)
+	fs.StringVar(&opts.DatasourceUID, "datasource-uid", "", "UID of the Prometheus datasource in Grafana.")
+	fs.StringVar(&opts.Folder, "folder", "absent-metrics-operator", "Title of the Grafana folder for the alert rules.")
+	fs.Int64Var(&opts.OrgID, "org-id", 1, "ID of the Grafana organization.")
+	fs.StringVar(&opts.DefaultInterval, "interval", "1m", "Evaluation interval for rule groups that don't specify one.")
+	fs.StringVar(&output, "output", "", "Write the result to this file instead of stdout.")
+	fs.StringVar(&configMap, "configmap", "", "Wrap the result in a ConfigMap with this name in the format 'namespace/name'.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if opts.DatasourceUID == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var cmNamespace, cmName string
+	if configMap != "" {
+		var ok bool
+		cmNamespace, cmName, ok = strings.Cut(configMap, "/")
+		if !ok || cmNamespace == "" || cmName == "" {
+			fmt.Fprintf(w, "ERROR: invalid value for '-configmap', expected 'namespace/name': %q\n", configMap)
+			return 2
+		}
+	}
+
+	r := &controllers.PrometheusRuleReconciler{
+		Log: logr.Discard(),
+		KeepLabel: controllers.KeepLabel{
+			controllers.LabelSupportGroup: true,
+			controllers.LabelTier:         true,
+			controllers.LabelService:      true,
+		},
+	}
+	file := File{APIVersion: 1}
+	for _, path := range fs.Args() {
+		groups, err := convertFile(r, path, opts)
+		if err != nil {
+			fmt.Fprintf(w, "ERROR: %s: %s\n", path, err.Error())
+			return 2
+		}
+		file.Groups = append(file.Groups, groups...)
+	}
+	sort.SliceStable(file.Groups, func(i, j int) bool {
+		return file.Groups[i].Name < file.Groups[j].Name
+	})
+
+	b, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		fmt.Fprintf(w, "ERROR: %s\n", err.Error())
+		return 2
+	}
+	b = append(b, '\n')
+	if configMap != "" {
+		b, err = wrapInConfigMap(cmNamespace, cmName, b)
+		if err != nil {
+			fmt.Fprintf(w, "ERROR: %s\n", err.Error())
+			return 2
+		}
+	}
+
+	if output == "" {
+		_, err = w.Write(b)
+	} else {
+		err = os.WriteFile(output, b, 0o644) //nolint:gosec // not a secret
+	}
+	if err != nil {
+		fmt.Fprintf(w, "ERROR: %s\n", err.Error())
+		return 2
+	}
+	return 0
+}
+
+// convertFile returns the Grafana-managed alert rules for the absence alert rules that
+// the operator would generate for the PrometheusRule in the given file.
+func convertFile(r *controllers.PrometheusRuleReconciler, path string, opts Opts) ([]RuleGroup, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pr monitoringv1.PrometheusRule
+	if err := yaml.Unmarshal(b, &pr); err != nil {
+		return nil, err
+	}
+	if pr.GetName() == "" {
+		return nil, errors.New("not a PrometheusRule")
+	}
+
+	absencePromRule, err := r.GenerateAbsencePrometheusRule(&pr)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertRuleGroups(absencePromRule, opts), nil
+}
+
+// wrapInConfigMap returns the manifest of a ConfigMap that contains the given
+// provisioning file.
+func wrapInConfigMap(namespace, name string, file []byte) ([]byte, error) {
+	cm := corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Data: map[string]string{configMapKey: string(file)},
+	}
+	return yaml.Marshal(cm)
+}
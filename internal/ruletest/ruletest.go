@@ -0,0 +1,313 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ruletest implements the 'test' subcommand of the operator. It checks whether
+// the absence alert rules that are generated for the alert rules in a PrometheusRule
+// would fire for a given set of input series.
+//
+// The absence alert rules are generated with the options of the operator (i.e. the
+// same flags) and evaluated with the rule engine of Prometheus, like the unit tests of
+// promtool. The format of the test files is modeled after these:
+//
+//	rule_files:
+//	  - rules.yaml # PrometheusRule manifest, relative to the test file
+//	evaluation_interval: 1m
+//	tests:
+//	  - name: foo_bar is missing
+//	    input_series:
+//	      - series: 'bar_foo{job="a"}'
+//	        values: '1+0x30'
+//	    alert_rule_test:
+//	      - eval_time: 20m
+//	        exp_alerts:
+//	          - AbsentFooBar
+package ruletest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/rules"
+	"sigs.k8s.io/yaml"
+
+	"github.com/sapcc/absent-metrics-operator/controllers"
+)
+
+type testFile struct {
+	RuleFiles          []string    `json:"rule_files"`
+	EvaluationInterval string      `json:"evaluation_interval"`
+	Tests              []testGroup `json:"tests"`
+}
+
+type testGroup struct {
+	Name          string          `json:"name"`
+	InputSeries   []seriesDesc    `json:"input_series"`
+	AlertRuleTest []alertTestCase `json:"alert_rule_test"`
+}
+
+type seriesDesc struct {
+	Series string `json:"series"`
+	Values string `json:"values"`
+}
+
+type alertTestCase struct {
+	EvalTime  string   `json:"eval_time"`
+	ExpAlerts []string `json:"exp_alerts"`
+}
+
+// ruleFile is a Prometheus rule file.
+type ruleFile struct {
+	Groups []monitoringv1.RuleGroup `json:"groups"`
+}
+
+// Main runs the test files that are given as arguments and writes the results to the
+// given writer. The absence alert rules are generated with the options of the given
+// reconciler. The return value is the exit code for the process.
+func Main(r *controllers.PrometheusRuleReconciler, args []string, w io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(w, "usage: absent-metrics-operator test [flags] <test-file>...")
+		return 2
+	}
+
+	exitCode := 0
+	for _, path := range args {
+		ok, err := RunFile(r, path, w)
+		if err != nil {
+			fmt.Fprintf(w, "ERROR: %s: %s\n", path, err.Error())
+			return 2
+		}
+		if !ok {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// RunFile runs the tests in a test file and writes the results to the given writer. The
+// absence alert rules are generated with the options of the given reconciler. It reports
+// whether all tests passed.
+func RunFile(r *controllers.PrometheusRuleReconciler, path string, w io.Writer) (bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	var tf testFile
+	if err := yaml.UnmarshalStrict(b, &tf); err != nil {
+		return false, err
+	}
+
+	interval := time.Minute
+	if tf.EvaluationInterval != "" {
+		d, err := model.ParseDuration(tf.EvaluationInterval)
+		if err != nil {
+			return false, fmt.Errorf("invalid evaluation_interval: %w", err)
+		}
+		interval = time.Duration(d)
+	}
+	if interval <= 0 {
+		return false, errors.New("evaluation_interval must be positive")
+	}
+
+	// The absence alert rules are written to Prometheus rule files so that they are
+	// loaded exactly like Prometheus would load them.
+	dir, err := os.MkdirTemp("", "absent-metrics-operator-test")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+	ruleFiles := make([]string, 0, len(tf.RuleFiles))
+	for i, rf := range tf.RuleFiles {
+		if !filepath.IsAbs(rf) {
+			rf = filepath.Join(filepath.Dir(path), rf)
+		}
+		out := filepath.Join(dir, fmt.Sprintf("%d.yaml", i))
+		if err := writeAbsenceRuleFile(r, rf, out); err != nil {
+			return false, fmt.Errorf("%s: %w", rf, err)
+		}
+		ruleFiles = append(ruleFiles, out)
+	}
+
+	allPassed := true
+	for _, tg := range tf.Tests {
+		passed, err := runTestGroup(w, tg, ruleFiles, interval)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", tg.Name, err)
+		}
+		allPassed = allPassed && passed
+	}
+	return allPassed, nil
+}
+
+// writeAbsenceRuleFile writes the absence alert rules that the operator would generate
+// for the PrometheusRule in the given file to a Prometheus rule file.
+func writeAbsenceRuleFile(r *controllers.PrometheusRuleReconciler, path, out string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var pr monitoringv1.PrometheusRule
+	if err := yaml.Unmarshal(b, &pr); err != nil {
+		return err
+	}
+	if pr.GetName() == "" {
+		return errors.New("not a PrometheusRule")
+	}
+
+	absencePromRule, err := r.GenerateAbsencePrometheusRule(&pr)
+	if err != nil {
+		return err
+	}
+	b, err = yaml.Marshal(ruleFile{Groups: absencePromRule.Spec.Groups})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, b, 0o600)
+}
+
+func runTestGroup(w io.Writer, tg testGroup, ruleFiles []string, interval time.Duration) (bool, error) {
+	var load strings.Builder
+	fmt.Fprintf(&load, "load %s\n", model.Duration(interval))
+	for _, sd := range tg.InputSeries {
+		fmt.Fprintf(&load, "  %s %s\n", sd.Series, sd.Values)
+	}
+	suite, err := promql.NewLazyLoader(nil, load.String(), promql.LazyLoaderOpts{
+		EnableAtModifier:     true,
+		EnableNegativeOffset: true,
+	})
+	if err != nil {
+		return false, fmt.Errorf("invalid input series: %w", err)
+	}
+	defer suite.Close()
+	suite.SubqueryInterval = interval
+
+	m := rules.NewManager(&rules.ManagerOptions{
+		QueryFunc:  rules.EngineQueryFunc(suite.QueryEngine(), suite.Storage()),
+		Appendable: suite.Storage(),
+		Context:    context.Background(),
+		NotifyFunc: func(context.Context, string, ...*rules.Alert) {},
+		Logger:     log.NewNopLogger(),
+	})
+	groupsMap, errs := m.LoadGroups(interval, nil, "", nil, ruleFiles...)
+	if len(errs) > 0 {
+		return false, errors.Join(errs...)
+	}
+	groups := make([]*rules.Group, 0, len(groupsMap))
+	for _, g := range groupsMap {
+		for _, r := range g.Rules() {
+			if ar, ok := r.(*rules.AlertingRule); ok {
+				// Otherwise the 'for' duration would be ignored in the first evaluation
+				// (see rules.AlertingRule.Restored).
+				ar.SetRestored(true)
+			}
+		}
+		groups = append(groups, g)
+	}
+
+	evalTimes := make([]time.Duration, len(tg.AlertRuleTest))
+	var maxEvalTime time.Duration
+	for i, tc := range tg.AlertRuleTest {
+		d, err := model.ParseDuration(tc.EvalTime)
+		if err != nil {
+			return false, fmt.Errorf("invalid eval_time: %w", err)
+		}
+		evalTimes[i] = time.Duration(d)
+		if evalTimes[i] > maxEvalTime {
+			maxEvalTime = evalTimes[i]
+		}
+	}
+
+	// The rules are evaluated at each evaluation interval. The alerts for an eval_time
+	// are those of the last evaluation at or before it, like in the unit tests of
+	// promtool.
+	got := make([][]string, len(tg.AlertRuleTest))
+	mint := time.Unix(0, 0).UTC()
+	for ts := mint; !ts.After(mint.Add(maxEvalTime)); ts = ts.Add(interval) {
+		var evalErr error
+		suite.WithSamplesTill(ts, func(err error) {
+			if err != nil {
+				evalErr = err
+				return
+			}
+			for _, g := range groups {
+				g.Eval(suite.Context(), ts)
+				for _, r := range g.Rules() {
+					if err := r.LastError(); err != nil {
+						evalErr = fmt.Errorf("rule %s at %s: %w", r.Name(), ts.Sub(mint), err)
+						return
+					}
+				}
+			}
+		})
+		if evalErr != nil {
+			return false, evalErr
+		}
+
+		for i, t := range evalTimes {
+			if t < ts.Sub(mint) || t >= ts.Add(interval).Sub(mint) {
+				continue
+			}
+			got[i] = firingAlerts(groups)
+		}
+	}
+
+	var failures []string
+	for i, tc := range tg.AlertRuleTest {
+		exp := append([]string(nil), tc.ExpAlerts...)
+		sort.Strings(exp)
+		if strings.Join(got[i], ",") != strings.Join(exp, ",") {
+			failures = append(failures, fmt.Sprintf("  eval_time: %s\n    expected: [%s]\n    got:      [%s]",
+				tc.EvalTime, strings.Join(exp, ", "), strings.Join(got[i], ", ")))
+		}
+	}
+
+	if len(failures) > 0 {
+		fmt.Fprintf(w, "FAIL: %s\n%s\n", tg.Name, strings.Join(failures, "\n"))
+		return false, nil
+	}
+	fmt.Fprintf(w, "PASS: %s\n", tg.Name)
+	return true, nil
+}
+
+// firingAlerts returns the sorted names of the alerting rules in the given groups that
+// have at least one firing alert.
+func firingAlerts(groups []*rules.Group) []string {
+	var result []string
+	for _, g := range groups {
+		for _, r := range g.Rules() {
+			ar, ok := r.(*rules.AlertingRule)
+			if !ok {
+				continue
+			}
+			for _, a := range ar.ActiveAlerts() {
+				if a.State == rules.StateFiring {
+					result = append(result, ar.Name())
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -15,10 +15,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	_ "go.uber.org/automaxprocs"
 
@@ -27,20 +35,33 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/common/model"
 	"github.com/sapcc/go-api-declarations/bininfo"
 	"go.uber.org/zap/zapcore"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	"github.com/sapcc/absent-metrics-operator/controllers"
+	"github.com/sapcc/absent-metrics-operator/internal/config"
+	"github.com/sapcc/absent-metrics-operator/internal/grafana"
+	"github.com/sapcc/absent-metrics-operator/internal/ruletest"
 	//+kubebuilder:scaffold:imports
 )
 
+// generateAPIPath is the path of the endpoint that is enabled by the
+// '-enable-generate-api' flag.
+const generateAPIPath = "/generate"
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -54,12 +75,102 @@ func init() {
 }
 
 func main() {
+	// The 'test' subcommand accepts the same flags as the operator so that the absence
+	// alert rules are generated with the same options.
+	var testMode bool
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "test":
+			testMode = true
+		case "grafana":
+			os.Exit(grafana.Main(os.Args[2:], os.Stdout))
+		}
+	}
+
 	var (
+		configFile           string
 		debug                bool
 		metricsAddr          string
+		secureMetrics        bool
 		probeAddr            string
 		enableLeaderElection bool
-		keepLabel            labelsMap
+		keepLabel            keepLabelFlag
+		fallbackPromServer   string
+		includeSourceExpr    bool
+		includeSourceGroup   bool
+		annotateVersion      bool
+		staticLabels         labelValuesMap
+		minFor               time.Duration
+		minGroupInterval     time.Duration
+		preserveMatchers     bool
+		keepEmpty            bool
+		defaultSeverity      string
+		severityConfigMap    namespacedName
+		skipLabels           labelValuesMap
+		managedByLabel       string
+		excludeUpLike        bool
+		upLikeRegex          string
+		recordSources        bool
+		copyAnnotations      labelsMap
+		eventInterval        time.Duration
+		sourceURLTemplate    string
+		queryURLTemplate     string
+		createDelay          time.Duration
+		skipSeverities       labelsMap
+		aggregation          string
+		instanceID           string
+		renameLabels         labelValuesMap
+		severityOrder        stringList
+		skipLocalRecorded    bool
+		promServerLabel      bool
+		promServerLabelKey   string
+		promServerDelimiter  string
+		missingLabels        string
+		missingPlaceholder   string
+		orphanSweepInterval  time.Duration
+		requeueAfter         time.Duration
+		maxConcurrent        int
+		nsRateLimit          float64
+		nsRateBurst          int
+		groupingLabel        string
+		groupingLabelTmpl    string
+		typeLabelTmpl        string
+		emptyResultThreshold int
+		alertNameStyle       string
+		excludeOwnerKinds    labelsMap
+		excludeLabels        labelValuesMap
+		excludeAnnotations   labelValuesMap
+		excludeUnlabeled     bool
+		originalSeverity     bool
+		adoptExisting        bool
+		enableGenerateAPI    bool
+		noLabelInference     bool
+		forBySeverity        durationMap
+		sanitizeLabelValues  bool
+		optionalConfigMap    namespacedName
+		annotationPrefix     string
+		skipColonMetrics     bool
+		skipVectorUnless     bool
+		alertOnUp            bool
+		hashRuleGroups       bool
+		liftMatcherLabels    labelsMap
+		once                 bool
+		forceRelabel         bool
+		output               string
+		duplicateAlertNames  string
+		skipHandWritten      bool
+		watchdogAlertName    string
+		labelPrecedence      string
+		retainLabels         bool
+		maxAbsenceRuleSize   int
+		stripLabels          labelsMap
+		allowedLabels        labelsMap
+		escalationSeverity   string
+		ownerMappingFile     string
+		ownerLabel           string
+		recordMissing        bool
+		maxRulesPerSource    int
+		ruleOrder            string
 	)
 	flag.BoolVar(&debug, "debug", false, "Alias for '-zap-devel' flag.")
 	// Port `9659` has been allocated for absent metrics operator: https://github.com/prometheus/prometheus/wiki/Default-port-allocations
@@ -69,51 +180,602 @@ func main() {
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.Var(&keepLabel, "keep-labels", "A comma-separated list of labels to retain from the original alert rule. "+
+		"Any label can be retained, therefore misspelled label names are not rejected. "+
 		fmt.Sprintf("(default '%s,%s,%s')", controllers.LabelSupportGroup, controllers.LabelTier, controllers.LabelService))
+	flag.StringVar(&fallbackPromServer, "fallback-prometheus-server", "",
+		"The Prometheus server name to use for PrometheusRules that do not have a 'prometheus' label. "+
+			"If empty, such resources are not processed.")
+	flag.BoolVar(&keepEmpty, "keep-empty-absence-rules", false,
+		"Keep AbsencePrometheusRules that no longer have any absence alert rules instead of deleting them.")
+	flag.BoolVar(&includeSourceExpr, "include-source-expr", false,
+		"Add the expression of the original alert rule as an annotation to its absence alert rules.")
+	flag.BoolVar(&includeSourceGroup, "include-source-group", false,
+		"Add the name of the rule group of the original alert rule as an annotation to its absence alert rules.")
+	flag.BoolVar(&annotateVersion, "annotate-version", false, "Add the version of the operator as the "+
+		"'<annotation-prefix>/generated-by-version' annotation to absence alert rules. Absence alert rules are "+
+		"regenerated after each upgrade then.")
+	flag.Var(&staticLabels, "static-labels", "A comma-separated list of key=value pairs that are added as labels to all "+
+		"absence alert rules. These override the default labels but not the labels retained from the original alert rule.")
+	flag.DurationVar(&minFor, "min-for", 0, "The minimum duration for the 'for' field of absence alert rules.")
+	flag.DurationVar(&minGroupInterval, "min-group-interval", 0, "The minimum evaluation interval of absence rule groups. "+
+		"The interval of the original rule group is raised to this minimum if it is lower.")
+	flag.BoolVar(&preserveMatchers, "preserve-matchers", false,
+		"Retain the label matchers that are used for a metric in the original alert rule in the expression of its absence alert rule.")
+	flag.StringVar(&defaultSeverity, "default-severity", "info", "The value of the 'severity' label of absence alert rules.")
+	flag.Var(&severityConfigMap, "severity-configmap", "A ConfigMap (in the format 'namespace/name') that maps namespaces "+
+		"to the value of the 'severity' label of absence alert rules in that namespace. Overrides '-default-severity'.")
+	flag.Var(&skipLabels, "skip-labels", "A comma-separated list of key=value pairs that mark metrics from external "+
+		"sources (e.g. federation). Metrics that are selected with a matching label matcher do not get absence alert rules.")
+	flag.StringVar(&managedByLabel, "managed-by-label", "absent-metrics-operator/managed-by",
+		"The key of the label that identifies AbsencePrometheusRules which are managed by this operator instance.")
+	flag.BoolVar(&excludeUpLike, "exclude-up-like-metrics", false,
+		"Do not generate absence alert rules for metrics that match '-up-like-metrics-regex'.")
+	flag.StringVar(&upLikeRegex, "up-like-metrics-regex", ".+_up",
+		"The regex (fully anchored) that is used by '-exclude-up-like-metrics' to match metric names.")
+	flag.BoolVar(&recordSources, "record-rule-group-sources", false,
+		"Record the PrometheusRule that each absence rule group was generated for in an annotation of the "+
+			"AbsencePrometheusRule, so that clean up does not depend on the names of the rule groups.")
+	flag.Var(&copyAnnotations, "copy-annotations", "A comma-separated list of annotations of a PrometheusRule "+
+		"that are copied to its absence alert rules.")
+	flag.DurationVar(&eventInterval, "event-interval", 10*time.Minute,
+		"The minimum interval between identical events that are recorded for a PrometheusRule.")
+	flag.StringVar(&sourceURLTemplate, "source-url-template", "",
+		"A template for a URL that links to the source of a PrometheusRule, e.g. a file in a Git repository. "+
+			"It can use {{.Namespace}} and {{.Name}} and is added as an annotation to absence alert rules.")
+	flag.StringVar(&queryURLTemplate, "query-url-template", "",
+		"A template for a URL that queries the metric of an absence alert rule, e.g. in the Grafana explore view. "+
+			"It can use {{.Metric}} and {{.PrometheusServer}} and is added as an annotation to absence alert rules.")
+	flag.StringVar(&groupingLabel, "grouping-label", "", "The key of a label that is added to all absence alert rules, "+
+		"e.g. so that Alertmanager groups all absence alerts of a Prometheus server into one notification.")
+	flag.StringVar(&groupingLabelTmpl, "grouping-label-template", "{{.PrometheusServer}}",
+		"A template for the value of the '-grouping-label'. It can use {{.PrometheusServer}} and {{.Namespace}}.")
+	flag.StringVar(&typeLabelTmpl, "type-label-template", "", "A template for the value of the 'type' label of "+
+		"AbsencePrometheusRules. It can use the {{.Namespace}}, {{.Name}}, {{.Labels}}, and {{.Annotations}} of the "+
+		"PrometheusRule, e.g. '{{index .Labels \"type\"}}'. If empty or if the result is empty, 'alerting-rules' is used.")
+	flag.DurationVar(&createDelay, "create-delay", 0,
+		"The minimum age of a PrometheusRule before absence alert rules are generated for it.")
+	flag.Var(&skipSeverities, "skip-severities", "A comma-separated list of values of the 'severity' label. "+
+		"No absence alert rules are generated for alert rules with one of these severities.")
+	flag.StringVar(&aggregation, "aggregation", controllers.AggregationPrometheusServer,
+		fmt.Sprintf("The granularity at which absence alert rules are aggregated into AbsencePrometheusRules: '%s' or '%s'.",
+			controllers.AggregationPrometheusServer, controllers.AggregationNamespace))
+	flag.StringVar(&instanceID, "instance-id", "", "Identifies this operator instance when multiple instances are "+
+		"deployed in the same cluster. It is used as the value of the '-managed-by-label', in the names of "+
+		"AbsencePrometheusRules, and as the field manager.")
+	flag.Var(&renameLabels, "rename-labels", "A comma-separated list of old=new pairs of label names. "+
+		"The labels of absence alert rules are renamed accordingly, e.g. for alerting pipelines that expect different label names.")
+	flag.Var(&severityOrder, "severity-order", "A comma-separated list of values of the 'severity' label from "+
+		"highest to lowest, e.g. 'critical,warning,info'. If set, absence alert rules in a rule group for the same "+
+		"metric are merged into one that inherits the labels of the alert rule with the highest severity.")
+	flag.BoolVar(&skipLocalRecorded, "skip-local-recorded-metrics", false,
+		"Do not generate absence alert rules for metrics that are recorded by a recording rule in the same PrometheusRule.")
+	flag.BoolVar(&promServerLabel, "prometheus-server-label", false,
+		"Add the Prometheus server of a PrometheusRule as the 'prometheus' label to each of its absence alert rules.")
+	flag.StringVar(&promServerLabelKey, "prometheus-server-label-key", controllers.DefaultPrometheusServerLabelKey,
+		"The key of the label that specifies the Prometheus server of PrometheusRules. It is also used for the "+
+			"label of AbsencePrometheusRules and of absence alert rules (see '-prometheus-server-label').")
+	flag.StringVar(&promServerDelimiter, "prometheus-server-delimiter", "", "Split the value of the 'prometheus' "+
+		"label of PrometheusRules at this delimiter, e.g. '.', so that a PrometheusRule can concern multiple "+
+		"Prometheus servers. Absence alert rules are then generated for each of them. If empty, the value is not split.")
+	flag.StringVar(&missingLabels, "missing-labels", controllers.MissingLabelsLog,
+		fmt.Sprintf("How absence alert rules are handled whose 'tier' or 'service' label can not be determined: "+
+			"'%s' (generate them without the label), '%s' (do not generate them), or '%s' (use '-missing-label-placeholder').",
+			controllers.MissingLabelsLog, controllers.MissingLabelsSkip, controllers.MissingLabelsPlaceholder))
+	flag.StringVar(&missingPlaceholder, "missing-label-placeholder", "unknown",
+		"The value of labels that can not be determined if '-missing-labels' is set to 'placeholder'.")
+	flag.DurationVar(&orphanSweepInterval, "orphan-sweep-interval", 0,
+		"The interval at which orphaned absence alert rules are removed from all AbsencePrometheusRules, "+
+			"independently of changes to PrometheusRules. Disabled if zero.")
+	flag.DurationVar(&requeueAfter, "requeue-after", 5*time.Minute,
+		"The interval after which a resource is reconciled again after it was reconciled successfully. "+
+			"This corrects drift, e.g. manual changes to AbsencePrometheusRules.")
+	flag.IntVar(&emptyResultThreshold, "empty-result-threshold", 1, "The number of consecutive reconciles in which "+
+		"no absence alert rules are generated for a PrometheusRule before its existing absence alert rules are removed. "+
+		"Higher values guard against transient empty results, e.g. due to a broken expression that is fixed shortly after.")
+	flag.IntVar(&maxConcurrent, "max-concurrent-reconciles", 1, "The maximum number of resources that are reconciled concurrently.")
+	flag.Float64Var(&nsRateLimit, "namespace-rate-limit", 0, "The maximum number of reconciles per second in each namespace. "+
+		"Reconciles that exceed the limit are requeued so that a namespace with many PrometheusRules does not delay "+
+		"the reconciles of other namespaces. Disabled if zero.")
+	flag.IntVar(&nsRateBurst, "namespace-rate-burst", 10,
+		"The number of reconciles in each namespace that may exceed the '-namespace-rate-limit' in a burst.")
+	flag.BoolVar(&enableGenerateAPI, "enable-generate-api", false, "Serve an HTTP endpoint at '"+generateAPIPath+
+		"' on the metrics address that accepts a PrometheusRule as JSON in a POST request and responds with the "+
+		"generated AbsencePrometheusRule. Without '-metrics-secure', the endpoint is unauthenticated and can be used "+
+		"by anyone who can reach the metrics address.")
+	flag.BoolVar(&secureMetrics, "metrics-secure", false, "Serve the metrics (and the endpoint of "+
+		"'-enable-generate-api') via HTTPS and only to clients that are authenticated and authorized by the "+
+		"Kubernetes API. This requires permission to create TokenReviews and SubjectAccessReviews.")
+	flag.BoolVar(&noLabelInference, "disable-label-inference", false, "Do not infer defaults for the support group, "+
+		"tier, and service labels from alert rules. Only the labels of a PrometheusRule itself are used as defaults.")
+	flag.Var(&forBySeverity, "for-by-severity", "A comma-separated list of severity=duration pairs (e.g. "+
+		"'critical=2m,info=15m') that specify the 'for' field of absence alert rules per severity. "+
+		"Takes precedence over '-min-for'.")
+	flag.BoolVar(&sanitizeLabelValues, "sanitize-label-values", false, "Trim the whitespace of the label values of "+
+		"absence alert rules and replace commas and control characters with underscores.")
+	flag.Var(&optionalConfigMap, "optional-metrics-configmap", "A ConfigMap (in the format 'namespace/name') that lists "+
+		"the names of metrics that are known to be optional, one per line in each value. These metrics do not get "+
+		"absence alert rules. Changes to the ConfigMap take effect without a restart.")
+	flag.StringVar(&annotationPrefix, "annotation-prefix", controllers.DefaultAnnotationPrefix,
+		"The prefix of the keys of the annotations that are managed by the operator, e.g. '<prefix>/updated-at'.")
+	flag.BoolVar(&skipColonMetrics, "skip-colon-metrics", false, "Do not generate absence alert rules for metrics "+
+		"whose name contains a colon. By convention, these are recorded by recording rules (e.g. 'job:http_requests:rate5m').")
+	flag.BoolVar(&skipVectorUnless, "skip-vector-unless", false, "Do not generate absence alert rules for metrics that "+
+		"are already checked for absence by an expression of the form 'vector(1) unless <metric>'.")
+	flag.BoolVar(&alertOnUp, "alert-on-up", false, "Generate absence alert rules for the 'up' metric if it is "+
+		"selected with label matchers, e.g. 'absent(up{job=\"x\"})' which detects a scrape target that vanished "+
+		"entirely. Requires '-preserve-matchers'. By default, the 'up' metric is always skipped.")
+	flag.BoolVar(&hashRuleGroups, "hash-rule-groups", false, "Record the hashes of the absence rule groups in an "+
+		"annotation of AbsencePrometheusRules and skip updates if the hashes of the generated rule groups match the "+
+		"stored ones.")
+	flag.Var(&liftMatcherLabels, "lift-matcher-labels", "A comma-separated list of label names whose values are "+
+		"taken from the equality matchers of a metric in the original alert rule (e.g. 'region' for foo{region=\"eu\"}) "+
+		"and added as labels to its absence alert rule.")
+	flag.BoolVar(&once, "once", false, "Reconcile all PrometheusRules once and exit instead of running continuously. "+
+		"The exit status is non-zero if any PrometheusRule could not be reconciled.")
+	flag.StringVar(&labelPrecedence, "label-precedence", controllers.LabelPrecedenceResource,
+		"Whether the labels of a PrometheusRule ('"+controllers.LabelPrecedenceResource+"') or the labels that are "+
+			"inferred from alert rules ('"+controllers.LabelPrecedenceInference+"') take precedence as defaults for "+
+			"the support group, tier, and service labels.")
+	flag.BoolVar(&retainLabels, "retain-existing-labels", false, "Use the support group, tier, and service labels "+
+		"of an existing AbsencePrometheusRule as defaults for the labels that can not be determined otherwise, "+
+		"instead of removing them.")
+	flag.IntVar(&maxAbsenceRuleSize, "max-absence-rule-size", 0, "The maximum size (in bytes) of the rule groups "+
+		"of an AbsencePrometheusRule. Larger AbsencePrometheusRules are split into numbered parts ('<name>-1', "+
+		"'<name>-2', etc.). Zero disables splitting.")
+	flag.Var(&stripLabels, "strip-labels", "A comma-separated list of label names that are never carried over from "+
+		"the original alert rule to its absence alert rules (e.g. 'instance'). This takes precedence over the "+
+		"'-keep-labels' and '-lift-matcher-labels' flags.")
+	flag.Var(&allowedLabels, "allowed-labels", "A comma-separated list of the only label names that may be carried "+
+		"over from the original alert rule to its absence alert rules, either retained, lifted, or used as a default "+
+		"for the support group, tier, and service labels. This restricts the labels that tenants can inject into "+
+		"absence alert rules regardless of the '-keep-labels' and '-lift-matcher-labels' flags. "+
+		"All labels are allowed if it is empty.")
+	flag.StringVar(&escalationSeverity, "escalation-severity", "critical", "The value of the 'severity' label of "+
+		"escalated absence alert rules. These are generated in addition to the regular ones for alert rules that have "+
+		"the '<annotation-prefix>/escalate-after' annotation.")
+	flag.StringVar(&ownerMappingFile, "owner-mapping-file", "", "A YAML file that maps namespaces to the teams that "+
+		"own them. The team is used for the '-owner-label' of absence alert rules that do not have this label otherwise. "+
+		"Changes to the file take effect without a restart.")
+	flag.StringVar(&ownerLabel, "owner-label", controllers.LabelSupportGroup,
+		"The label of absence alert rules that is set to the team from the '-owner-mapping-file'.")
+	flag.BoolVar(&recordMissing, "record-missing-metrics", false, "Add a recording rule to each AbsencePrometheusRule "+
+		"in a separate rule group that records the number of its metrics that are currently missing as "+
+		"'absence:missing_metrics:count' with an 'absence_prometheus_rule' label. It is not counted towards "+
+		"'-max-rules-per-source' and only covers the absence alert rules that were kept.")
+	flag.IntVar(&maxRulesPerSource, "max-rules-per-source", 0, "The maximum number of absence alert rules that are "+
+		"generated for a PrometheusRule. Further absence alert rules are dropped and counted by the "+
+		"'absent_metrics_operator_truncated_sources_total' metric. Zero means no limit.")
+	flag.StringVar(&ruleOrder, "rule-order", controllers.RuleOrderSorted, "The order of the absence alert rules in "+
+		"a rule group: '"+controllers.RuleOrderSorted+"' by name for stable diffs or '"+controllers.RuleOrderSource+
+		"' in the order in which their metrics appear in the original rule group.")
+	flag.BoolVar(&originalSeverity, "original-severity-label", false, "Add the 'severity' label of the original "+
+		"alert rule as the 'original_severity' label to its absence alert rules.")
+	flag.BoolVar(&adoptExisting, "adopt-existing", true, "Adopt and reconcile AbsencePrometheusRules with the "+
+		"managed-by label that were not created by this version of the operator, i.e. that do not have the "+
+		"'<annotation-prefix>/owned' annotation. If false, such resources are left alone.")
+	flag.StringVar(&alertNameStyle, "alert-name-style", controllers.AlertNameStyleTitleCase, "How the names of absence "+
+		"alert rules are generated from metric names: '"+controllers.AlertNameStyleTitleCase+"' (e.g. 'AbsentTierServiceFooBar'), '"+
+		controllers.AlertNameStyleVerbatim+"' (e.g. 'Absent_foo_bar'), or '"+controllers.AlertNameStylePrefixed+"' (e.g. 'absent_foo_bar').")
+	flag.Var(&excludeOwnerKinds, "exclude-owner-kinds", "A comma-separated list of kinds of owner references. "+
+		"PrometheusRules that are owned by a resource of one of these kinds are not processed, e.g. because "+
+		"they are generated by another operator.")
+	flag.Var(&excludeLabels, "exclude-labels", "A comma-separated list of key=value pairs of labels that exclude "+
+		"PrometheusRules from processing, e.g. 'app.kubernetes.io/managed-by=Helm'.")
+	flag.Var(&excludeAnnotations, "exclude-annotations", "A comma-separated list of key=value pairs of annotations "+
+		"that exclude PrometheusRules from processing.")
+	flag.BoolVar(&excludeUnlabeled, "exclude-namespaces-without-prometheus-label", false, "Do not process "+
+		"PrometheusRules that do not have a 'prometheus' label, e.g. in namespaces that do not follow this convention. "+
+		"Existing absence alert rules for such resources are removed. Can not be combined with '-fallback-prometheus-server'.")
+	flag.BoolVar(&forceRelabel, "force-relabel", false, "Like '-once' but also re-derive the labels of all "+
+		"AbsencePrometheusRules, e.g. to remove labels that are no longer kept after changing '-keep-labels'.")
+	flag.StringVar(&output, "output", controllers.OutputPrometheusRule, "Where the absence alert rules are written to: '"+
+		controllers.OutputPrometheusRule+"' for AbsencePrometheusRules or '"+controllers.OutputConfigMap+"' for "+
+		"ConfigMaps with the same name and labels that contain a Prometheus rule file in the '"+
+		controllers.ConfigMapRulesKey+"' key.")
+	flag.StringVar(&duplicateAlertNames, "duplicate-alert-names", controllers.DuplicateAlertNamesWarn, "How absence "+
+		"alert rules with the same name in different rule groups of an AbsencePrometheusRule are handled: '"+
+		controllers.DuplicateAlertNamesWarn+"' logs them and '"+controllers.DuplicateAlertNamesDisambiguate+
+		"' adds a number to their names, e.g. 'AbsentFooBar2'.")
+	flag.StringVar(&watchdogAlertName, "watchdog-alert-name", "", "Add an alert rule with this name that always "+
+		"fires to each AbsencePrometheusRule to confirm that its absence alert rules are loaded and evaluated. "+
+		"If empty, no such alert rule is added.")
+	flag.BoolVar(&skipHandWritten, "skip-hand-written-absence", false, "Do not generate absence alert rules that "+
+		"duplicate hand-written ones, i.e. alert rules like 'absent(foo{job=\"bar\"})' in the PrometheusRules of the "+
+		"same Prometheus server and namespace. Label matchers are compared irrespective of their order.")
+	flag.StringVar(&configFile, "config", "", "A YAML file with options for the operator. Its keys are the names of "+
+		"the other flags, e.g. 'keep-labels' or 'default-severity'. Flags that are given on the command line take "+
+		"precedence over the file.")
 	opts := zap.Options{TimeEncoder: zapcore.RFC3339TimeEncoder}
 	opts.BindFlags(flag.CommandLine)
-	flag.Parse()
+	if testMode {
+		// The error is handled by flag.ExitOnError.
+		_ = flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+
+	// The config file is loaded before the logger is set up so that it can also contain
+	// the logging options.
+	var (
+		cfgFile     *config.File
+		startValues map[string]string
+		configErr   error
+	)
+	if configFile != "" {
+		cfgFile, configErr = config.Open(flag.CommandLine, configFile)
+		if configErr == nil {
+			startValues, configErr = cfgFile.Values()
+		}
+	}
 
 	// Enabled debug mode if `-debug` flag is provided.
 	if debug {
 		opts.Development = true
 	}
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	if configErr != nil {
+		setupLog.Error(configErr, "invalid '-config' file")
+		os.Exit(1)
+	}
 
 	// Set default value for '-keep-labels' flag.
 	if len(keepLabel) == 0 {
-		keepLabel = labelsMap{
+		keepLabel = keepLabelFlag{
 			controllers.LabelSupportGroup: true,
 			controllers.LabelTier:         true,
 			controllers.LabelService:      true,
 		}
 	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme: scheme,
-		Metrics: metricsserver.Options{
-			BindAddress: metricsAddr,
+	if aggregation != controllers.AggregationPrometheusServer && aggregation != controllers.AggregationNamespace {
+		setupLog.Error(fmt.Errorf("unknown aggregation: %q", aggregation), "invalid value for '-aggregation' flag")
+		os.Exit(1)
+	}
+	if excludeUnlabeled && fallbackPromServer != "" {
+		// PrometheusRules without a 'prometheus' label are either skipped or use the
+		// fallback, not both.
+		setupLog.Error(errors.New("can not be combined with '-fallback-prometheus-server'"),
+			"invalid value for '-exclude-namespaces-without-prometheus-label' flag")
+		os.Exit(1)
+	}
+	if errs := validation.IsQualifiedName(promServerLabelKey); len(errs) > 0 {
+		setupLog.Error(fmt.Errorf("invalid label key %q: %s", promServerLabelKey, strings.Join(errs, "; ")),
+			"invalid value for '-prometheus-server-label-key' flag")
+		os.Exit(1)
+	}
+	if promServerDelimiter != "" && !regexp.MustCompile(`^[-A-Za-z0-9_.]+$`).MatchString(promServerDelimiter) {
+		// Kubernetes does not allow other characters in label values.
+		setupLog.Error(fmt.Errorf("delimiter can not occur in label values: %q", promServerDelimiter),
+			"invalid value for '-prometheus-server-delimiter' flag")
+		os.Exit(1)
+	}
+	if promServerDelimiter != "" && aggregation == controllers.AggregationNamespace {
+		// The absence alert rules for all Prometheus servers of a PrometheusRule would end
+		// up in the same rule groups of the same AbsencePrometheusRule.
+		setupLog.Error(fmt.Errorf("not supported with '-aggregation=%s'", aggregation),
+			"invalid value for '-prometheus-server-delimiter' flag")
+		os.Exit(1)
+	}
+
+	switch missingLabels {
+	case controllers.MissingLabelsLog, controllers.MissingLabelsSkip, controllers.MissingLabelsPlaceholder:
+	default:
+		setupLog.Error(fmt.Errorf("unknown mode: %q", missingLabels), "invalid value for '-missing-labels' flag")
+		os.Exit(1)
+	}
+
+	if labelPrecedence != controllers.LabelPrecedenceResource && labelPrecedence != controllers.LabelPrecedenceInference {
+		setupLog.Error(fmt.Errorf("unknown precedence: %q", labelPrecedence), "invalid value for '-label-precedence' flag")
+		os.Exit(1)
+	}
+
+	if ruleOrder != controllers.RuleOrderSorted && ruleOrder != controllers.RuleOrderSource {
+		setupLog.Error(fmt.Errorf("unknown order: %q", ruleOrder), "invalid value for '-rule-order' flag")
+		os.Exit(1)
+	}
+
+	switch alertNameStyle {
+	case controllers.AlertNameStyleTitleCase, controllers.AlertNameStyleVerbatim, controllers.AlertNameStylePrefixed:
+	default:
+		setupLog.Error(fmt.Errorf("unknown style: %q", alertNameStyle), "invalid value for '-alert-name-style' flag")
+		os.Exit(1)
+	}
+
+	if output != controllers.OutputPrometheusRule && output != controllers.OutputConfigMap {
+		setupLog.Error(fmt.Errorf("unknown output: %q", output), "invalid value for '-output' flag")
+		os.Exit(1)
+	}
+
+	if duplicateAlertNames != controllers.DuplicateAlertNamesWarn && duplicateAlertNames != controllers.DuplicateAlertNamesDisambiguate {
+		setupLog.Error(fmt.Errorf("unknown mode: %q", duplicateAlertNames), "invalid value for '-duplicate-alert-names' flag")
+		os.Exit(1)
+	}
+
+	if alertOnUp && !preserveMatchers {
+		setupLog.Error(fmt.Errorf("'-preserve-matchers' is not set"), "invalid value for '-alert-on-up' flag")
+		os.Exit(1)
+	}
+
+	if requeueAfter <= 0 {
+		setupLog.Error(fmt.Errorf("non-positive duration: %s", requeueAfter), "invalid value for '-requeue-after' flag")
+		os.Exit(1)
+	}
+
+	if minGroupInterval < 0 {
+		setupLog.Error(fmt.Errorf("negative duration: %s", minGroupInterval), "invalid value for '-min-group-interval' flag")
+		os.Exit(1)
+	}
+
+	if emptyResultThreshold < 1 {
+		setupLog.Error(fmt.Errorf("non-positive number: %d", emptyResultThreshold), "invalid value for '-empty-result-threshold' flag")
+		os.Exit(1)
+	}
+
+	if maxConcurrent < 1 {
+		setupLog.Error(fmt.Errorf("non-positive number: %d", maxConcurrent), "invalid value for '-max-concurrent-reconciles' flag")
+		os.Exit(1)
+	}
+
+	if nsRateLimit < 0 {
+		setupLog.Error(fmt.Errorf("negative rate: %g", nsRateLimit), "invalid value for '-namespace-rate-limit' flag")
+		os.Exit(1)
+	}
+
+	if nsRateBurst < 1 {
+		setupLog.Error(fmt.Errorf("non-positive number: %d", nsRateBurst), "invalid value for '-namespace-rate-burst' flag")
+		os.Exit(1)
+	}
+
+	if maxAbsenceRuleSize < 0 {
+		setupLog.Error(fmt.Errorf("negative size: %d", maxAbsenceRuleSize), "invalid value for '-max-absence-rule-size' flag")
+		os.Exit(1)
+	}
+
+	if maxRulesPerSource < 0 {
+		setupLog.Error(fmt.Errorf("negative number: %d", maxRulesPerSource), "invalid value for '-max-rules-per-source' flag")
+		os.Exit(1)
+	}
+
+	if err := controllers.ValidateRenameLabels(renameLabels); err != nil {
+		setupLog.Error(err, "invalid value for '-rename-labels' flag")
+		os.Exit(1)
+	}
+
+	leaderElectionID := "absent-metrics-operator.cloud.sap"
+	if instanceID != "" {
+		if errs := validation.IsDNS1123Label(instanceID); len(errs) > 0 {
+			setupLog.Error(errors.New(strings.Join(errs, "; ")), "invalid value for '-instance-id' flag")
+			os.Exit(1)
+		}
+		leaderElectionID = instanceID + "." + leaderElectionID
+	}
+	if errs := validation.IsDNS1123Subdomain(annotationPrefix); len(errs) > 0 {
+		setupLog.Error(errors.New(strings.Join(errs, "; ")), "invalid value for '-annotation-prefix' flag")
+		os.Exit(1)
+	}
+
+	var generatedByVersion string
+	if annotateVersion {
+		generatedByVersion = bininfo.VersionOr("dev")
+	}
+
+	var excludeMetrics *regexp.Regexp
+	if excludeUpLike {
+		var err error
+		excludeMetrics, err = regexp.Compile("^(?:" + upLikeRegex + ")$")
+		if err != nil {
+			setupLog.Error(err, "invalid value for '-up-like-metrics-regex' flag")
+			os.Exit(1)
+		}
+	}
+
+	var sourceURLTmpl *template.Template
+	if sourceURLTemplate != "" {
+		var err error
+		sourceURLTmpl, err = controllers.ParseSourceURLTemplate(sourceURLTemplate)
+		if err != nil {
+			setupLog.Error(err, "invalid value for '-source-url-template' flag")
+			os.Exit(1)
+		}
+	}
+
+	var queryURLTmpl *template.Template
+	if queryURLTemplate != "" {
+		var err error
+		queryURLTmpl, err = controllers.ParseQueryURLTemplate(queryURLTemplate)
+		if err != nil {
+			setupLog.Error(err, "invalid value for '-query-url-template' flag")
+			os.Exit(1)
+		}
+	}
+
+	var typeLabelTemplate *template.Template
+	if typeLabelTmpl != "" {
+		var err error
+		typeLabelTemplate, err = controllers.ParseTypeLabelTemplate(typeLabelTmpl)
+		if err != nil {
+			setupLog.Error(err, "invalid value for '-type-label-template' flag")
+			os.Exit(1)
+		}
+	}
+
+	if watchdogAlertName != "" && !model.IsValidMetricName(model.LabelValue(watchdogAlertName)) {
+		setupLog.Error(fmt.Errorf("invalid alert name: %q", watchdogAlertName), "invalid value for '-watchdog-alert-name' flag")
+		os.Exit(1)
+	}
+
+	var groupingLabelTemplate *template.Template
+	if groupingLabel != "" {
+		if !model.LabelNameRE.MatchString(groupingLabel) || groupingLabel == "context" {
+			setupLog.Error(fmt.Errorf("invalid label name: %q", groupingLabel), "invalid value for '-grouping-label' flag")
+			os.Exit(1)
+		}
+		var err error
+		groupingLabelTemplate, err = controllers.ParseGroupingLabelTemplate(groupingLabelTmpl)
+		if err != nil {
+			setupLog.Error(err, "invalid value for '-grouping-label-template' flag")
+			os.Exit(1)
+		}
+	}
+
+	var ownerMapping *controllers.OwnerMapping
+	if ownerMappingFile != "" {
+		var err error
+		ownerMapping, err = controllers.NewOwnerMapping(ownerMappingFile)
+		if err != nil {
+			setupLog.Error(err, "invalid value for '-owner-mapping-file' flag")
+			os.Exit(1)
+		}
+	}
+
+	reconciler := &controllers.PrometheusRuleReconciler{
+		Log:       ctrl.Log.WithName("controller").WithName("prometheusrule"),
+		KeepLabel: controllers.KeepLabel(keepLabel),
+
+		FallbackPrometheusServer:        fallbackPromServer,
+		KeepEmptyAbsencePrometheusRules: keepEmpty,
+		SeverityConfigMap:               types.NamespacedName(severityConfigMap),
+		OptionalMetricsConfigMap:        types.NamespacedName(optionalConfigMap),
+		SkipHandWrittenAbsence:          skipHandWritten,
+		WatchdogAlertName:               watchdogAlertName,
+		RecordMissingMetrics:            recordMissing,
+		OwnerMapping:                    ownerMapping,
+		ManagedByLabel:                  managedByLabel,
+		RecordRuleGroupSources:          recordSources,
+		HashRuleGroups:                  hashRuleGroups,
+		MaxAbsencePrometheusRuleSize:    maxAbsenceRuleSize,
+		CopyAnnotations:                 copyAnnotations,
+		SourceURLTemplate:               sourceURLTmpl,
+		GroupingLabel:                   groupingLabel,
+		GroupingLabelTemplate:           groupingLabelTemplate,
+		TypeLabelTemplate:               typeLabelTemplate,
+		CreateDelay:                     createDelay,
+		Aggregation:                     aggregation,
+		InstanceID:                      instanceID,
+		PrometheusServerDelimiter:       promServerDelimiter,
+		PrometheusServerLabel:           promServerLabel,
+		PrometheusServerLabelKey:        promServerLabelKey,
+		OrphanSweepInterval:             orphanSweepInterval,
+		RequeueAfter:                    requeueAfter,
+		MaxConcurrentReconciles:         maxConcurrent,
+		EmptyResultThreshold:            emptyResultThreshold,
+		ExcludeOwnerKinds:               excludeOwnerKinds,
+		ExcludeLabels:                   excludeLabels,
+		ExcludeAnnotations:              excludeAnnotations,
+		ExcludeWithoutPrometheusLabel:   excludeUnlabeled,
+		DisableAdoption:                 !adoptExisting,
+		DisableLabelInference:           noLabelInference,
+		LabelPrecedence:                 labelPrecedence,
+		RetainExistingLabels:            retainLabels,
+		AnnotationPrefix:                annotationPrefix,
+		Output:                          output,
+		DuplicateAlertNames:             duplicateAlertNames,
+		ParseOpts: controllers.ParseOpts{
+			IncludeSourceExpr:        includeSourceExpr,
+			IncludeSourceGroup:       includeSourceGroup,
+			GeneratedByVersion:       generatedByVersion,
+			StaticLabels:             staticLabels,
+			MinFor:                   minFor,
+			MinGroupInterval:         minGroupInterval,
+			ForBySeverity:            forBySeverity,
+			PreserveMatchers:         preserveMatchers,
+			DefaultSeverity:          defaultSeverity,
+			EscalationSeverity:       escalationSeverity,
+			SkipLabels:               skipLabels,
+			ExcludeMetrics:           excludeMetrics,
+			SkipSeverities:           skipSeverities,
+			RenameLabels:             renameLabels,
+			QueryURLTemplate:         queryURLTmpl,
+			SeverityOrder:            severityOrder,
+			SkipLocalRecordedMetrics: skipLocalRecorded,
+			MissingLabels:            missingLabels,
+			MissingLabelPlaceholder:  missingPlaceholder,
+			SanitizeLabelValues:      sanitizeLabelValues,
+			SkipColonMetrics:         skipColonMetrics,
+			SkipVectorUnless:         skipVectorUnless,
+			AlertOnUp:                alertOnUp,
+			LiftMatcherLabels:        liftMatcherLabels,
+			StripLabels:              stripLabels,
+			AllowedLabels:            allowedLabels,
+			OwnerLabel:               ownerLabel,
+			MaxRules:                 maxRulesPerSource,
+			RuleOrder:                ruleOrder,
+			AlertNameStyle:           alertNameStyle,
+			OriginalSeverity:         originalSeverity,
 		},
+	}
+
+	if testMode {
+		os.Exit(ruletest.Main(reconciler, flag.Args(), os.Stdout))
+	}
+
+	if once || forceRelabel {
+		reconciler.ForceRelabel = forceRelabel
+		os.Exit(runOnce(reconciler))
+	}
+
+	metricsOpts := metricsserver.Options{
+		BindAddress: metricsAddr,
+	}
+	if secureMetrics {
+		// Clients are authenticated with TokenReviews and authorized with
+		// SubjectAccessReviews for the request path and method, e.g. 'get' on '/metrics'
+		// and 'post' on '/generate'.
+		metricsOpts.SecureServing = true
+		metricsOpts.FilterProvider = filters.WithAuthenticationAndAuthorization
+	}
+	if enableGenerateAPI {
+		// The generation API is served alongside the metrics.
+		metricsOpts.ExtraHandlers = map[string]http.Handler{
+			generateAPIPath: reconciler.GenerateHandler(),
+		}
+		if !secureMetrics {
+			setupLog.Info("the generation API is unauthenticated since '-metrics-secure' is not set",
+				"path", generateAPIPath, "address", metricsAddr)
+		}
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsOpts,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "absent-metrics-operator.cloud.sap",
+		LeaderElectionID:       leaderElectionID,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	if err := controllers.CheckPrometheusRuleCRD(mgr.GetRESTMapper()); err != nil {
+		setupLog.Error(err, "preflight check failed")
+		os.Exit(1)
+	}
+
 	controllers.RegisterMetrics()
 
-	if err = (&controllers.PrometheusRuleReconciler{
-		Client:    mgr.GetClient(),
-		Scheme:    mgr.GetScheme(),
-		Log:       ctrl.Log.WithName("controller").WithName("prometheusrule"),
-		KeepLabel: controllers.KeepLabel(keepLabel),
-	}).SetupWithManager(mgr); err != nil {
+	reconciler.Client = mgr.GetClient()
+	reconciler.Scheme = mgr.GetScheme()
+	reconciler.Recorder = controllers.NewRateLimitedRecorder(
+		mgr.GetEventRecorderFor("absent-metrics-operator"), eventInterval)
+	if nsRateLimit > 0 {
+		reconciler.NamespaceRateLimiter = controllers.NewNamespaceRateLimiter(nsRateLimit, nsRateBurst)
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PrometheusRule")
 		os.Exit(1)
 	}
+	if cfgFile != nil {
+		err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return cfgFile.Watch(ctx, setupLog, func(values map[string]string) {
+				reloadConfig(reconciler, startValues, values)
+			})
+		}))
+		if err != nil {
+			setupLog.Error(err, "unable to watch '-config' file")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -135,9 +797,139 @@ func main() {
 	}
 }
 
-// labelsMap type is a wrapper around controllers.KeepLabel. It is used for the
-// `--keep-labels` flag to convert a comma-separated string into a map.
-type labelsMap controllers.KeepLabel
+// reloadableFlags are the flags whose values in the '-config' file can be changed while
+// the operator is running.
+var reloadableFlags = map[string]bool{
+	"min-for":                 true,
+	"default-severity":        true,
+	"exclude-up-like-metrics": true,
+	"up-like-metrics-regex":   true,
+	"query-url-template":      true,
+	"source-url-template":     true,
+	"grouping-label-template": true,
+	"type-label-template":     true,
+}
+
+// reloadConfig applies the values of the reloadableFlags from the '-config' file to the
+// reconciler. Changes to other flags are only logged since they require a restart.
+func reloadConfig(reconciler *controllers.PrometheusRuleReconciler, startValues, values map[string]string) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !reloadableFlags[name] && values[name] != startValues[name] {
+			setupLog.Info("option in '-config' file was changed but requires a restart", "option", name)
+		}
+	}
+
+	opts, err := parseReloadableOpts(values, reconciler.GroupingLabel != "")
+	if err != nil {
+		setupLog.Error(err, "could not reload '-config' file")
+		return
+	}
+	reconciler.Reload(opts)
+	setupLog.Info("reloaded '-config' file")
+}
+
+// parseReloadableOpts parses the values of the reloadableFlags in the same way as
+// during startup.
+func parseReloadableOpts(values map[string]string, groupingLabel bool) (controllers.ReloadableOpts, error) {
+	var (
+		opts controllers.ReloadableOpts
+		err  error
+	)
+	opts.MinFor, err = time.ParseDuration(values["min-for"])
+	if err != nil {
+		return opts, fmt.Errorf("invalid value for 'min-for': %w", err)
+	}
+	opts.DefaultSeverity = values["default-severity"]
+
+	excludeUpLike, err := strconv.ParseBool(values["exclude-up-like-metrics"])
+	if err != nil {
+		return opts, fmt.Errorf("invalid value for 'exclude-up-like-metrics': %w", err)
+	}
+	if excludeUpLike {
+		opts.ExcludeMetrics, err = regexp.Compile("^(?:" + values["up-like-metrics-regex"] + ")$")
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for 'up-like-metrics-regex': %w", err)
+		}
+	}
+
+	if v := values["query-url-template"]; v != "" {
+		opts.QueryURLTemplate, err = controllers.ParseQueryURLTemplate(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for 'query-url-template': %w", err)
+		}
+	}
+	if v := values["source-url-template"]; v != "" {
+		opts.SourceURLTemplate, err = controllers.ParseSourceURLTemplate(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for 'source-url-template': %w", err)
+		}
+	}
+	if groupingLabel {
+		opts.GroupingLabelTemplate, err = controllers.ParseGroupingLabelTemplate(values["grouping-label-template"])
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for 'grouping-label-template': %w", err)
+		}
+	}
+	if v := values["type-label-template"]; v != "" {
+		opts.TypeLabelTemplate, err = controllers.ParseTypeLabelTemplate(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for 'type-label-template': %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// runOnce reconciles all PrometheusRules once without starting the manager and returns
+// the exit status.
+func runOnce(reconciler *controllers.PrometheusRuleReconciler) int {
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		return 1
+	}
+	if err := controllers.CheckPrometheusRuleCRD(c.RESTMapper()); err != nil {
+		setupLog.Error(err, "preflight check failed")
+		return 1
+	}
+
+	reconciler.Client = c
+	reconciler.Scheme = scheme
+	count, err := reconciler.ReconcileAll(ctrl.SetupSignalHandler())
+	if err != nil {
+		setupLog.Error(err, "could not reconcile all PrometheusRules", "count", count)
+		return 1
+	}
+	setupLog.Info("successfully reconciled all PrometheusRules", "count", count)
+	return 0
+}
+
+// keepLabelFlag type is a wrapper around controllers.KeepLabel. It is used for the
+// `--keep-labels` flag to convert a comma-separated list of label names into a map.
+type keepLabelFlag controllers.KeepLabel
+
+// String implements the flag.Value interface.
+func (k keepLabelFlag) String() string {
+	return labelsMap(k).String()
+}
+
+// Set implements the flag.Value interface.
+func (k *keepLabelFlag) Set(in string) error {
+	keep, err := controllers.ParseKeepLabel(in)
+	if err != nil {
+		return err
+	}
+
+	*k = keepLabelFlag(keep)
+	return nil
+}
+
+// labelsMap type is used for flags that convert a comma-separated list into a map.
+type labelsMap map[string]bool
 
 // String implements the flag.Value interface.
 func (lm labelsMap) String() string {
@@ -159,3 +951,111 @@ func (lm *labelsMap) Set(in string) error {
 	*lm = labels
 	return nil
 }
+
+// stringList type is used for flags that convert a comma-separated list into a slice
+// while retaining the order of its elements.
+type stringList []string
+
+// String implements the flag.Value interface.
+func (sl stringList) String() string {
+	return strings.Join(sl, ",")
+}
+
+// Set implements the flag.Value interface.
+func (sl *stringList) Set(in string) error {
+	list := strings.Split(in, ",")
+	for i, v := range list {
+		list[i] = strings.TrimSpace(v)
+	}
+
+	*sl = list
+	return nil
+}
+
+// labelValuesMap type is used for flags that convert a comma-separated list of key=value
+// pairs into a map.
+type labelValuesMap map[string]string
+
+// String implements the flag.Value interface.
+func (lm labelValuesMap) String() string {
+	list := make([]string, 0, len(lm))
+	for k, v := range lm {
+		list = append(list, k+"="+v)
+	}
+	return strings.Join(list, ",")
+}
+
+// Set implements the flag.Value interface.
+func (lm *labelValuesMap) Set(in string) error {
+	labels := make(labelValuesMap)
+	for _, v := range strings.Split(in, ",") {
+		k, val, ok := strings.Cut(v, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return fmt.Errorf("invalid key=value pair: %q", v)
+		}
+		labels[k] = strings.TrimSpace(val)
+	}
+
+	*lm = labels
+	return nil
+}
+
+// durationMap type is used for flags that convert a comma-separated list of key=duration
+// pairs into a map.
+type durationMap map[string]time.Duration
+
+// String implements the flag.Value interface.
+func (dm durationMap) String() string {
+	list := make([]string, 0, len(dm))
+	for k, v := range dm {
+		list = append(list, k+"="+v.String())
+	}
+	return strings.Join(list, ",")
+}
+
+// Set implements the flag.Value interface.
+func (dm *durationMap) Set(in string) error {
+	durations := make(durationMap)
+	for _, v := range strings.Split(in, ",") {
+		k, val, ok := strings.Cut(v, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return fmt.Errorf("invalid key=duration pair: %q", v)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(val))
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("duration must be positive: %q", v)
+		}
+		durations[k] = d
+	}
+
+	*dm = durations
+	return nil
+}
+
+// namespacedName type is used for flags that reference an object in the format
+// 'namespace/name'.
+type namespacedName types.NamespacedName
+
+// String implements the flag.Value interface.
+func (n namespacedName) String() string {
+	if n.Name == "" {
+		return ""
+	}
+	return types.NamespacedName(n).String()
+}
+
+// Set implements the flag.Value interface.
+func (n *namespacedName) Set(in string) error {
+	ns, name, ok := strings.Cut(strings.TrimSpace(in), "/")
+	if !ok || ns == "" || name == "" {
+		return fmt.Errorf("invalid reference, expected 'namespace/name': %q", in)
+	}
+
+	*n = namespacedName{Namespace: ns, Name: name}
+	return nil
+}
@@ -0,0 +1,1382 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/sapcc/go-api-declarations/bininfo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/sapcc/absent-metrics-operator/controllers"
+)
+
+var _ = Describe("ParseRuleGroups", func() {
+	// These tests check the generation of absence alert rules without involving the
+	// controller.
+
+	It("should generate distinct absence rule groups for rule groups with the same name", func() {
+		groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{
+			{Name: "foo.alerts", Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+			{Name: "foo.alerts", Rules: []monitoringv1.Rule{createMockRule("bar_foo")}},
+			{Name: "foo.alerts", Rules: []monitoringv1.Rule{createMockRule("baz_foo")}},
+		}, "mock", controllers.ParseOpts{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(groups).To(HaveLen(3))
+		Expect(groups[0].Name).To(Equal("mock/foo.alerts"))
+		Expect(groups[1].Name).To(Equal("mock/foo.alerts-2"))
+		Expect(groups[2].Name).To(Equal("mock/foo.alerts-3"))
+	})
+
+	It("should generate identical absence alert rules regardless of the order of the alert rules", func() {
+		fooRule := createMockRule("foo_bar")
+		fooRule.Alert = "FooBarHigh"
+		otherFooRule := createMockRule("foo_bar")
+		otherFooRule.Alert = "FooBarLow"
+		rules := []monitoringv1.Rule{fooRule, createMockRule("bar_foo"), otherFooRule}
+		reversedRules := []monitoringv1.Rule{otherFooRule, createMockRule("bar_foo"), fooRule}
+
+		var specs [][]byte
+		for _, r := range [][]monitoringv1.Rule{rules, reversedRules, rules} {
+			groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{
+				{Name: "foo.alerts", Rules: r},
+			}, "mock", controllers.ParseOpts{})
+			Expect(err).ToNot(HaveOccurred())
+			b, err := json.Marshal(groups)
+			Expect(err).ToNot(HaveOccurred())
+			specs = append(specs, b)
+		}
+		Expect(specs[1]).To(Equal(specs[0]))
+		Expect(specs[2]).To(Equal(specs[0]))
+	})
+
+	It("should count the alert rules that do not reference any time series", func() {
+		before := counterValue("absent_metrics_operator_rules_without_metrics_total", "constant")
+		opts := controllers.ParseOpts{Namespace: "constant"}
+		Expect(parseMockRule("vector(1) > bool 0", opts)).To(BeEmpty())
+		Expect(counterValue("absent_metrics_operator_rules_without_metrics_total", "constant") - before).To(Equal(1.0))
+
+		// Expressions whose metrics are skipped do reference time series.
+		Expect(parseMockRule("up == 0", opts)).To(BeEmpty())
+		Expect(counterValue("absent_metrics_operator_rules_without_metrics_total", "constant") - before).To(Equal(1.0))
+	})
+
+	Describe("source expression annotation", func() {
+		opts := controllers.ParseOpts{IncludeSourceExpr: true}
+
+		It("should contain the sanitized expression", func() {
+			rules := parseMockRule("rate(foo_bar[5m])\n  > 0", opts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Annotations).To(HaveKeyWithValue("absent-metrics-operator/source-expr", "rate(foo_bar[5m]) > 0"))
+		})
+
+		It("should truncate long expressions", func() {
+			expr := "foo_bar > " + strings.Repeat("1 + ", 200) + "1"
+			rules := parseMockRule(expr, opts)
+			Expect(rules).To(HaveLen(1))
+			ann := rules[0].Annotations["absent-metrics-operator/source-expr"]
+			Expect(ann).To(HaveLen(503))
+			Expect(ann).To(HavePrefix("foo_bar > 1 + "))
+			Expect(ann).To(HaveSuffix("..."))
+		})
+
+		It("should not be added by default", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Annotations).ToNot(HaveKey("absent-metrics-operator/source-expr"))
+		})
+
+		It("should use the annotation prefix", func() {
+			prefixOpts := controllers.ParseOpts{IncludeSourceExpr: true, AnnotationPrefix: "example.com"}
+			rules := parseMockRule("foo_bar > 0", prefixOpts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Annotations).To(HaveKeyWithValue("example.com/source-expr", "foo_bar > 0"))
+			Expect(rules[0].Annotations).ToNot(HaveKey("absent-metrics-operator/source-expr"))
+		})
+	})
+
+	Describe("source group annotation", func() {
+		in := []monitoringv1.RuleGroup{
+			{Name: "foo.alerts", Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+			{Name: "foo.alerts", Rules: []monitoringv1.Rule{createMockRule("bar_foo")}},
+			{Name: "bar.alerts", Rules: []monitoringv1.Rule{createMockRule("baz_foo")}},
+		}
+
+		It("should contain the name of the original rule group", func() {
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", controllers.ParseOpts{IncludeSourceGroup: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(3))
+			for i, g := range groups {
+				Expect(g.Rules).To(HaveLen(1))
+				Expect(g.Rules[0].Annotations).To(HaveKeyWithValue("absent-metrics-operator/source-group", in[i].Name))
+			}
+			// The absence rule group of a duplicate rule group has a different name.
+			Expect(groups[1].Name).To(Equal("mock/foo.alerts-2"))
+		})
+
+		It("should not be added by default", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Annotations).ToNot(HaveKey("absent-metrics-operator/source-group"))
+		})
+	})
+
+	Describe("group interval", func() {
+		interval := func(d string) *monitoringv1.Duration {
+			result := monitoringv1.Duration(d)
+			return &result
+		}
+		in := []monitoringv1.RuleGroup{
+			{Name: "fast.alerts", Interval: interval("10s"), Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+			{Name: "slow.alerts", Interval: interval("5m"), Rules: []monitoringv1.Rule{createMockRule("bar_foo")}},
+			{Name: "default.alerts", Rules: []monitoringv1.Rule{createMockRule("baz_foo")}},
+		}
+
+		It("should raise intervals below the minimum and preserve the others", func() {
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", controllers.ParseOpts{MinGroupInterval: time.Minute})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(3))
+			Expect(groups[0].Interval).To(Equal(interval("1m")))
+			Expect(groups[1].Interval).To(Equal(interval("5m")))
+			Expect(groups[2].Interval).To(BeNil())
+		})
+
+		It("should copy the interval of the original rule group by default", func() {
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", controllers.ParseOpts{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(3))
+			Expect(groups[0].Interval).To(Equal(interval("10s")))
+		})
+
+		It("should return an error for an invalid interval", func() {
+			invalid := []monitoringv1.RuleGroup{
+				{Name: "foo.alerts", Interval: interval("often"), Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+			}
+			_, err := controllers.ParseRuleGroups(logger, invalid, "mock", controllers.ParseOpts{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("static labels", func() {
+		It("should be added to all absence alert rules", func() {
+			opts := controllers.ParseOpts{
+				LabelOpts:    controllers.LabelOpts{Keep: keepLabel},
+				StaticLabels: map[string]string{"alertgroup": "infra-absence", "tier": "static"},
+			}
+			rules := parseMockRule("foo_bar > 0 or bar_foo > 0", opts)
+			Expect(rules).To(HaveLen(2))
+			for _, r := range rules {
+				Expect(r.Labels).To(HaveKeyWithValue("alertgroup", "infra-absence"))
+				// Labels retained from the original alert rule take precedence.
+				Expect(r.Labels).To(HaveKeyWithValue("tier", "tier"))
+			}
+		})
+	})
+
+	Describe("Prometheus server label", func() {
+		It("should not be added by default", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Labels).ToNot(HaveKey("prometheus"))
+		})
+
+		It("should be added to all absence alert rules if specified", func() {
+			opts := controllers.ParseOpts{
+				StaticLabels:     map[string]string{"prometheus": "static"},
+				PrometheusServer: "openstack",
+			}
+			rules := parseMockRule("foo_bar > 0 or bar_foo > 0", opts)
+			Expect(rules).To(HaveLen(2))
+			for _, r := range rules {
+				Expect(r.Labels).To(HaveKeyWithValue("prometheus", "openstack"))
+			}
+		})
+	})
+
+	Describe("missing labels", func() {
+		rule := createMockRule("foo_bar")
+		delete(rule.Labels, "service")
+		in := []monitoringv1.RuleGroup{{
+			Name:  "mock.alerts",
+			Rules: []monitoringv1.Rule{rule, createMockRule("bar_foo")},
+		}}
+
+		It("should be omitted by default", func() {
+			opts := controllers.ParseOpts{LabelOpts: controllers.LabelOpts{Keep: keepLabel}}
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Rules).To(HaveLen(2))
+			Expect(groups[0].Rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+			Expect(groups[0].Rules[0].Labels).ToNot(HaveKey("service"))
+		})
+
+		It("should skip absence alert rules in skip mode", func() {
+			opts := controllers.ParseOpts{
+				LabelOpts:     controllers.LabelOpts{Keep: keepLabel},
+				MissingLabels: controllers.MissingLabelsSkip,
+			}
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Rules).To(HaveLen(1))
+			Expect(groups[0].Rules[0].Expr.String()).To(Equal("absent(bar_foo)"))
+		})
+
+		It("should use the placeholder in placeholder mode", func() {
+			opts := controllers.ParseOpts{
+				LabelOpts:               controllers.LabelOpts{Keep: keepLabel},
+				MissingLabels:           controllers.MissingLabelsPlaceholder,
+				MissingLabelPlaceholder: "unknown",
+			}
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Rules).To(HaveLen(2))
+			Expect(groups[0].Rules[0].Expr.String()).To(Equal("absent(bar_foo)"))
+			Expect(groups[0].Rules[0].Labels).To(HaveKeyWithValue("service", "service"))
+			Expect(groups[0].Rules[1].Expr.String()).To(Equal("absent(foo_bar)"))
+			Expect(groups[0].Rules[1].Labels).To(HaveKeyWithValue("service", "unknown"))
+		})
+	})
+
+	Describe("severity", func() {
+		It("should be 'info' by default", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Labels).To(HaveKeyWithValue("severity", "info"))
+		})
+
+		It("should use the configured default severity", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{DefaultSeverity: "warning"})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Labels).To(HaveKeyWithValue("severity", "warning"))
+		})
+	})
+
+	Describe("alert name prefix", func() {
+		It("should be 'Absent' by default", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Alert).To(Equal("AbsentFooBar"))
+		})
+
+		It("should use the configured prefix", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{AlertNamePrefix: "team-x"})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Alert).To(Equal("TeamXFooBar"))
+		})
+	})
+
+	Describe("alert name style", func() {
+		DescribeTable("should generate the alert name from the metric name",
+			func(style, expected string) {
+				rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{AlertNameStyle: style})
+				Expect(rules).To(HaveLen(1))
+				Expect(rules[0].Alert).To(Equal(expected))
+			},
+			Entry("default", "", "AbsentFooBar"),
+			Entry("titlecase", controllers.AlertNameStyleTitleCase, "AbsentFooBar"),
+			Entry("verbatim", controllers.AlertNameStyleVerbatim, "Absent_foo_bar"),
+			Entry("prefixed", controllers.AlertNameStylePrefixed, "absent_foo_bar"),
+		)
+
+		DescribeTable("should generate unique alert names within a rule group",
+			func(style string) {
+				rules := parseMockRule("foo_bar > 0 or foo_bar_total > 0 or bar_foo > 0", controllers.ParseOpts{AlertNameStyle: style})
+				Expect(rules).To(HaveLen(3))
+				names := make(map[string]bool)
+				for _, r := range rules {
+					names[r.Alert] = true
+				}
+				Expect(names).To(HaveLen(3))
+			},
+			Entry("titlecase", controllers.AlertNameStyleTitleCase),
+			Entry("verbatim", controllers.AlertNameStyleVerbatim),
+			Entry("prefixed", controllers.AlertNameStylePrefixed),
+		)
+
+		It("should use the configured prefix", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{
+				AlertNamePrefix: "Team",
+				AlertNameStyle:  controllers.AlertNameStylePrefixed,
+			})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Alert).To(Equal("team_foo_bar"))
+		})
+
+		DescribeTable("should replace characters that are not allowed in alert names",
+			func(style, prefix, expr, expected string) {
+				rules := parseMockRule(expr, controllers.ParseOpts{AlertNameStyle: style, AlertNamePrefix: prefix})
+				Expect(rules).To(HaveLen(1))
+				Expect(rules[0].Alert).To(Equal(expected))
+			},
+			Entry("in the metric name", controllers.AlertNameStyleVerbatim, "", `{__name__="foo-bar.total"} > 0`, "Absent_foo_bar_total"),
+			Entry("in the prefix", controllers.AlertNameStylePrefixed, "team-x", "foo_bar > 0", "team_x_foo_bar"),
+			Entry("at the start", controllers.AlertNameStylePrefixed, "1st", "foo_bar > 0", "_1st_foo_bar"),
+			Entry("with non-ASCII letters", controllers.AlertNameStyleTitleCase, "", `{__name__="foo_bär"} > 0`, "AbsentFooBR"),
+		)
+
+		It("should append a hash to sanitized alert names that collide with others", func() {
+			rules := parseMockRule(`foo_bar > 0 or {__name__="foo-bar"} > 0 or {__name__="foo.bar"} > 0`,
+				controllers.ParseOpts{AlertNameStyle: controllers.AlertNameStyleVerbatim})
+			Expect(rules).To(HaveLen(3))
+			names := make(map[string]bool)
+			for _, r := range rules {
+				Expect(r.Alert).To(MatchRegexp(`^Absent_foo_bar(_[0-9a-f]{8})?$`))
+				names[r.Alert] = true
+			}
+			Expect(names).To(HaveLen(3))
+			Expect(names).To(HaveKey("Absent_foo_bar"))
+		})
+	})
+
+	Describe("annotations", func() {
+		It("should be added to all absence alert rules", func() {
+			opts := controllers.ParseOpts{Annotations: map[string]string{
+				"owner":   "team-x",
+				"summary": "should not be overridden",
+			}}
+			rules := parseMockRule("foo_bar > 0 or bar_foo > 0", opts)
+			Expect(rules).To(HaveLen(2))
+			for _, r := range rules {
+				Expect(r.Annotations).To(HaveKeyWithValue("owner", "team-x"))
+				Expect(r.Annotations["summary"]).To(HavePrefix("missing "))
+			}
+		})
+	})
+
+	Describe("source URL annotation", func() {
+		It("should contain the rendered URL", func() {
+			tmpl, err := controllers.ParseSourceURLTemplate("https://git.example.com/rules/{{.Namespace}}/{{.Name}}.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			url, err := controllers.RenderSourceURL(tmpl, "swift", "openstack-swift.alerts")
+			Expect(err).ToNot(HaveOccurred())
+
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{SourceURL: url})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Annotations).To(HaveKeyWithValue("absent-metrics-operator/source-url",
+				"https://git.example.com/rules/swift/openstack-swift.alerts.yaml"))
+		})
+
+		It("should reject invalid templates", func() {
+			_, err := controllers.ParseSourceURLTemplate("https://git.example.com/{{.Namespace")
+			Expect(err).To(HaveOccurred())
+			_, err = controllers.ParseSourceURLTemplate("https://git.example.com/{{.Repository}}")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("version annotation", func() {
+		It("should contain the version of the operator", func() {
+			version := bininfo.VersionOr("dev")
+			rules := parseMockRule("foo_bar > 0 and bar_foo > 0", controllers.ParseOpts{GeneratedByVersion: version})
+			Expect(rules).To(HaveLen(2))
+			for _, r := range rules {
+				Expect(r.Annotations).To(HaveKeyWithValue("absent-metrics-operator/generated-by-version", version))
+			}
+		})
+
+		It("should not be added by default", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{})
+			Expect(rules[0].Annotations).ToNot(HaveKey("absent-metrics-operator/generated-by-version"))
+		})
+	})
+
+	Describe("query URL annotation", func() {
+		It("should contain the rendered URL for each metric", func() {
+			tmpl, err := controllers.ParseQueryURLTemplate(
+				"https://grafana.example.com/explore?datasource={{.PrometheusServer}}&query={{urlquery .Metric}}")
+			Expect(err).ToNot(HaveOccurred())
+
+			rules := parseMockRule(`foo_bar{region="eu"} > 0 and bar_foo > 0`, controllers.ParseOpts{
+				QueryURLTemplate: tmpl,
+				PrometheusServer: "openstack",
+				PreserveMatchers: true,
+			})
+			Expect(rules).To(HaveLen(2))
+			urls := make(map[string]string)
+			for _, r := range rules {
+				urls[r.Annotations["summary"]] = r.Annotations["absent-metrics-operator/query-url"]
+			}
+			Expect(urls).To(HaveKeyWithValue("missing bar_foo",
+				"https://grafana.example.com/explore?datasource=openstack&query=bar_foo"))
+			Expect(urls).To(HaveKeyWithValue(`missing foo_bar{region="eu"}`,
+				"https://grafana.example.com/explore?datasource=openstack&query=foo_bar%7Bregion%3D%22eu%22%7D"))
+		})
+
+		It("should reject invalid templates", func() {
+			_, err := controllers.ParseQueryURLTemplate("https://grafana.example.com/{{.Metric")
+			Expect(err).To(HaveOccurred())
+			_, err = controllers.ParseQueryURLTemplate("https://grafana.example.com/{{.Namespace}}")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("grouping label", func() {
+		It("should be added to all absence alert rules with the rendered value", func() {
+			tmpl, err := controllers.ParseGroupingLabelTemplate("absence-{{.PrometheusServer}}")
+			Expect(err).ToNot(HaveOccurred())
+			value, err := controllers.RenderGroupingLabel(tmpl, "openstack", "swift")
+			Expect(err).ToNot(HaveOccurred())
+
+			opts := controllers.ParseOpts{
+				LabelOpts:          controllers.LabelOpts{Keep: keepLabel},
+				GroupingLabel:      "absence_group",
+				GroupingLabelValue: value,
+			}
+			rules := parseMockRule("foo_bar > 0 or bar_foo > 0", opts)
+			Expect(rules).To(HaveLen(2))
+			for _, r := range rules {
+				Expect(r.Labels).To(HaveKeyWithValue("absence_group", "absence-openstack"))
+			}
+		})
+
+		It("should not be added by default", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Labels).ToNot(HaveKey("absence_group"))
+		})
+
+		It("should reject invalid templates", func() {
+			_, err := controllers.ParseGroupingLabelTemplate("{{.PrometheusServer")
+			Expect(err).To(HaveOccurred())
+			_, err = controllers.ParseGroupingLabelTemplate("{{.Cluster}}")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("skipped severities", func() {
+		It("should not generate absence alert rules for alert rules with these severities", func() {
+			opts := controllers.ParseOpts{SkipSeverities: map[string]bool{"info": true}}
+			groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{{
+				Name: "mock.alerts",
+				Rules: []monitoringv1.Rule{
+					createMockRuleWithSeverity("foo_bar", "info"),
+					createMockRuleWithSeverity("bar_foo", "critical"),
+				},
+			}}, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Rules).To(HaveLen(1))
+			Expect(groups[0].Rules[0].Expr.String()).To(Equal("absent(bar_foo)"))
+		})
+	})
+
+	Describe("lifted matcher labels", func() {
+		opts := controllers.ParseOpts{LiftMatcherLabels: map[string]bool{"region": true}}
+
+		It("should add the value of an equality matcher as a label", func() {
+			rules := parseMockRule(`foo_bar{region="eu",job="a"} > 0`, opts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+			Expect(rules[0].Labels).To(HaveKeyWithValue("region", "eu"))
+			Expect(rules[0].Labels).ToNot(HaveKey("job"))
+		})
+
+		It("should ignore other matchers", func() {
+			rules := parseMockRule(`foo_bar{region=~"eu.*"} > 0`, opts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Labels).ToNot(HaveKey("region"))
+		})
+
+		It("should drop ambiguous values", func() {
+			rules := parseMockRule(`foo_bar{region="eu"} > 0 or foo_bar{region="us"} > 0`, opts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Labels).ToNot(HaveKey("region"))
+		})
+
+		It("should not override other labels", func() {
+			staticOpts := opts
+			staticOpts.StaticLabels = map[string]string{"region": "global"}
+			rules := parseMockRule(`foo_bar{region="eu"} > 0`, staticOpts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Labels).To(HaveKeyWithValue("region", "global"))
+		})
+	})
+
+	Describe("escalation", func() {
+		rule := createMockRule("foo_bar")
+		rule.Annotations = map[string]string{"absent-metrics-operator/escalate-after": "1h"}
+
+		It("should generate a second stage for opted-in alert rules", func() {
+			groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{{
+				Name:  "mock.alerts",
+				Rules: []monitoringv1.Rule{rule, createMockRule("bar_foo")},
+			}}, "mock", controllers.ParseOpts{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			rules := groups[0].Rules
+			Expect(rules).To(HaveLen(3))
+			Expect(rules[0].Expr.String()).To(Equal("absent(bar_foo)"))
+			Expect(rules[1].Expr.String()).To(Equal("absent(foo_bar)"))
+			Expect(*rules[1].For).To(Equal(monitoringv1.Duration("10m")))
+			Expect(rules[1].Labels).To(HaveKeyWithValue("severity", "info"))
+			Expect(rules[2].Alert).To(Equal(rules[1].Alert))
+			Expect(rules[2].Expr.String()).To(Equal("absent(foo_bar)"))
+			Expect(*rules[2].For).To(Equal(monitoringv1.Duration("1h")))
+			Expect(rules[2].Labels).To(HaveKeyWithValue("severity", "critical"))
+		})
+
+		It("should not merge the stages by severity", func() {
+			groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{{
+				Name:  "mock.alerts",
+				Rules: []monitoringv1.Rule{rule},
+			}}, "mock", controllers.ParseOpts{
+				EscalationSeverity: "warning",
+				SeverityOrder:      []string{"critical", "warning", "info"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups[0].Rules).To(HaveLen(2))
+			Expect(groups[0].Rules[1].Labels).To(HaveKeyWithValue("severity", "warning"))
+		})
+
+		It("should reject invalid durations", func() {
+			invalid := createMockRule("foo_bar")
+			invalid.Annotations = map[string]string{"absent-metrics-operator/escalate-after": "soon"}
+			_, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{{
+				Name:  "mock.alerts",
+				Rules: []monitoringv1.Rule{invalid},
+			}}, "mock", controllers.ParseOpts{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should raise the duration of the second stage to the minimum", func() {
+			groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{{
+				Name:  "mock.alerts",
+				Rules: []monitoringv1.Rule{rule},
+			}}, "mock", controllers.ParseOpts{
+				MinFor:        2 * time.Hour,
+				ForBySeverity: map[string]time.Duration{"info": 10 * time.Minute},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups[0].Rules).To(HaveLen(2))
+			Expect(*groups[0].Rules[0].For).To(Equal(monitoringv1.Duration("10m")))
+			Expect(*groups[0].Rules[1].For).To(Equal(monitoringv1.Duration("2h")))
+		})
+
+		It("should skip the second stage if it is not longer than the first stage", func() {
+			var logs []string
+			log := funcr.New(func(_, args string) { logs = append(logs, args) }, funcr.Options{})
+			early := createMockRule("foo_bar")
+			early.Annotations = map[string]string{"absent-metrics-operator/escalate-after": "5m"}
+			groups, err := controllers.ParseRuleGroups(log, []monitoringv1.RuleGroup{{
+				Name:  "mock.alerts",
+				Rules: []monitoringv1.Rule{early},
+			}}, "mock", controllers.ParseOpts{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Rules).To(HaveLen(1))
+			Expect(*groups[0].Rules[0].For).To(Equal(monitoringv1.Duration("10m")))
+			Expect(logs).To(ContainElement(ContainSubstring("skipping the second stage")))
+		})
+	})
+
+	Describe("maximum number of rules", func() {
+		in := []monitoringv1.RuleGroup{
+			{Name: "first.alerts", Rules: []monitoringv1.Rule{createMockRule("foo_bar"), createMockRule("bar_foo")}},
+			{Name: "second.alerts", Rules: []monitoringv1.Rule{createMockRule("foo_baz"), createMockRule("baz_foo")}},
+		}
+
+		It("should not limit the absence alert rules by default", func() {
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", controllers.ParseOpts{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(2))
+			Expect(groups[1].Rules).To(HaveLen(2))
+		})
+
+		It("should drop the absence alert rules that exceed the maximum", func() {
+			var logs []string
+			log := funcr.New(func(_, args string) { logs = append(logs, args) }, funcr.Options{})
+			before := counterValue("absent_metrics_operator_truncated_sources_total", "truncated")
+			groups, err := controllers.ParseRuleGroups(log, in, "mock", controllers.ParseOpts{Namespace: "truncated", MaxRules: 3})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(2))
+			Expect(groups[0].Rules).To(HaveLen(2))
+			Expect(groups[1].Rules).To(HaveLen(1))
+			Expect(groups[1].Rules[0].Expr.String()).To(Equal("absent(foo_baz)"))
+			Expect(counterValue("absent_metrics_operator_truncated_sources_total", "truncated") - before).To(Equal(1.0))
+			Expect(logs).To(ContainElement(SatisfyAll(
+				ContainSubstring(`"error"="too many absence alert rules for PrometheusRule"`),
+				ContainSubstring(`"dropped"=1`),
+			)))
+
+			// Rule groups without any remaining absence alert rules are omitted.
+			groups, err = controllers.ParseRuleGroups(log, in, "mock", controllers.ParseOpts{Namespace: "truncated", MaxRules: 2})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Name).To(Equal("mock/first.alerts"))
+		})
+
+		It("should keep or drop the stages of escalated absence alert rules together", func() {
+			escalated := createMockRule("foo_baz")
+			escalated.Annotations = map[string]string{"absent-metrics-operator/escalate-after": "1h"}
+			in := []monitoringv1.RuleGroup{
+				{Name: "first.alerts", Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+				{Name: "second.alerts", Rules: []monitoringv1.Rule{escalated, createMockRule("bar_foo")}},
+			}
+			// The maximum of 2 would only leave room for the first stage of the escalated
+			// absence alert rule.
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", controllers.ParseOpts{Namespace: "truncated", MaxRules: 2})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Name).To(Equal("mock/first.alerts"))
+
+			groups, err = controllers.ParseRuleGroups(logger, in, "mock", controllers.ParseOpts{Namespace: "truncated", MaxRules: 3})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(2))
+			Expect(groups[1].Rules).To(HaveLen(2))
+			for _, r := range groups[1].Rules {
+				Expect(r.Expr.String()).To(Equal("absent(foo_baz)"))
+			}
+		})
+
+		It("should not count the missing metrics recording rule towards the maximum", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Log:                  logger,
+				KeepLabel:            keepLabel,
+				RecordMissingMetrics: true,
+				ParseOpts:            controllers.ParseOpts{MaxRules: 3},
+			}
+			aPR, err := r.GenerateAbsencePrometheusRule(&monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "openstack-truncated.alerts",
+					Namespace: "truncated",
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{Groups: in},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(3))
+			Expect(aPR.Spec.Groups[1].Rules).To(HaveLen(1))
+			record := aPR.Spec.Groups[2]
+			Expect(record.Name).To(Equal("absent-metrics-operator-missing-metrics"))
+			// Only the remaining absence alert rules are counted.
+			Expect(record.Rules[0].Expr.String()).To(Equal("sum(absent(bar_foo) or vector(0)) + " +
+				"sum(absent(foo_bar) or vector(0)) + sum(absent(foo_baz) or vector(0))"))
+		})
+	})
+
+	Describe("rule order", func() {
+		in := []monitoringv1.RuleGroup{{
+			Name:  "mock.alerts",
+			Rules: []monitoringv1.Rule{createMockRule("foo_bar"), createMockRule("baz_foo"), createMockRule("bar_foo")},
+		}}
+		in[0].Rules[0].Expr = intstr.FromString("foo_bar > 0 or zoo_bar > 0 or abc_bar > 0")
+		exprs := func(opts controllers.ParseOpts) []string {
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			var result []string
+			for _, r := range groups[0].Rules {
+				result = append(result, r.Expr.String())
+			}
+			return result
+		}
+
+		It("should be sorted by default", func() {
+			Expect(exprs(controllers.ParseOpts{})).To(Equal([]string{
+				"absent(abc_bar)", "absent(bar_foo)", "absent(baz_foo)", "absent(foo_bar)", "absent(zoo_bar)",
+			}))
+		})
+
+		It("should follow the original rule group in source order", func() {
+			opts := controllers.ParseOpts{RuleOrder: controllers.RuleOrderSource}
+			expected := []string{
+				"absent(foo_bar)", "absent(zoo_bar)", "absent(abc_bar)", "absent(baz_foo)", "absent(bar_foo)",
+			}
+			for i := 0; i < 3; i++ {
+				Expect(exprs(opts)).To(Equal(expected))
+			}
+		})
+	})
+
+	Describe("for strategy", func() {
+		parseWithStrategy := func(strategy string, sourceFor *monitoringv1.Duration, minFor time.Duration) ([]monitoringv1.RuleGroup, error) {
+			rule := createMockRule("foo_bar")
+			rule.For = sourceFor
+			rule.Annotations = map[string]string{"absent-metrics-operator/for-strategy": strategy}
+			return controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{{
+				Name:  "mock.alerts",
+				Rules: []monitoringv1.Rule{rule},
+			}}, "mock", controllers.ParseOpts{MinFor: minFor})
+		}
+		duration := func(d string) *monitoringv1.Duration {
+			result := monitoringv1.Duration(d)
+			return &result
+		}
+
+		DescribeTable("should derive the 'for' duration from the original alert rule",
+			func(strategy, sourceFor string, minFor time.Duration, expected string) {
+				groups, err := parseWithStrategy(strategy, duration(sourceFor), minFor)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(groups).To(HaveLen(1))
+				Expect(groups[0].Rules).To(HaveLen(1))
+				Expect(groups[0].Rules[0].For).To(Equal(duration(expected)))
+			},
+			Entry("default", controllers.ForStrategyDefault, "30m", time.Duration(0), "10m"),
+			Entry("max with a longer source", controllers.ForStrategyMax, "30m", time.Duration(0), "30m"),
+			Entry("max with a shorter source", controllers.ForStrategyMax, "5m", time.Duration(0), "10m"),
+			Entry("source with a longer source", controllers.ForStrategySource, "30m", time.Duration(0), "30m"),
+			Entry("source with a shorter source", controllers.ForStrategySource, "5m", time.Duration(0), "5m"),
+			Entry("source below the minimum", controllers.ForStrategySource, "0s", 15*time.Minute, "15m"),
+			Entry("source above the minimum", controllers.ForStrategySource, "30m", 15*time.Minute, "30m"),
+		)
+
+		It("should use the default if the original alert rule does not have a 'for' duration", func() {
+			groups, err := parseWithStrategy(controllers.ForStrategySource, nil, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups[0].Rules[0].For).To(Equal(duration("10m")))
+		})
+
+		It("should reject unknown strategies", func() {
+			_, err := parseWithStrategy("longest", duration("30m"), 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("missing metrics recording rule", func() {
+		escalated := createMockRule("foo_bar")
+		escalated.Annotations = map[string]string{"absent-metrics-operator/escalate-after": "1h"}
+		pr := &monitoringv1.PrometheusRule{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "openstack-mock.alerts",
+				Namespace: "mock",
+				Labels:    map[string]string{"prometheus": "openstack"},
+			},
+			Spec: monitoringv1.PrometheusRuleSpec{
+				Groups: []monitoringv1.RuleGroup{
+					{Name: "first.alerts", Rules: []monitoringv1.Rule{escalated, createMockRule("bar_foo")}},
+					{Name: "second.alerts", Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+				},
+			},
+		}
+
+		It("should not be generated by default", func() {
+			r := &controllers.PrometheusRuleReconciler{Log: logger, KeepLabel: keepLabel}
+			aPR, err := r.GenerateAbsencePrometheusRule(pr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(2))
+			for _, g := range aPR.Spec.Groups {
+				for _, r := range g.Rules {
+					Expect(r.Record).To(BeEmpty())
+				}
+			}
+			Expect(aPR.Annotations).ToNot(HaveKey("absent-metrics-operator/missing-metrics"))
+		})
+
+		It("should generate a single recording rule per AbsencePrometheusRule if enabled", func() {
+			r := &controllers.PrometheusRuleReconciler{Log: logger, KeepLabel: keepLabel, RecordMissingMetrics: true}
+			aPR, err := r.GenerateAbsencePrometheusRule(pr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(3))
+			for _, g := range aPR.Spec.Groups[:2] {
+				for _, r := range g.Rules {
+					Expect(r.Record).To(BeEmpty())
+				}
+			}
+
+			g := aPR.Spec.Groups[2]
+			Expect(g.Name).To(Equal("absent-metrics-operator-missing-metrics"))
+			Expect(g.Rules).To(HaveLen(1))
+			Expect(g.Rules[0].Record).To(Equal("absence:missing_metrics:count"))
+			Expect(g.Rules[0].Labels).To(Equal(map[string]string{
+				"absence_prometheus_rule": "mock/openstack-absent-metric-alert-rules",
+			}))
+			// Each metric is counted once, regardless of escalated absence alert rules and
+			// rule groups.
+			Expect(g.Rules[0].Expr.String()).To(Equal(
+				"sum(absent(bar_foo) or vector(0)) + sum(absent(foo_bar) or vector(0))"))
+			_, err = parser.ParseExpr(g.Rules[0].Expr.String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Annotations).To(HaveKeyWithValue("absent-metrics-operator/missing-metrics",
+				"absence:missing_metrics:count"))
+		})
+	})
+
+	Describe("stripped labels", func() {
+		rule := createMockRule("foo_bar")
+		rule.Expr = intstr.FromString(`foo_bar{region="eu"} > 0`)
+		rule.Labels["instance"] = "node001"
+		in := []monitoringv1.RuleGroup{{Name: "mock.alerts", Rules: []monitoringv1.Rule{rule}}}
+
+		It("should not carry over stripped labels", func() {
+			opts := controllers.ParseOpts{
+				LabelOpts: controllers.LabelOpts{
+					Keep: controllers.KeepLabel{"tier": true, "service": true, "instance": true},
+				},
+				LiftMatcherLabels: map[string]bool{"region": true},
+				StripLabels:       map[string]bool{"instance": true, "region": true},
+			}
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			labels := groups[0].Rules[0].Labels
+			Expect(labels).To(HaveKeyWithValue("tier", "tier"))
+			Expect(labels).To(HaveKeyWithValue("service", "service"))
+			Expect(labels).ToNot(HaveKey("instance"))
+			Expect(labels).ToNot(HaveKey("region"))
+		})
+	})
+
+	Describe("allowed labels", func() {
+		rule := createMockRule("foo_bar")
+		rule.Expr = intstr.FromString(`foo_bar{region="eu"} > 0`)
+		rule.Labels["customer"] = "acme"
+		rule.Labels["severity"] = "critical"
+		in := []monitoringv1.RuleGroup{{Name: "mock.alerts", Rules: []monitoringv1.Rule{rule}}}
+
+		It("should ignore labels that are not allowed", func() {
+			opts := controllers.ParseOpts{
+				LabelOpts: controllers.LabelOpts{
+					Keep: controllers.KeepLabel{"tier": true, "service": true, "customer": true},
+				},
+				LiftMatcherLabels: map[string]bool{"region": true},
+				OriginalSeverity:  true,
+				AllowedLabels:     map[string]bool{"tier": true, "service": true},
+			}
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			labels := groups[0].Rules[0].Labels
+			Expect(labels).To(HaveKeyWithValue("tier", "tier"))
+			Expect(labels).To(HaveKeyWithValue("service", "service"))
+			Expect(labels).ToNot(HaveKey("customer"))
+			Expect(labels).ToNot(HaveKey("region"))
+			Expect(labels).ToNot(HaveKey("original_severity"))
+		})
+
+		It("should carry over all labels by default", func() {
+			opts := controllers.ParseOpts{
+				LabelOpts: controllers.LabelOpts{
+					Keep: controllers.KeepLabel{"tier": true, "service": true, "customer": true},
+				},
+			}
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups[0].Rules[0].Labels).To(HaveKeyWithValue("customer", "acme"))
+		})
+	})
+
+	Describe("label sanitization", func() {
+		rule := createMockRule("foo_bar")
+		rule.Labels["service"] = " foo,bar\n"
+		in := []monitoringv1.RuleGroup{{Name: "mock.alerts", Rules: []monitoringv1.Rule{rule}}}
+
+		It("should not change label values by default", func() {
+			opts := controllers.ParseOpts{LabelOpts: controllers.LabelOpts{Keep: keepLabel}}
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups[0].Rules[0].Labels).To(HaveKeyWithValue("service", " foo,bar\n"))
+		})
+
+		It("should sanitize label values if enabled", func() {
+			opts := controllers.ParseOpts{
+				LabelOpts:           controllers.LabelOpts{Keep: keepLabel},
+				SanitizeLabelValues: true,
+			}
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups[0].Rules[0].Labels).To(HaveKeyWithValue("service", "foo_bar"))
+			Expect(groups[0].Rules[0].Labels).To(HaveKeyWithValue("tier", "tier"))
+		})
+	})
+
+	Describe("renamed labels", func() {
+		It("should rename the labels of absence alert rules", func() {
+			opts := controllers.ParseOpts{
+				LabelOpts:    controllers.LabelOpts{Keep: keepLabel},
+				RenameLabels: map[string]string{"service": "service_name", "severity": "level"},
+			}
+			rules := parseMockRule("foo_bar > 0", opts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Labels).To(Equal(map[string]string{
+				"context":      "absent-metrics",
+				"level":        "info",
+				"service_name": "service",
+				"tier":         "tier",
+			}))
+			// The alert name is generated from the original labels.
+			Expect(rules[0].Alert).To(Equal("AbsentTierServiceFooBar"))
+		})
+
+		It("should reject invalid label names", func() {
+			Expect(controllers.ValidateRenameLabels(map[string]string{"service": "service_name"})).To(Succeed())
+			Expect(controllers.ValidateRenameLabels(map[string]string{"service": "service.name"})).ToNot(Succeed())
+			Expect(controllers.ValidateRenameLabels(map[string]string{"service": ""})).ToNot(Succeed())
+		})
+	})
+
+	Describe("original severity", func() {
+		It("should not be added by default", func() {
+			groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{{
+				Name:  "mock.alerts",
+				Rules: []monitoringv1.Rule{createMockRuleWithSeverity("foo_bar", "critical")},
+			}}, "mock", controllers.ParseOpts{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups[0].Rules[0].Labels).ToNot(HaveKey("original_severity"))
+		})
+
+		It("should carry the severity of the original alert rule", func() {
+			groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{{
+				Name: "mock.alerts",
+				Rules: []monitoringv1.Rule{
+					createMockRuleWithSeverity("foo_bar", "critical"),
+					createMockRuleWithSeverity("bar_foo", "{{ $labels.severity }}"),
+				},
+			}}, "mock", controllers.ParseOpts{OriginalSeverity: true, DefaultSeverity: "warning"})
+			Expect(err).ToNot(HaveOccurred())
+			rules := groups[0].Rules
+			Expect(rules).To(HaveLen(2))
+			Expect(rules[0].Expr.String()).To(Equal("absent(bar_foo)"))
+			Expect(rules[0].Labels).ToNot(HaveKey("original_severity"))
+			Expect(rules[0].Labels).To(HaveKeyWithValue("severity", "warning"))
+			Expect(rules[1].Expr.String()).To(Equal("absent(foo_bar)"))
+			Expect(rules[1].Labels).To(HaveKeyWithValue("original_severity", "critical"))
+			Expect(rules[1].Labels).To(HaveKeyWithValue("severity", "warning"))
+		})
+	})
+
+	Describe("severity order", func() {
+		It("should merge absence alert rules into the one of the alert rule with the highest severity", func() {
+			infoRule := createMockRuleWithSeverity("foo_bar", "info")
+			infoRule.Labels["service"] = "info-service"
+			criticalRule := createMockRuleWithSeverity("foo_bar", "critical")
+			criticalRule.Labels["service"] = "critical-service"
+			in := []monitoringv1.RuleGroup{{
+				Name:  "mock.alerts",
+				Rules: []monitoringv1.Rule{infoRule, criticalRule, createMockRuleWithSeverity("bar_foo", "info")},
+			}}
+
+			opts := controllers.ParseOpts{LabelOpts: controllers.LabelOpts{Keep: keepLabel}}
+			groups, err := controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Rules).To(HaveLen(3))
+
+			opts.SeverityOrder = []string{"critical", "warning", "info"}
+			groups, err = controllers.ParseRuleGroups(logger, in, "mock", opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Rules).To(HaveLen(2))
+			Expect(groups[0].Rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+			Expect(groups[0].Rules[0].Labels).To(HaveKeyWithValue("service", "critical-service"))
+			Expect(groups[0].Rules[1].Expr.String()).To(Equal("absent(bar_foo)"))
+		})
+	})
+
+	Describe("locally recorded metrics", func() {
+		recordingRule := monitoringv1.Rule{Record: "foo:bar:rate5m", Expr: intstr.FromString("rate(foo_bar[5m])")}
+		alertRule := createMockRule("foo:bar:rate5m")
+		otherAlertRule := createMockRule("bar:foo:rate5m") // recorded in a different PrometheusRule
+
+		It("should be skipped if configured", func() {
+			groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{
+				{Name: "recording.rules", Rules: []monitoringv1.Rule{recordingRule}},
+				{Name: "alerting.rules", Rules: []monitoringv1.Rule{alertRule, otherAlertRule}},
+			}, "mock", controllers.ParseOpts{SkipLocalRecordedMetrics: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Rules).To(HaveLen(1))
+			Expect(groups[0].Rules[0].Expr.String()).To(Equal("absent(bar:foo:rate5m)"))
+		})
+
+		It("should not be skipped by default", func() {
+			groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{
+				{Name: "alerting.rules", Rules: []monitoringv1.Rule{recordingRule, alertRule, otherAlertRule}},
+			}, "mock", controllers.ParseOpts{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Rules).To(HaveLen(2))
+		})
+	})
+
+	Describe("minimum for duration", func() {
+		It("should raise the default duration if it is below the minimum", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{MinFor: 15 * time.Minute})
+			Expect(rules).To(HaveLen(1))
+			Expect(*rules[0].For).To(Equal(monitoringv1.Duration("15m")))
+		})
+
+		It("should not change the default duration if it is above the minimum", func() {
+			rules := parseMockRule("foo_bar > 0", controllers.ParseOpts{MinFor: 5 * time.Minute})
+			Expect(rules).To(HaveLen(1))
+			Expect(*rules[0].For).To(Equal(monitoringv1.Duration("10m")))
+		})
+	})
+
+	Describe("for duration per severity", func() {
+		forBySeverity := map[string]time.Duration{
+			"critical": 2 * time.Minute,
+			"info":     15 * time.Minute,
+		}
+
+		DescribeTable("should use the duration of the severity of the absence alert rule",
+			func(severity, expected string) {
+				opts := controllers.ParseOpts{
+					DefaultSeverity: severity,
+					MinFor:          5 * time.Minute,
+					ForBySeverity:   forBySeverity,
+				}
+				rules := parseMockRule("foo_bar > 0", opts)
+				Expect(rules).To(HaveLen(1))
+				Expect(*rules[0].For).To(Equal(monitoringv1.Duration(expected)))
+			},
+			Entry("critical", "critical", "2m"),
+			Entry("info", "info", "15m"),
+			Entry("severity without duration", "warning", "10m"),
+		)
+	})
+
+	Describe("metric extraction", func() {
+		opts := controllers.ParseOpts{}
+
+		DescribeTable("should extract the metric from range vector functions",
+			func(expr string) {
+				rules := parseMockRule(expr, opts)
+				Expect(rules).To(HaveLen(1))
+				Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+			},
+			Entry("avg_over_time", "avg_over_time(foo_bar[5m]) > 0"),
+			Entry("min_over_time", "min_over_time(foo_bar[5m]) > 0"),
+			Entry("max_over_time", "max_over_time(foo_bar[5m]) > 0"),
+			Entry("sum_over_time", "sum_over_time(foo_bar[5m]) > 0"),
+			Entry("count_over_time", "count_over_time(foo_bar[5m]) > 0"),
+			Entry("quantile_over_time", "quantile_over_time(0.9, foo_bar[5m]) > 0"),
+			Entry("stddev_over_time", "stddev_over_time(foo_bar[5m]) > 0"),
+			Entry("stdvar_over_time", "stdvar_over_time(foo_bar[5m]) > 0"),
+			Entry("last_over_time", "last_over_time(foo_bar[5m]) > 0"),
+			Entry("present_over_time", "present_over_time(foo_bar[5m]) > 0"),
+			Entry("subquery", "max_over_time(rate(foo_bar[5m])[1h:]) > 0"),
+		)
+
+		DescribeTable("should not extract the scalar arguments of functions",
+			func(expr string) {
+				rules := parseMockRule(expr, opts)
+				Expect(rules).To(HaveLen(1))
+				Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+			},
+			Entry("predict_linear", "predict_linear(foo_bar[1h], 3600) < 0"),
+			Entry("predict_linear with arithmetic", "predict_linear(foo_bar[1h], 4 * 3600) < 0"),
+			Entry("predict_linear with subquery", "predict_linear(rate(foo_bar[5m])[1h:], 3600) > 0"),
+			Entry("holt_winters", "holt_winters(foo_bar[1h], 0.3, 0.6) > 0"),
+			Entry("clamp", "clamp(foo_bar, 0, 100) > 0"),
+			Entry("round", "round(foo_bar, 0.5) > 0"),
+			Entry("histogram_quantile", "histogram_quantile(0.9, foo_bar) > 0"),
+			Entry("scalar comparison", "foo_bar > scalar(vector(3600))"),
+		)
+
+		It("should extract metrics from scalar arguments that select time series", func() {
+			rules := parseMockRule("predict_linear(foo_bar[1h], scalar(foo_limit)) < 0", opts)
+			Expect(rules).To(HaveLen(2))
+			Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+			Expect(rules[1].Expr.String()).To(Equal("absent(foo_limit)"))
+		})
+
+		DescribeTable("should not extract the grouping labels of aggregations",
+			func(expr string) {
+				rules := parseMockRule(expr, opts)
+				Expect(rules).To(HaveLen(1))
+				Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+			},
+			Entry("sum without", "sum without (instance) (foo_bar) > 0"),
+			Entry("count by", "count by (job) (foo_bar) > 0"),
+			Entry("grouping label named like a metric", "sum by (foo_baz) (foo_bar) > 0"),
+			Entry("topk by", "topk by (job) (3, foo_bar) > 0"),
+			Entry("quantile without", "quantile without (instance, pod) (0.9, foo_bar) > 0"),
+			Entry("count_values by", `count_values by (job) ("value", foo_bar) > 0`),
+			Entry("nested", "max by (job) (sum without (instance) (rate(foo_bar[5m]))) > 0"),
+		)
+
+		DescribeTable("should not extract the parameters of aggregations",
+			func(expr string) {
+				rules := parseMockRule(expr, opts)
+				Expect(rules).To(HaveLen(1))
+				Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+			},
+			Entry("topk", "topk(3, foo_bar) > 0"),
+			Entry("bottomk", "bottomk(3, foo_bar) > 0"),
+			Entry("quantile", "quantile(0.9, foo_bar) > 0"),
+			Entry("count_values", `count_values("foo_baz", foo_bar) > 0`),
+			Entry("parameter expression", "topk(2 + 1, rate(foo_bar[5m])) > 0"),
+		)
+
+		It("should extract metrics that are used in the parameters of aggregations", func() {
+			rules := parseMockRule("topk(scalar(foo_limit), foo_bar) > 0", opts)
+			Expect(rules).To(HaveLen(2))
+			Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+			Expect(rules[1].Expr.String()).To(Equal("absent(foo_limit)"))
+		})
+
+		It("should skip metrics that are already covered by absent()", func() {
+			Expect(parseMockRule("absent(foo_bar) or foo_bar > 0", opts)).To(BeEmpty())
+			Expect(parseMockRule(`absent({__name__="foo_bar"}) or foo_bar > 0`, opts)).To(BeEmpty())
+			Expect(parseMockRule(`absent(foo_bar{job="a", env="b"}) or foo_bar{env="b", job="a"} > 0`, opts)).To(BeEmpty())
+		})
+
+		It("should only skip the metrics inside absent() when it is combined with other selectors", func() {
+			Expect(parseMockRule("absent(foo_bar) * 5 > 0", opts)).To(BeEmpty())
+
+			for _, expr := range []string{
+				"absent(foo_bar) and on() bar_foo > 0",
+				"absent(foo_bar) * on() group_left() bar_foo > 0",
+				"(absent(foo_bar) or vector(0)) + bar_foo > 0",
+			} {
+				rules := parseMockRule(expr, opts)
+				Expect(rules).To(HaveLen(1), expr)
+				Expect(rules[0].Expr.String()).To(Equal("absent(bar_foo)"), expr)
+			}
+		})
+
+		DescribeTable("should skip metrics that are covered by absent() regardless of formatting",
+			func(expr string) {
+				Expect(parseMockRule(expr, opts)).To(BeEmpty())
+			},
+			Entry("comparison", "absent(foo_bar) == 1"),
+			Entry("whitespace", "absent ( foo_bar )   ==   1"),
+			Entry("newlines", "absent(\n  foo_bar\n)\n== 1"),
+			Entry("parentheses", "((absent((foo_bar)))) > 0"),
+			Entry("negation", "1 - absent(foo_bar) < 1"),
+			Entry("nested function", "sum(absent_over_time(foo_bar{job=\"a\"}[5m])) or foo_bar{job=\"a\"} > 0"),
+			Entry("name matcher", `absent({__name__="foo_bar"}) unless foo_bar > 0`),
+			Entry("self-guard", "foo_bar unless absent(foo_bar)"),
+			Entry("self-guard with range", "rate(foo_bar[5m]) > 0 unless on() absent_over_time(foo_bar[10m])"),
+			Entry("self-guard with offset", "foo_bar offset 5m > 0 unless absent(foo_bar)"),
+			Entry("literal regex matcher", `foo_bar{job=~"a"} unless absent(foo_bar{job="a"})`),
+		)
+
+		It("should not skip metrics whose name has an absent metric as prefix", func() {
+			rules := parseMockRule("absent(foo_bar_total) or foo_bar > 0", opts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+		})
+
+		It("should only skip the time series with the same matchers as in absent()", func() {
+			expr := `absent(foo_bar{job="a"}) or rate(foo_bar{job="b"}[5m]) > 0`
+			rules := parseMockRule(expr, opts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+
+			rules = parseMockRule(expr, controllers.ParseOpts{PreserveMatchers: true})
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Expr.String()).To(Equal(`absent(foo_bar{job="b"})`))
+			Expect(rules[0].Annotations).To(HaveKeyWithValue("summary", `missing foo_bar{job="b"}`))
+		})
+
+		DescribeTable("should drop the @ modifier from the absence alert expression",
+			func(expr, expected string) {
+				rules := parseMockRule(expr, controllers.ParseOpts{PreserveMatchers: true})
+				Expect(rules).To(HaveLen(1))
+				Expect(rules[0].Expr.String()).To(Equal(expected))
+				_, err := parser.ParseExpr(rules[0].Expr.String())
+				Expect(err).ToNot(HaveOccurred())
+			},
+			Entry("end()", `foo_bar{job="x"} @ end() > 0`, `absent(foo_bar{job="x"})`),
+			Entry("start()", `rate(foo_bar{job="x"}[5m] @ start()) > 0`, `absent(foo_bar{job="x"})`),
+			Entry("timestamp", `foo_bar{job="x"} @ 1609746000 offset 5m > 0`, `absent(foo_bar{job="x"})`),
+		)
+
+		It("should skip metrics that are already covered by absent_over_time()", func() {
+			Expect(parseMockRule("absent_over_time(foo_bar[5m])", opts)).To(BeEmpty())
+			Expect(parseMockRule("absent_over_time(foo_bar[5m]) or avg_over_time(foo_bar[5m]) > 0", opts)).To(BeEmpty())
+		})
+
+		It("should not extract string arguments of label_replace()", func() {
+			Expect(parseMockRule(`label_replace(up, "foo", "$1", "bar", "(.*)")`, opts)).To(BeEmpty())
+
+			rules := parseMockRule(`label_replace(foo_bar, "foo", "$1", "bar", "(.*)") > 0`, opts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+		})
+
+		It("should skip up-like metrics if configured", func() {
+			excludeOpts := controllers.ParseOpts{ExcludeMetrics: regexp.MustCompile("^(?:.+_up)$")}
+			rules := parseMockRule("foo_up > 0 or foo_uptime > 0", excludeOpts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Expr.String()).To(Equal("absent(foo_uptime)"))
+
+			// Without the option, only the "up" metric is skipped.
+			Expect(parseMockRule("up > 0 or foo_up > 0 or foo_uptime > 0", opts)).To(HaveLen(2))
+		})
+
+		It("should skip metrics from external sources", func() {
+			skipOpts := controllers.ParseOpts{SkipLabels: map[string]string{"prometheus": "federated"}}
+			rules := parseMockRule(`foo_bar{prometheus="federated"} > 0 or bar_foo{prometheus="local"} > 0`, skipOpts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Expr.String()).To(Equal("absent(bar_foo)"))
+
+			// Without the option, absence alert rules are generated for both metrics.
+			Expect(parseMockRule(`foo_bar{prometheus="federated"} > 0 or bar_foo{prometheus="local"} > 0`, opts)).To(HaveLen(2))
+		})
+
+		It("should skip metrics with a colon in their name if configured", func() {
+			colonOpts := controllers.ParseOpts{SkipColonMetrics: true}
+			rules := parseMockRule("job:foo_bar:rate5m > 0 or foo_bar_total > 0", colonOpts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar_total)"))
+
+			// Without the option, absence alert rules are generated for both metrics.
+			Expect(parseMockRule("job:foo_bar:rate5m > 0 or foo_bar_total > 0", opts)).To(HaveLen(2))
+		})
+
+		It("should only generate absence alert rules for the up metric if configured", func() {
+			upOpts := controllers.ParseOpts{AlertOnUp: true, PreserveMatchers: true}
+			rules := parseMockRule(`up{job="x"} == 0`, upOpts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Expr.String()).To(Equal(`absent(up{job="x"})`))
+
+			// The up metric without label matchers is always skipped.
+			Expect(parseMockRule("up == 0", upOpts)).To(BeEmpty())
+			// By default, the up metric is skipped.
+			Expect(parseMockRule(`up{job="x"} == 0`, opts)).To(BeEmpty())
+			Expect(parseMockRule(`up{job="x"} == 0`, controllers.ParseOpts{PreserveMatchers: true})).To(BeEmpty())
+			// Without preserving the matchers, the absence alert rule would be absent(up).
+			Expect(parseMockRule(`up{job="x"} == 0`, controllers.ParseOpts{AlertOnUp: true})).To(BeEmpty())
+		})
+
+		DescribeTable("should skip metrics that are checked with vector(N) unless <selector> if configured",
+			func(expr string, expected []string) {
+				unlessOpts := controllers.ParseOpts{SkipVectorUnless: true}
+				rules := parseMockRule(expr, unlessOpts)
+				exprs := make([]string, 0, len(rules))
+				for _, r := range rules {
+					exprs = append(exprs, r.Expr.String())
+				}
+				Expect(exprs).To(ConsistOf(expected))
+
+				// Without the option, absence alert rules are generated for all metrics.
+				Expect(parseMockRule(expr, opts)).ToNot(BeEmpty())
+			},
+			Entry("plain", "vector(1) unless foo_bar", []string{}),
+			Entry("with matchers", `vector(0) unless foo_bar{job="x"}`, []string{}),
+			Entry("in parentheses", "(vector(1)) unless (foo_bar)", []string{}),
+			Entry("combined with other metrics", "(vector(1) unless foo_bar) or bar_foo > 0", []string{"absent(bar_foo)"}),
+			Entry("other selector on the left-hand side", "bar_foo unless foo_bar", []string{"absent(bar_foo)", "absent(foo_bar)"}),
+		)
+
+		It("should skip metrics that are known to be optional", func() {
+			optionalOpts := controllers.ParseOpts{OptionalMetrics: map[string]bool{"foo_bar": true}}
+			rules := parseMockRule("foo_bar > 0 or foo_bar_total > 0", optionalOpts)
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].Expr.String()).To(Equal("absent(foo_bar_total)"))
+		})
+	})
+})
+
+///////////////////////////////////////////////////////////////////////////////
+// Helper functions
+
+// counterValue returns the value of a counter with a 'namespace' label from the
+// controller-runtime metrics registry.
+func counterValue(name, namespace string) float64 {
+	return counterValueWithLabel(name, "namespace", namespace)
+}
+
+// gaugeValue returns the value of a gauge with a 'namespace' label from the given
+// registry. Gauges are not registered on the controller-runtime metrics registry during
+// testing (see controllers.RegisterMetrics).
+func gaugeValue(g prometheus.Gatherer, name, namespace string) float64 {
+	families, err := g.Gather()
+	Expect(err).ToNot(HaveOccurred())
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "namespace" && l.GetValue() == namespace {
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// counterValueWithLabel returns the value of a counter with the given label from the
+// controller-runtime metrics registry.
+func counterValueWithLabel(name, labelName, labelValue string) float64 {
+	families, err := metrics.Registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == labelName && l.GetValue() == labelValue {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// histogramSampleCount returns the number of observations of a histogram with the given
+// label from the controller-runtime metrics registry.
+func histogramSampleCount(name, labelName, labelValue string) uint64 {
+	families, err := metrics.Registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == labelName && l.GetValue() == labelValue {
+					return m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// createMockRuleWithSeverity returns a mock alert rule for the given metric with the
+// given severity.
+func createMockRuleWithSeverity(metric, severity string) monitoringv1.Rule {
+	rule := createMockRule(metric)
+	rule.Labels["severity"] = severity
+	return rule
+}
+
+// parseMockRule generates the absence alert rules for an alert rule with the given
+// expression and returns them.
+func parseMockRule(expr string, opts controllers.ParseOpts) []monitoringv1.Rule {
+	rule := createMockRule("foo_bar")
+	rule.Expr = intstr.FromString(expr)
+	groups, err := controllers.ParseRuleGroups(logger, []monitoringv1.RuleGroup{{
+		Name:  "mock.alerts",
+		Rules: []monitoringv1.Rule{rule},
+	}}, "mock", opts)
+	Expect(err).ToNot(HaveOccurred())
+	if len(groups) == 0 {
+		return nil
+	}
+	Expect(groups).To(HaveLen(1))
+	return groups[0].Rules
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/sapcc/absent-metrics-operator/controllers"
+	"github.com/sapcc/absent-metrics-operator/internal/config"
+)
+
+var _ = Describe("Config file", func() {
+	var (
+		fs              *flag.FlagSet
+		keepLabels      string
+		defaultSeverity string
+		minFor          time.Duration
+		preserve        bool
+		staticLabels    string
+		promServerKey   string
+	)
+
+	BeforeEach(func() {
+		fs = flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.StringVar(&keepLabels, "keep-labels", "support_group,service", "")
+		fs.StringVar(&defaultSeverity, "default-severity", "info", "")
+		fs.DurationVar(&minFor, "min-for", 0, "")
+		fs.BoolVar(&preserve, "preserve-matchers", false, "")
+		fs.StringVar(&staticLabels, "static-labels", "", "")
+		fs.StringVar(&promServerKey, "prometheus-server-label-key", controllers.DefaultPrometheusServerLabelKey, "")
+	})
+
+	writeConfig := func(content string) string {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+		return path
+	}
+
+	It("should apply the values of the file", func() {
+		path := writeConfig(`
+keep-labels: [support_group, tier]
+default-severity: warning
+min-for: 10m
+preserve-matchers: true
+static-labels:
+  team: monitoring
+  context: absent
+`)
+		Expect(fs.Parse(nil)).To(Succeed())
+		Expect(config.Apply(fs, path)).To(Succeed())
+		Expect(keepLabels).To(Equal("support_group,tier"))
+		Expect(defaultSeverity).To(Equal("warning"))
+		Expect(minFor).To(Equal(10 * time.Minute))
+		Expect(preserve).To(BeTrue())
+		Expect(staticLabels).To(Equal("context=absent,team=monitoring"))
+	})
+
+	It("should apply the key of the Prometheus server label", func() {
+		Expect(fs.Parse(nil)).To(Succeed())
+		Expect(config.Apply(fs, writeConfig("prometheus-server-label-key: prometheus-instance\n"))).To(Succeed())
+		Expect(promServerKey).To(Equal("prometheus-instance"))
+
+		r := &controllers.PrometheusRuleReconciler{
+			Log:                      logger,
+			KeepLabel:                keepLabel,
+			PrometheusServerLabel:    true,
+			PrometheusServerLabelKey: promServerKey,
+		}
+		pr := &monitoringv1.PrometheusRule{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "openstack-api.alerts",
+				Namespace: "api",
+				// The 'prometheus' label is ignored.
+				Labels: map[string]string{"prometheus-instance": "openstack", "prometheus": "infra"},
+			},
+			Spec: monitoringv1.PrometheusRuleSpec{
+				Groups: []monitoringv1.RuleGroup{{
+					Name:  "api.alerts",
+					Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+				}},
+			},
+		}
+		aPR, err := r.GenerateAbsencePrometheusRule(pr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(aPR.Name).To(Equal("openstack-absent-metric-alert-rules"))
+		Expect(aPR.Labels).To(HaveKeyWithValue("prometheus-instance", "openstack"))
+		Expect(aPR.Labels).ToNot(HaveKey("prometheus"))
+		Expect(aPR.Spec.Groups).To(HaveLen(1))
+		Expect(aPR.Spec.Groups[0].Rules).To(HaveLen(1))
+		Expect(aPR.Spec.Groups[0].Rules[0].Labels).To(HaveKeyWithValue("prometheus-instance", "openstack"))
+		Expect(aPR.Spec.Groups[0].Rules[0].Labels).ToNot(HaveKey("prometheus"))
+
+		// A PrometheusRule with only the 'prometheus' label has no Prometheus server.
+		pr.Labels = map[string]string{"prometheus": "openstack"}
+		_, err = r.GenerateAbsencePrometheusRule(pr)
+		Expect(err).To(MatchError("no 'prometheus-instance' label found"))
+	})
+
+	It("should not override flags that are given explicitly", func() {
+		path := writeConfig("default-severity: warning\nmin-for: 10m\n")
+		Expect(fs.Parse([]string{"-default-severity=critical"})).To(Succeed())
+		Expect(config.Apply(fs, path)).To(Succeed())
+		Expect(defaultSeverity).To(Equal("critical"))
+		Expect(minFor).To(Equal(10 * time.Minute))
+	})
+
+	It("should reject unknown options and invalid values", func() {
+		Expect(fs.Parse(nil)).To(Succeed())
+		Expect(config.Apply(fs, writeConfig("no-such-flag: true\n"))).ToNot(Succeed())
+		Expect(config.Apply(fs, writeConfig("min-for: soon\n"))).ToNot(Succeed())
+	})
+})
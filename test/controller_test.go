@@ -15,6 +15,9 @@
 package test
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
@@ -25,18 +28,28 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-logr/logr/funcr"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
 
 	"github.com/sapcc/absent-metrics-operator/controllers"
+	"github.com/sapcc/absent-metrics-operator/internal/config"
 )
 
 var _ = Describe("Controller", func() {
@@ -119,11 +132,13 @@ var _ = Describe("Controller", func() {
 	Describe("Update", func() {
 		objKey := newObjKey(swiftNs, "openstack-swift.alerts")
 		prObjKey := newObjKey(swiftNs, osAbsentPRName)
-		labelOpts := controllers.LabelOpts{
-			DefaultSupportGroup: "not-containers",
-			DefaultTier:         "os",
-			DefaultService:      "swift",
-			Keep:                keepLabel,
+		parseOpts := controllers.ParseOpts{
+			LabelOpts: controllers.LabelOpts{
+				DefaultSupportGroup: "not-containers",
+				DefaultTier:         "os",
+				DefaultService:      "swift",
+				Keep:                keepLabel,
+			},
 		}
 		fooBar := "foo_bar"
 		barFoo := "bar_foo"
@@ -141,7 +156,7 @@ var _ = Describe("Controller", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				// Generate the corresponding absent alert rules.
-				expected, err := controllers.ParseRuleGroups(logger, pr.Spec.Groups, pr.GetName(), labelOpts)
+				expected, err := controllers.ParseRuleGroups(logger, pr.Spec.Groups, pr.GetName(), parseOpts)
 				Expect(err).ToNot(HaveOccurred())
 
 				// Get the updated AbsentPromRule from the server and check if it has the
@@ -175,7 +190,7 @@ var _ = Describe("Controller", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				// Generate the corresponding absent alert rules.
-				expected, err := controllers.ParseRuleGroups(logger, pr.Spec.Groups, pr.GetName(), labelOpts)
+				expected, err := controllers.ParseRuleGroups(logger, pr.Spec.Groups, pr.GetName(), parseOpts)
 				Expect(err).ToNot(HaveOccurred())
 
 				// Get the updated AbsentPromRule from the server and check if the
@@ -263,11 +278,13 @@ var _ = Describe("Controller", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				// Generate the corresponding absent alert rules.
-				expected, err := controllers.ParseRuleGroups(logger, pr.Spec.Groups, pr.GetName(), controllers.LabelOpts{
-					DefaultSupportGroup: "not-containers",
-					DefaultTier:         "os",
-					DefaultService:      "swift",
-					Keep:                keepLabel,
+				expected, err := controllers.ParseRuleGroups(logger, pr.Spec.Groups, pr.GetName(), controllers.ParseOpts{
+					LabelOpts: controllers.LabelOpts{
+						DefaultSupportGroup: "not-containers",
+						DefaultTier:         "os",
+						DefaultService:      "swift",
+						Keep:                keepLabel,
+					},
 				})
 				Expect(err).ToNot(HaveOccurred())
 
@@ -297,6 +314,2574 @@ var _ = Describe("Controller", func() {
 		})
 	})
 
+	Describe("Preflight check", func() {
+		It("should succeed if the PrometheusRule CRD is installed", func() {
+			Expect(controllers.CheckPrometheusRuleCRD(k8sClient.RESTMapper())).To(Succeed())
+		})
+
+		It("should fail with a clear error if the PrometheusRule CRD is missing", func() {
+			err := controllers.CheckPrometheusRuleCRD(meta.NewDefaultRESTMapper(nil))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("the PrometheusRule CRD (monitoring.coreos.com/v1) is not installed"))
+		})
+	})
+
+	Describe("Fallback Prometheus server", func() {
+		fallbackNs := "fallback"
+		objKey := newObjKey(fallbackNs, "fallback.alerts")
+		prObjKey := newObjKey(fallbackNs, controllers.AbsencePrometheusRuleName(fallbackPromServer))
+
+		It("should be used for a PrometheusRule without 'prometheus' label", func() {
+			Expect(ensureNamespace(ctx, fallbackNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{Name: objKey.Name, Namespace: objKey.Namespace},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "fallback.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+
+			waitForControllerToProcess()
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels["prometheus"]).To(Equal(fallbackPromServer))
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Describe("AbsencePrometheusRule managed by a different instance", func() {
+		foreignNs := "foreign"
+		objKey := newObjKey(foreignNs, "foreign.alerts")
+		prObjKey := newObjKey(foreignNs, controllers.AbsencePrometheusRuleName("openstack"))
+
+		It("should not be modified", func() {
+			Expect(ensureNamespace(ctx, foreignNs)).To(Succeed())
+			foreignPR := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      prObjKey.Name,
+					Namespace: prObjKey.Namespace,
+					Labels: map[string]string{
+						"other-operator/managed-by": "true",
+						"prometheus":                "openstack",
+					},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name: "other.alerts",
+						Rules: []monitoringv1.Rule{{
+							Alert: "AbsentOtherMetric",
+							Expr:  intstr.FromString("absent(other_metric)"),
+						}},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &foreignPR)).To(Succeed())
+
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "foreign.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+
+			waitForControllerToProcess()
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).ToNot(HaveKey("absent-metrics-operator/managed-by"))
+			Expect(aPR.Spec).To(Equal(foreignPR.Spec))
+
+			// Delete the PromRules so that they don't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			Expect(deletePromRule(prObjKey)).To(Succeed())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Adoption of existing AbsencePrometheusRules", func() {
+		adoptionNs := "adoption"
+		objKey := newObjKey(adoptionNs, "adoption.alerts")
+		prObjKey := newObjKey(adoptionNs, controllers.AbsencePrometheusRuleName("openstack-adoption"))
+
+		It("should leave resources alone that were not created by this version if disabled", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:          k8sClient,
+				Scheme:          k8sClient.Scheme(),
+				Log:             logger,
+				KeepLabel:       keepLabel,
+				InstanceID:      "adoption",
+				DisableAdoption: true,
+			}
+
+			// An AbsencePrometheusRule that was created by another version of the operator
+			// does not have the 'owned' annotation.
+			Expect(ensureNamespace(ctx, adoptionNs)).To(Succeed())
+			oldAPR := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      prObjKey.Name,
+					Namespace: prObjKey.Namespace,
+					Labels: map[string]string{
+						"absent-metrics-operator/managed-by": "adoption",
+						"prometheus":                         "openstack",
+					},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name: "old.alerts/old.alerts",
+						Rules: []monitoringv1.Rule{{
+							Alert: "AbsentOldMetric",
+							Expr:  intstr.FromString("absent(old_metric)"),
+						}},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &oldAPR)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "adoption.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).To(HaveOccurred())
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: prObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Annotations).To(BeEmpty())
+			Expect(aPR.Spec).To(Equal(oldAPR.Spec))
+
+			// With adoption, the AbsencePrometheusRule is reconciled and marked as owned.
+			r.DisableAdoption = false
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Annotations).To(HaveKeyWithValue("absent-metrics-operator/owned", "true"))
+			Expect(aPR.Spec.Groups).To(HaveLen(2))
+
+			// Delete the PromRule and the AbsencePrometheusRule so that they don't affect
+			// the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			Expect(deletePromRule(prObjKey)).To(Succeed())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Rule group sources", func() {
+		provenanceNs := "provenance"
+		objKey := newObjKey(provenanceNs, "provenance.alerts")
+		prObjKey := newObjKey(provenanceNs, controllers.AbsencePrometheusRuleName("openstack"))
+
+		It("should be preferred over the rule group name during clean up", func() {
+			Expect(ensureNamespace(ctx, provenanceNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "provenance.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			// Add rule groups whose names do not follow the naming convention of the
+			// operator. Their sources are recorded in the annotation instead.
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+			customRule := monitoringv1.Rule{Alert: "AbsentCustomMetric", Expr: intstr.FromString("absent(custom_metric)")}
+			aPR.Spec.Groups = append(aPR.Spec.Groups,
+				monitoringv1.RuleGroup{Name: "custom-kept", Rules: []monitoringv1.Rule{customRule}},
+				monitoringv1.RuleGroup{Name: "custom-orphan", Rules: []monitoringv1.Rule{customRule}},
+			)
+			aPR.Annotations["absent-metrics-operator/rule-group-sources"] = `{` +
+				`"custom-kept":{"namespace":"provenance","name":"provenance.alerts"},` +
+				`"custom-orphan":{"namespace":"provenance","name":"deleted.alerts"}}`
+			Expect(k8sClient.Update(ctx, &aPR)).To(Succeed())
+			waitForControllerToProcess()
+
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			var groupNames []string
+			for _, g := range aPR.Spec.Groups {
+				groupNames = append(groupNames, g.Name)
+			}
+			Expect(groupNames).To(HaveLen(2))
+			Expect(groupNames).To(ContainElement("custom-kept"))
+			Expect(aPR.Annotations["absent-metrics-operator/rule-group-sources"]).
+				To(Equal(`{"custom-kept":{"namespace":"provenance","name":"provenance.alerts"}}`))
+
+			// Deleting the PrometheusRule should also clean up the rule group that was
+			// recorded for it.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Create delay", func() {
+		delayNs := "delay"
+		objKey := newObjKey(delayNs, "delay.alerts")
+
+		It("should defer the processing of new PrometheusRules", func() {
+			Expect(ensureNamespace(ctx, delayNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "delay.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			r := &controllers.PrometheusRuleReconciler{
+				Client:      k8sClient,
+				Scheme:      k8sClient.Scheme(),
+				Log:         logger,
+				KeepLabel:   keepLabel,
+				CreateDelay: time.Hour,
+			}
+			req := ctrl.Request{NamespacedName: objKey}
+			result, err := r.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 50*time.Minute))
+			Expect(result.RequeueAfter).To(BeNumerically("<=", time.Hour))
+
+			// Once the PrometheusRule is older than the delay, it is processed as usual.
+			r.CreateDelay = time.Nanosecond
+			result, err = r.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(5 * time.Minute))
+			_, err = getPromRule(newObjKey(delayNs, controllers.AbsencePrometheusRuleName("openstack")))
+			Expect(err).ToNot(HaveOccurred())
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Aggregation per namespace", func() {
+		aggregatedNs := "aggregated"
+		osObjKey := newObjKey(aggregatedNs, "openstack.alerts")
+		k8sObjKey := newObjKey(aggregatedNs, "kubernetes.alerts")
+		prObjKey := newObjKey(aggregatedNs, controllers.AbsencePrometheusRuleName("namespace"))
+
+		// We use a separate reconciler with a different managed-by label so that the
+		// reconciler of the test suite does not interfere with this test.
+		r := &controllers.PrometheusRuleReconciler{
+			KeepLabel:                keepLabel,
+			FallbackPrometheusServer: fallbackPromServer,
+			ManagedByLabel:           "aggregated/managed-by",
+			Aggregation:              controllers.AggregationNamespace,
+		}
+
+		It("should aggregate the absence alert rules of all Prometheus servers into one resource", func() {
+			r.Client = k8sClient
+			r.Scheme = k8sClient.Scheme()
+			r.Log = logger
+
+			Expect(ensureNamespace(ctx, aggregatedNs)).To(Succeed())
+			for _, v := range []struct {
+				key        client.ObjectKey
+				promServer string
+				metric     string
+			}{
+				{osObjKey, "openstack", "foo_bar"},
+				{k8sObjKey, "kubernetes", "bar_foo"},
+			} {
+				pr := monitoringv1.PrometheusRule{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      v.key.Name,
+						Namespace: v.key.Namespace,
+						Labels:    map[string]string{"prometheus": v.promServer},
+					},
+					Spec: monitoringv1.PrometheusRuleSpec{
+						Groups: []monitoringv1.RuleGroup{{
+							Name:  v.key.Name,
+							Rules: []monitoringv1.Rule{createMockRule(v.metric)},
+						}},
+					},
+				}
+				Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+				waitForControllerToProcess()
+				_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: v.key})
+				Expect(err).ToNot(HaveOccurred())
+				waitForControllerToProcess()
+			}
+
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).ToNot(HaveKey("prometheus"))
+			Expect(aPR.Spec.Groups).To(HaveLen(2))
+			Expect(aPR.Spec.Groups[0].Name).To(Equal("kubernetes.alerts/kubernetes.alerts"))
+			Expect(aPR.Spec.Groups[0].Rules[0].Labels).To(HaveKeyWithValue("prometheus", "kubernetes"))
+			Expect(aPR.Spec.Groups[1].Name).To(Equal("openstack.alerts/openstack.alerts"))
+			Expect(aPR.Spec.Groups[1].Rules[0].Labels).To(HaveKeyWithValue("prometheus", "openstack"))
+
+			// Deleting a PrometheusRule should only clean up its own absence alert rules.
+			Expect(deletePromRule(osObjKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: osObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+			Expect(aPR.Spec.Groups[0].Name).To(Equal("kubernetes.alerts/kubernetes.alerts"))
+			waitForControllerToProcess()
+
+			Expect(deletePromRule(k8sObjKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: k8sObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Coverage dropped", func() {
+		coverageNs := "coverage"
+		objKey := newObjKey(coverageNs, "coverage.alerts")
+		prObjKey := newObjKey(coverageNs, osAbsentPRName)
+
+		It("should be counted when a PrometheusRule no longer has any absence alert rules", func() {
+			Expect(ensureNamespace(ctx, coverageNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "coverage.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(counterValue("absent_metrics_operator_coverage_dropped_total", coverageNs)).To(Equal(float64(0)))
+
+			// Cover the metric in the original alert rule so that no absence alert rules
+			// are generated anymore.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Spec.Groups[0].Rules[0].Expr = intstr.FromString("absent(foo_bar) or foo_bar > 0")
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			Expect(counterValue("absent_metrics_operator_coverage_dropped_total", coverageNs)).To(Equal(float64(1)))
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Multiple operator instances", func() {
+		instancesNs := "instances"
+		objKey := newObjKey(instancesNs, "instances.alerts")
+		aObjKey := newObjKey(instancesNs, controllers.AbsencePrometheusRuleName("openstack-a"))
+		bObjKey := newObjKey(instancesNs, controllers.AbsencePrometheusRuleName("openstack-b"))
+
+		newReconciler := func(instanceID string) *controllers.PrometheusRuleReconciler {
+			return &controllers.PrometheusRuleReconciler{
+				Client:                   k8sClient,
+				Scheme:                   k8sClient.Scheme(),
+				Log:                      logger,
+				KeepLabel:                keepLabel,
+				FallbackPrometheusServer: fallbackPromServer,
+				InstanceID:               instanceID,
+			}
+		}
+
+		It("should coexist without deleting each other's AbsencePrometheusRules", func() {
+			ra, rb := newReconciler("a"), newReconciler("b")
+
+			Expect(ensureNamespace(ctx, instancesNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "instances.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			req := ctrl.Request{NamespacedName: objKey}
+			_, err := ra.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rb.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			waitForControllerToProcess()
+
+			for id, key := range map[string]client.ObjectKey{"a": aObjKey, "b": bObjKey} {
+				aPR, err := getPromRule(key)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(aPR.Labels).To(HaveKeyWithValue("absent-metrics-operator/managed-by", id))
+				Expect(aPR.Spec.Groups).To(HaveLen(1))
+			}
+
+			// An instance ignores the AbsencePrometheusRules of other instances.
+			_, err = ra.Reconcile(ctx, ctrl.Request{NamespacedName: bObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(bObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+
+			// Clean up by one instance should not affect the other instance.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = ra.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(aObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			_, err = getPromRule(bObjKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = rb.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(bObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Orphan sweep", func() {
+		sweepNs := "sweep"
+		prObjKey := newObjKey(sweepNs, controllers.AbsencePrometheusRuleName("openstack-sweep"))
+
+		It("should clean up absence alert rules whose PrometheusRule no longer exists", func() {
+			// We use a separate reconciler with a different instance ID so that the
+			// reconciler of the test suite does not clean up the orphan itself.
+			r := &controllers.PrometheusRuleReconciler{
+				Client:                   k8sClient,
+				Scheme:                   k8sClient.Scheme(),
+				Log:                      logger,
+				KeepLabel:                keepLabel,
+				FallbackPrometheusServer: fallbackPromServer,
+				InstanceID:               "sweep",
+			}
+
+			Expect(ensureNamespace(ctx, sweepNs)).To(Succeed())
+			orphan := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      prObjKey.Name,
+					Namespace: prObjKey.Namespace,
+					Labels: map[string]string{
+						"absent-metrics-operator/managed-by": "sweep",
+						"prometheus":                         "openstack",
+					},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name: "missing.alerts/missing.alerts",
+						Rules: []monitoringv1.Rule{{
+							Alert: "AbsentFooBar",
+							Expr:  intstr.FromString("absent(foo_bar)"),
+						}},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &orphan)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(r.SweepOrphans(ctx)).To(Succeed())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Disabled label inference", func() {
+		inferenceNs := "inference"
+		objKey := newObjKey(inferenceNs, "inference.alerts")
+		prObjKey := newObjKey(inferenceNs, controllers.AbsencePrometheusRuleName("openstack-noinfer"))
+
+		It("should not list other PrometheusRules and still retain the labels of alert rules", func() {
+			c := &listCountingClient{Client: k8sClient}
+			r := &controllers.PrometheusRuleReconciler{
+				Client:                   c,
+				Scheme:                   k8sClient.Scheme(),
+				Log:                      logger,
+				KeepLabel:                keepLabel,
+				FallbackPrometheusServer: fallbackPromServer,
+				InstanceID:               "noinfer",
+				DisableLabelInference:    true,
+			}
+
+			Expect(ensureNamespace(ctx, inferenceNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "inference.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.lists).To(Equal(0))
+
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).ToNot(HaveKey("tier"))
+			Expect(aPR.Labels).ToNot(HaveKey("service"))
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+			Expect(aPR.Spec.Groups[0].Rules[0].Labels).To(HaveKeyWithValue("tier", "tier"))
+			Expect(aPR.Spec.Groups[0].Rules[0].Labels).To(HaveKeyWithValue("service", "service"))
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Optional metrics", func() {
+		optionalNs := "optional"
+		objKey := newObjKey(optionalNs, "optional.alerts")
+		prObjKey := newObjKey(optionalNs, controllers.AbsencePrometheusRuleName("openstack-optional"))
+		cmKey := newObjKey(optionalNs, "optional-metrics")
+
+		It("should be skipped and reloaded when the ConfigMap changes", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:                   k8sClient,
+				Scheme:                   k8sClient.Scheme(),
+				Log:                      logger,
+				KeepLabel:                keepLabel,
+				InstanceID:               "optional",
+				OptionalMetricsConfigMap: cmKey,
+			}
+
+			Expect(ensureNamespace(ctx, optionalNs)).To(Succeed())
+			cm := corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: cmKey.Name, Namespace: cmKey.Namespace},
+				Data:       map[string]string{"team-a": "foo_bar\nbar_foo\n"},
+			}
+			Expect(k8sClient.Create(ctx, &cm)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "optional.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar"), createMockRule("foo_baz")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+			Expect(aPR.Spec.Groups[0].Rules).To(HaveLen(1))
+			Expect(aPR.Spec.Groups[0].Rules[0].Expr.String()).To(Equal("absent(foo_baz)"))
+
+			// Once the metric is removed from the ConfigMap, it gets an absence alert rule.
+			cm.Data = map[string]string{"team-a": "bar_foo"}
+			Expect(k8sClient.Update(ctx, &cm)).To(Succeed())
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+			Expect(aPR.Spec.Groups[0].Rules).To(HaveLen(2))
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &cm)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Merged rule groups", func() {
+		mergeNs := "merge"
+		objKey := newObjKey(mergeNs, "merge.alerts")
+		prObjKey := newObjKey(mergeNs, controllers.AbsencePrometheusRuleName("openstack-merge"))
+
+		It("should be counted per action", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "merge",
+			}
+
+			Expect(ensureNamespace(ctx, mergeNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{
+						{Name: "a.alerts", Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+						{Name: "b.alerts", Rules: []monitoringv1.Rule{createMockRule("foo_baz")}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+
+			// Update the first rule group, keep the second one, and add a third one.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Spec.Groups[0].Rules = []monitoringv1.Rule{createMockRule("bar_foo")}
+			pr.Spec.Groups = append(pr.Spec.Groups,
+				monitoringv1.RuleGroup{Name: "c.alerts", Rules: []monitoringv1.Rule{createMockRule("baz_foo")}})
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			// The operator instance of the test suite has processed the update in the
+			// meantime therefore only the difference is checked.
+			name := "absent_metrics_operator_groups_merged_total"
+			carriedOver := counterValueWithLabel(name, "action", "carried_over")
+			updated := counterValueWithLabel(name, "action", "updated")
+			added := counterValueWithLabel(name, "action", "added")
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(counterValueWithLabel(name, "action", "carried_over") - carriedOver).To(Equal(1.0))
+			Expect(counterValueWithLabel(name, "action", "updated") - updated).To(Equal(1.0))
+			Expect(counterValueWithLabel(name, "action", "added") - added).To(Equal(1.0))
+
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(3))
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Annotation prefix", func() {
+		annotationsNs := "annotations"
+		objKey := newObjKey(annotationsNs, "annotations.alerts")
+		prObjKey := newObjKey(annotationsNs, controllers.AbsencePrometheusRuleName("openstack-prefix"))
+
+		It("should be used for the annotations of AbsencePrometheusRules", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:                 k8sClient,
+				Scheme:                 k8sClient.Scheme(),
+				Log:                    logger,
+				KeepLabel:              keepLabel,
+				InstanceID:             "prefix",
+				RecordRuleGroupSources: true,
+				AnnotationPrefix:       "example.com",
+			}
+
+			Expect(ensureNamespace(ctx, annotationsNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "annotations.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Annotations).To(HaveKey("example.com/updated-at"))
+			Expect(aPR.Annotations).To(HaveKey("example.com/rule-group-sources"))
+			Expect(aPR.Annotations).ToNot(HaveKey("absent-metrics-operator/updated-at"))
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Rule group hashes", func() {
+		hashesNs := "hashes"
+		objKey := newObjKey(hashesNs, "hashes.alerts")
+		prObjKey := newObjKey(hashesNs, controllers.AbsencePrometheusRuleName("openstack-hashes"))
+
+		It("should skip the update of unchanged rule groups", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:         k8sClient,
+				Scheme:         k8sClient.Scheme(),
+				Log:            logger,
+				KeepLabel:      keepLabel,
+				InstanceID:     "hashes",
+				HashRuleGroups: true,
+			}
+
+			Expect(ensureNamespace(ctx, hashesNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "hashes.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Annotations).To(HaveKey("absent-metrics-operator/rule-group-hashes"))
+
+			// An unchanged rule group is not updated.
+			rv := aPR.ResourceVersion
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.ResourceVersion).To(Equal(rv))
+
+			// A change to the absence alert rule behind the operator's back is reverted even
+			// though the recorded hash is unchanged.
+			aPR.Spec.Groups[0].Rules[0].Annotations["summary"] = "changed"
+			Expect(k8sClient.Update(ctx, &aPR)).To(Succeed())
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups[0].Rules[0].Annotations).To(HaveKeyWithValue("summary", "missing foo_bar"))
+
+			// A change to the PrometheusRule changes the hash and updates the rule group.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Spec.Groups[0].Rules = append(pr.Spec.Groups[0].Rules, createMockRule("foo_baz"))
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups[0].Rules).To(HaveLen(2))
+			Expect(aPR.Spec.Groups[0].Rules[0].Annotations).To(HaveKeyWithValue("summary", "missing foo_bar"))
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Requeue after", func() {
+		requeueNs := "requeue"
+		objKey := newObjKey(requeueNs, "requeue.alerts")
+		prObjKey := newObjKey(requeueNs, controllers.AbsencePrometheusRuleName("openstack-requeue"))
+
+		It("should requeue successfully reconciled resources and correct drift", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:       k8sClient,
+				Scheme:       k8sClient.Scheme(),
+				Log:          logger,
+				KeepLabel:    keepLabel,
+				InstanceID:   "requeue",
+				RequeueAfter: 42 * time.Second,
+			}
+
+			Expect(ensureNamespace(ctx, requeueNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "requeue.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(42 * time.Second))
+			result, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: prObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(42 * time.Second))
+
+			// Change the absence alert rule behind the operator's back. The change is
+			// reverted when the PrometheusRule is requeued.
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			aPR.Spec.Groups[0].Rules[0].Annotations["summary"] = "changed"
+			Expect(k8sClient.Update(ctx, &aPR)).To(Succeed())
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups[0].Rules[0].Annotations).To(HaveKeyWithValue("summary", "missing foo_bar"))
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Excluded PrometheusRules", func() {
+		excludeNs := "exclude"
+		helmObjKey := newObjKey(excludeNs, "helm.alerts")
+		ownedObjKey := newObjKey(excludeNs, "owned.alerts")
+		prObjKey := newObjKey(excludeNs, controllers.AbsencePrometheusRuleName("openstack-exclude"))
+
+		newPromRule := func(key types.NamespacedName) monitoringv1.PrometheusRule {
+			return monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  key.Name,
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+		}
+
+		It("should not generate absence alert rules for excluded PrometheusRules", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "exclude",
+			}
+
+			Expect(ensureNamespace(ctx, excludeNs)).To(Succeed())
+			helmPR := newPromRule(helmObjKey)
+			helmPR.Labels["app.kubernetes.io/managed-by"] = "Helm"
+			Expect(k8sClient.Create(ctx, &helmPR)).To(Succeed())
+			ownedPR := newPromRule(ownedObjKey)
+			ownedPR.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: "monitoring.coreos.com/v1",
+				Kind:       "Alertmanager",
+				Name:       "main",
+				UID:        "c0ffee",
+			}}
+			Expect(k8sClient.Create(ctx, &ownedPR)).To(Succeed())
+			waitForControllerToProcess()
+
+			// Without exclusion, absence alert rules are generated for the PrometheusRule.
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: helmObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			// With exclusion, they are removed and no longer generated.
+			r.ExcludeLabels = map[string]string{"app.kubernetes.io/managed-by": "Helm"}
+			r.ExcludeOwnerKinds = map[string]bool{"Alertmanager": true}
+			for _, key := range []types.NamespacedName{helmObjKey, ownedObjKey} {
+				result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.RequeueAfter).To(BeZero())
+				_, err = getPromRule(prObjKey)
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			}
+
+			// Delete the PromRules so that they don't affect the other tests.
+			Expect(deletePromRule(helmObjKey)).To(Succeed())
+			Expect(deletePromRule(ownedObjKey)).To(Succeed())
+			waitForControllerToProcess()
+		})
+
+		It("should not generate absence alert rules for PrometheusRules without a prometheus label", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:                        k8sClient,
+				Scheme:                        k8sClient.Scheme(),
+				Log:                           logger,
+				KeepLabel:                     keepLabel,
+				InstanceID:                    "unlabeled",
+				ExcludeWithoutPrometheusLabel: true,
+			}
+			unlabeledObjKey := newObjKey(excludeNs, "unlabeled.alerts")
+			labeledObjKey := newObjKey(excludeNs, "labeled.alerts")
+			labeledPRObjKey := newObjKey(excludeNs, controllers.AbsencePrometheusRuleName("openstack-unlabeled"))
+
+			Expect(ensureNamespace(ctx, excludeNs)).To(Succeed())
+			unlabeledPR := newPromRule(unlabeledObjKey)
+			delete(unlabeledPR.Labels, "prometheus")
+			Expect(k8sClient.Create(ctx, &unlabeledPR)).To(Succeed())
+			labeledPR := newPromRule(labeledObjKey)
+			Expect(k8sClient.Create(ctx, &labeledPR)).To(Succeed())
+			waitForControllerToProcess()
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: unlabeledObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero())
+			var absencePromRules monitoringv1.PrometheusRuleList
+			Expect(k8sClient.List(ctx, &absencePromRules, client.InNamespace(excludeNs),
+				client.MatchingLabels{"absent-metrics-operator/managed-by": "unlabeled"})).To(Succeed())
+			Expect(absencePromRules.Items).To(BeEmpty())
+
+			// PrometheusRules with a prometheus label are processed as usual.
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: labeledObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(labeledPRObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+			Expect(aPR.Spec.Groups[0].Name).To(Equal("labeled.alerts/labeled.alerts"))
+
+			Expect(deletePromRule(unlabeledObjKey)).To(Succeed())
+			Expect(deletePromRule(labeledObjKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: labeledObjKey})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("API server write duration", func() {
+		writesNs := "writes"
+		objKey := newObjKey(writesNs, "writes.alerts")
+		prObjKey := newObjKey(writesNs, controllers.AbsencePrometheusRuleName("openstack-writes"))
+		metricName := "absent_metrics_operator_apiserver_write_duration_seconds"
+
+		It("should be observed for each write of an AbsencePrometheusRule", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "writes",
+			}
+
+			Expect(ensureNamespace(ctx, writesNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "writes.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			// The suite's controller writes concurrently, therefore we only check that the
+			// number of observations increases.
+			creates := histogramSampleCount(metricName, "verb", "create")
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(histogramSampleCount(metricName, "verb", "create")).To(BeNumerically(">", creates))
+
+			// Delete the PromRule so that it doesn't affect the other tests. This deletes
+			// the AbsencePrometheusRule as well.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			deletes := histogramSampleCount(metricName, "verb", "delete")
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			Expect(histogramSampleCount(metricName, "verb", "delete")).To(BeNumerically(">", deletes))
+		})
+	})
+
+	Describe("Deleted absence alert rules", func() {
+		deletionsNs := "deletions"
+		fooObjKey := newObjKey(deletionsNs, "foo.alerts")
+		barObjKey := newObjKey(deletionsNs, "bar.alerts")
+		prObjKey := newObjKey(deletionsNs, controllers.AbsencePrometheusRuleName("openstack-deletions"))
+		metricName := "absent_metrics_operator_absence_rules_deleted_total"
+
+		newPromRule := func(key types.NamespacedName, metric string) monitoringv1.PrometheusRule {
+			return monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  key.Name,
+						Rules: []monitoringv1.Rule{createMockRule(metric)},
+					}},
+				},
+			}
+		}
+
+		It("should be counted when absence alert rules are cleaned up", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "deletions",
+			}
+
+			Expect(ensureNamespace(ctx, deletionsNs)).To(Succeed())
+			fooPR := newPromRule(fooObjKey, "foo_bar")
+			Expect(k8sClient.Create(ctx, &fooPR)).To(Succeed())
+			barPR := newPromRule(barObjKey, "bar_foo")
+			Expect(k8sClient.Create(ctx, &barPR)).To(Succeed())
+			waitForControllerToProcess()
+			for _, key := range []types.NamespacedName{fooObjKey, barObjKey} {
+				_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(2))
+
+			// The suite's controller cleans up concurrently, therefore we only check that
+			// the counter increases by at least the number of deleted absence alert rules.
+			//
+			// The absence rule group of a deleted PromRule is removed from the
+			// AbsencePrometheusRule.
+			before := counterValue(metricName, deletionsNs)
+			Expect(deletePromRule(fooObjKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: fooObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+			Expect(counterValue(metricName, deletionsNs)).To(BeNumerically(">=", before+1))
+
+			// The AbsencePrometheusRule is deleted together with its last absence rule group.
+			before = counterValue(metricName, deletionsNs)
+			Expect(deletePromRule(barObjKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: barObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			Expect(counterValue(metricName, deletionsNs)).To(BeNumerically(">=", before+1))
+		})
+	})
+
+	Describe("Empty result threshold", func() {
+		emptyNs := "emptyresult"
+		objKey := newObjKey(emptyNs, "emptyresult.alerts")
+		prObjKey := newObjKey(emptyNs, controllers.AbsencePrometheusRuleName("openstack-emptyresult"))
+
+		It("should not remove absence alert rules after a single empty result", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:               k8sClient,
+				Scheme:               k8sClient.Scheme(),
+				Log:                  logger,
+				KeepLabel:            keepLabel,
+				InstanceID:           "emptyresult",
+				EmptyResultThreshold: 2,
+			}
+
+			Expect(ensureNamespace(ctx, emptyNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "emptyresult.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			// Cover the metric in the original alert rule so that no absence alert rules
+			// are generated anymore. The first empty result is not enough to remove the
+			// existing absence alert rules.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Spec.Groups[0].Rules[0].Expr = intstr.FromString("absent(foo_bar) or foo_bar > 0")
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+
+			// A non-empty result in between resets the count.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Spec.Groups[0].Rules[0].Expr = intstr.FromString("foo_bar > 0")
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Spec.Groups[0].Rules[0].Expr = intstr.FromString("absent(foo_bar) or foo_bar > 0")
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			// The absence alert rules are removed once the empty result is stable.
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+		})
+
+		It("should count the empty results for each Prometheus server separately", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:                    k8sClient,
+				Scheme:                    k8sClient.Scheme(),
+				Log:                       logger,
+				KeepLabel:                 keepLabel,
+				InstanceID:                "emptyresult",
+				EmptyResultThreshold:      2,
+				PrometheusServerDelimiter: ".",
+			}
+			multiObjKey := newObjKey(emptyNs, "emptyresult-multi.alerts")
+			aPRObjKeys := []types.NamespacedName{
+				prObjKey,
+				newObjKey(emptyNs, controllers.AbsencePrometheusRuleName("kubernetes-emptyresult")),
+			}
+
+			Expect(ensureNamespace(ctx, emptyNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      multiObjKey.Name,
+					Namespace: multiObjKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack.kubernetes"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "emptyresult.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: multiObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			for _, key := range aPRObjKeys {
+				_, err = getPromRule(key)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			pr, err = getPromRule(multiObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Spec.Groups[0].Rules[0].Expr = intstr.FromString("absent(foo_bar) or foo_bar > 0")
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			// The first empty result does not remove the absence alert rules of any
+			// Prometheus server.
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: multiObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			for _, key := range aPRObjKeys {
+				_, err = getPromRule(key)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			// The second one removes them for all Prometheus servers.
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: multiObjKey})
+			Expect(err).ToNot(HaveOccurred())
+			for _, key := range aPRObjKeys {
+				_, err = getPromRule(key)
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			}
+
+			Expect(deletePromRule(multiObjKey)).To(Succeed())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Namespace rate limit", func() {
+		It("should not let a busy namespace delay the reconciles of other namespaces", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "ratelimit",
+				// The rate is low enough that no token is refilled while the test runs.
+				NamespaceRateLimiter: controllers.NewNamespaceRateLimiter(0.01, 3),
+			}
+
+			// Simulate an unbalanced load: the busy namespace uses up its burst and its
+			// remaining requests are requeued instead of occupying the workers.
+			for i := 0; i < 3; i++ {
+				result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: newObjKey("busy", fmt.Sprintf("busy-%d.alerts", i))})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.RequeueAfter).To(BeZero())
+			}
+			for i := 3; i < 10; i++ {
+				result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: newObjKey("busy", fmt.Sprintf("busy-%d.alerts", i))})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+				Expect(result.RequeueAfter).To(BeNumerically("<=", 100*time.Second))
+			}
+
+			// The quiet namespace is reconciled right away.
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: newObjKey("quiet", "quiet.alerts")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero())
+		})
+	})
+
+	Describe("Reconcile once", func() {
+		onceNs := "once"
+		objKey := newObjKey(onceNs, "once.alerts")
+		prObjKey := newObjKey(onceNs, controllers.AbsencePrometheusRuleName("openstack-once"))
+
+		It("should reconcile all PrometheusRules", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:                   k8sClient,
+				Scheme:                   k8sClient.Scheme(),
+				Log:                      logger,
+				KeepLabel:                keepLabel,
+				FallbackPrometheusServer: fallbackPromServer,
+				InstanceID:               "once",
+			}
+
+			Expect(ensureNamespace(ctx, onceNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "once.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			count, err := r.ReconcileAll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(BeNumerically(">", 0))
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+
+			// Delete the PromRule and the AbsencePrometheusRules of this instance so that
+			// they don't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			var absencePromRules monitoringv1.PrometheusRuleList
+			Expect(k8sClient.List(ctx, &absencePromRules,
+				client.MatchingLabels{"absent-metrics-operator/managed-by": "once"})).To(Succeed())
+			for _, aPR := range absencePromRules.Items {
+				Expect(k8sClient.Delete(ctx, aPR)).To(Succeed())
+			}
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Force relabel", func() {
+		relabelNs := "forcerelabel"
+		objKey := newObjKey(relabelNs, "forcerelabel.alerts")
+		prObjKey := newObjKey(relabelNs, controllers.AbsencePrometheusRuleName("openstack-forcerelabel"))
+
+		It("should update the labels of existing AbsencePrometheusRules", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:                   k8sClient,
+				Scheme:                   k8sClient.Scheme(),
+				Log:                      logger,
+				KeepLabel:                keepLabel,
+				FallbackPrometheusServer: fallbackPromServer,
+				InstanceID:               "forcerelabel",
+			}
+
+			Expect(ensureNamespace(ctx, relabelNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "forcerelabel.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			_, err := r.ReconcileAll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).To(HaveKeyWithValue("tier", "tier"))
+			Expect(aPR.Spec.Groups[0].Rules[0].Labels).To(HaveKeyWithValue("tier", "tier"))
+
+			// Without force relabel, the labels of the AbsencePrometheusRule are carried
+			// over after the kept labels were changed.
+			r.KeepLabel = controllers.KeepLabel{"severity": true}
+			_, err = r.ReconcileAll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).To(HaveKeyWithValue("tier", "tier"))
+
+			r.ForceRelabel = true
+			_, err = r.ReconcileAll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).ToNot(HaveKey("tier"))
+			Expect(aPR.Labels).ToNot(HaveKey("service"))
+			Expect(aPR.Spec.Groups[0].Rules[0].Labels).ToNot(HaveKey("tier"))
+
+			// Delete the PromRule and the AbsencePrometheusRules of this instance so that
+			// they don't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			var absencePromRules monitoringv1.PrometheusRuleList
+			Expect(k8sClient.List(ctx, &absencePromRules,
+				client.MatchingLabels{"absent-metrics-operator/managed-by": "forcerelabel"})).To(Succeed())
+			for _, aPR := range absencePromRules.Items {
+				Expect(k8sClient.Delete(ctx, aPR)).To(Succeed())
+			}
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("ConfigMap output", func() {
+		cmNs := "configmap"
+		objKey := newObjKey(cmNs, "configmap.alerts")
+		cmObjKey := newObjKey(cmNs, controllers.AbsencePrometheusRuleName("openstack-configmap"))
+
+		// ruleFile mirrors the Prometheus rule file format.
+		type ruleFile struct {
+			Groups []struct {
+				Name     string `json:"name"`
+				Interval string `json:"interval,omitempty"`
+				Rules    []struct {
+					Alert       string            `json:"alert"`
+					Expr        string            `json:"expr"`
+					For         string            `json:"for,omitempty"`
+					Labels      map[string]string `json:"labels,omitempty"`
+					Annotations map[string]string `json:"annotations,omitempty"`
+				} `json:"rules"`
+			} `json:"groups"`
+		}
+
+		It("should write the absence alert rules as a rule file into a ConfigMap", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "configmap",
+				Output:     controllers.OutputConfigMap,
+			}
+
+			Expect(ensureNamespace(ctx, cmNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "configmap.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar"), createMockRule("bar_foo")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+
+			// No AbsencePrometheusRule is created.
+			_, err = getPromRule(cmObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			var cm corev1.ConfigMap
+			Expect(k8sClient.Get(ctx, cmObjKey, &cm)).To(Succeed())
+			Expect(cm.Labels).To(HaveKeyWithValue("absent-metrics-operator/managed-by", "configmap"))
+			Expect(cm.Labels).To(HaveKeyWithValue("prometheus", "openstack"))
+			Expect(cm.Data).To(HaveKey(controllers.ConfigMapRulesKey))
+
+			var rf ruleFile
+			Expect(yaml.UnmarshalStrict([]byte(cm.Data[controllers.ConfigMapRulesKey]), &rf)).To(Succeed())
+			Expect(rf.Groups).To(HaveLen(1))
+			Expect(rf.Groups[0].Name).To(Equal("configmap.alerts/configmap.alerts"))
+			var alerts []string
+			for _, rule := range rf.Groups[0].Rules {
+				alerts = append(alerts, rule.Alert)
+				_, err := parser.ParseExpr(rule.Expr)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = model.ParseDuration(rule.For)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(alerts).To(Equal([]string{"AbsentTierServiceBarFoo", "AbsentTierServiceFooBar"}))
+
+			// The ConfigMap is cleaned up when the PromRule is deleted.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Get(ctx, cmObjKey, &cm)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Duplicate alert names", func() {
+		dupNs := "duplicates"
+		objKey := newObjKey(dupNs, "duplicates.alerts")
+		prObjKey := newObjKey(dupNs, controllers.AbsencePrometheusRuleName("openstack-duplicates"))
+
+		alertNames := func() map[string][]string {
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			result := make(map[string][]string)
+			for _, g := range aPR.Spec.Groups {
+				for _, rule := range g.Rules {
+					result[g.Name] = append(result[g.Name], rule.Alert)
+				}
+			}
+			return result
+		}
+
+		It("should warn about or disambiguate alert names that are used in multiple rule groups", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "duplicates",
+			}
+
+			Expect(ensureNamespace(ctx, dupNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{
+						{Name: "b", Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+						{Name: "a", Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			// By default, the duplicates are only logged.
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(alertNames()).To(Equal(map[string][]string{
+				"duplicates.alerts/a": {"AbsentTierServiceFooBar"},
+				"duplicates.alerts/b": {"AbsentTierServiceFooBar"},
+			}))
+
+			// The first rule group by name keeps the original name.
+			r.DuplicateAlertNames = controllers.DuplicateAlertNamesDisambiguate
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(alertNames()).To(Equal(map[string][]string{
+				"duplicates.alerts/a": {"AbsentTierServiceFooBar"},
+				"duplicates.alerts/b": {"AbsentTierServiceFooBar2"},
+			}))
+
+			// The result is stable.
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(alertNames()).To(Equal(map[string][]string{
+				"duplicates.alerts/a": {"AbsentTierServiceFooBar"},
+				"duplicates.alerts/b": {"AbsentTierServiceFooBar2"},
+			}))
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Type label template", func() {
+		typeNs := "typelabel"
+		objKey := newObjKey(typeNs, "typelabel.alerts")
+		prObjKey := newObjKey(typeNs, controllers.AbsencePrometheusRuleName("openstack-typelabel"))
+
+		It("should propagate the type label of the PrometheusRule", func() {
+			tmpl, err := controllers.ParseTypeLabelTemplate(`{{index .Labels "type"}}`)
+			Expect(err).ToNot(HaveOccurred())
+			r := &controllers.PrometheusRuleReconciler{
+				Client:            k8sClient,
+				Scheme:            k8sClient.Scheme(),
+				Log:               logger,
+				KeepLabel:         keepLabel,
+				InstanceID:        "typelabel",
+				TypeLabelTemplate: tmpl,
+			}
+
+			Expect(ensureNamespace(ctx, typeNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack", "type": "critical-alerting-rules"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "typelabel.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).To(HaveKeyWithValue("type", "critical-alerting-rules"))
+
+			// Changes of the type label are applied to the existing AbsencePrometheusRule.
+			// Without a type label, the default is used.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			delete(pr.Labels, "type")
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).To(HaveKeyWithValue("type", "alerting-rules"))
+
+			// Invalid label values are rejected.
+			pr.Labels["type"] = "not a label value"
+			_, err = controllers.RenderTypeLabel(tmpl, &pr)
+			Expect(err).To(HaveOccurred())
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Config reload", func() {
+		reloadNs := "reload"
+		objKey := newObjKey(reloadNs, "reload.alerts")
+		prObjKey := newObjKey(reloadNs, controllers.AbsencePrometheusRuleName("openstack-reload"))
+
+		It("should apply changes of the config file on the next reconcile", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+			Expect(os.WriteFile(path, []byte("default-severity: warning\n"), 0o600)).To(Succeed())
+			fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+			defaultSeverity := fs.String("default-severity", "info", "")
+			fs.Duration("min-for", 0, "")
+			Expect(fs.Parse(nil)).To(Succeed())
+			cfgFile, err := config.Open(fs, path)
+			Expect(err).ToNot(HaveOccurred())
+
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "reload",
+				ParseOpts:  controllers.ParseOpts{DefaultSeverity: *defaultSeverity},
+			}
+			reloaded := make(chan struct{}, 1)
+			watchCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				defer GinkgoRecover()
+				err := cfgFile.Watch(watchCtx, logger, func(values map[string]string) {
+					minFor, err := time.ParseDuration(values["min-for"])
+					Expect(err).ToNot(HaveOccurred())
+					r.Reload(controllers.ReloadableOpts{MinFor: minFor, DefaultSeverity: values["default-severity"]})
+					select {
+					case reloaded <- struct{}{}:
+					default:
+					}
+				})
+				Expect(err).ToNot(HaveOccurred())
+			}()
+
+			Expect(ensureNamespace(ctx, reloadNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "reload.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			rule := aPR.Spec.Groups[0].Rules[0]
+			Expect(rule.Labels).To(HaveKeyWithValue("severity", "warning"))
+			Expect(*rule.For).To(Equal(monitoringv1.Duration("10m")))
+
+			// The watch is set up asynchronously, therefore the file is changed until the
+			// change is noticed. The comment makes each write a change.
+			n := 0
+			Eventually(func() bool {
+				n++
+				content := fmt.Sprintf("# %d\ndefault-severity: critical\nmin-for: 1h\n", n)
+				Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+				select {
+				case <-reloaded:
+					return true
+				case <-time.After(500 * time.Millisecond):
+					return false
+				}
+			}).WithTimeout(10 * time.Second).Should(BeTrue())
+
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			rule = aPR.Spec.Groups[0].Rules[0]
+			Expect(rule.Labels).To(HaveKeyWithValue("severity", "critical"))
+			Expect(*rule.For).To(Equal(monitoringv1.Duration("1h")))
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Hand-written absence alert rules", func() {
+		handNs := "handwritten"
+		objKey := newObjKey(handNs, "handwritten.alerts")
+		handObjKey := newObjKey(handNs, "handwritten-absent.alerts")
+		prObjKey := newObjKey(handNs, controllers.AbsencePrometheusRuleName("openstack-handwritten"))
+
+		It("should not generate duplicates of hand-written absence alert rules", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:                 k8sClient,
+				Scheme:                 k8sClient.Scheme(),
+				Log:                    logger,
+				KeepLabel:              keepLabel,
+				InstanceID:             "handwritten",
+				SkipHandWrittenAbsence: true,
+				ParseOpts:              controllers.ParseOpts{PreserveMatchers: true},
+			}
+
+			Expect(ensureNamespace(ctx, handNs)).To(Succeed())
+			handPR := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      handObjKey.Name,
+					Namespace: handObjKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name: "handwritten-absent.alerts",
+						Rules: []monitoringv1.Rule{
+							{Alert: "FooBarMissing", Expr: intstr.FromString(`absent(foo_bar{job="a", region="eu"})`)},
+							{Alert: "BarFooMissing", Expr: intstr.FromString(`absent(bar_foo{job="c"})`)},
+						},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &handPR)).To(Succeed())
+
+			fooBar := createMockRule("foo_bar")
+			fooBar.Expr = intstr.FromString(`foo_bar{region="eu", job="a"} > 0`)
+			barFoo := createMockRule("bar_foo")
+			barFoo.Expr = intstr.FromString(`bar_foo{job="b"} > 0`)
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "handwritten.alerts",
+						Rules: []monitoringv1.Rule{fooBar, barFoo},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+
+			// The hand-written absence alert rule for foo_bar uses the same label matchers
+			// in a different order, the one for bar_foo uses different ones.
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+			var exprs []string
+			for _, rule := range aPR.Spec.Groups[0].Rules {
+				exprs = append(exprs, rule.Expr.String())
+			}
+			Expect(exprs).To(Equal([]string{`absent(bar_foo{job="b"})`}))
+
+			// Delete the PromRules so that they don't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			Expect(deletePromRule(handObjKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Frozen PrometheusRule", func() {
+		freezeNs := "freeze"
+		objKey := newObjKey(freezeNs, "freeze.alerts")
+		prObjKey := newObjKey(freezeNs, controllers.AbsencePrometheusRuleName("openstack-freeze"))
+
+		It("should keep the absence alert rules of frozen PrometheusRules as is", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "freeze",
+			}
+
+			Expect(ensureNamespace(ctx, freezeNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "freeze.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			expected, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(expected.Spec.Groups).To(HaveLen(1))
+
+			// Changes to a frozen PrometheusRule are not applied to its absence alert rules.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Labels["absent-metrics-operator/disable"] = "freeze"
+			pr.Spec.Groups[0].Rules = append(pr.Spec.Groups[0].Rules, createMockRule("bar_foo"))
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			actual, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actual.Spec).To(Equal(expected.Spec))
+
+			// Disabling the operator removes them.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Labels["absent-metrics-operator/disable"] = "true"
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Retain existing labels", func() {
+		retainNs := "retainlabels"
+		objKey := newObjKey(retainNs, "retainlabels.alerts")
+		prObjKey := newObjKey(retainNs, controllers.AbsencePrometheusRuleName("openstack-retainlabels"))
+
+		It("should keep the labels of the AbsencePrometheusRule if they can not be inferred", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:               k8sClient,
+				Scheme:               k8sClient.Scheme(),
+				Log:                  logger,
+				KeepLabel:            keepLabel,
+				InstanceID:           "retainlabels",
+				RetainExistingLabels: true,
+			}
+
+			Expect(ensureNamespace(ctx, retainNs)).To(Succeed())
+			rule := createMockRule("foo_bar")
+			rule.Labels["support_group"] = "containers"
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "retainlabels.alerts",
+						Rules: []monitoringv1.Rule{rule},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).To(HaveKeyWithValue("ccloud/support-group", "containers"))
+			Expect(aPR.Labels).To(HaveKeyWithValue("ccloud/service", "service"))
+
+			// The labels of the alert rules use templating now, therefore no defaults can
+			// be inferred for them.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			for k := range pr.Spec.Groups[0].Rules[0].Labels {
+				pr.Spec.Groups[0].Rules[0].Labels[k] = "{{ $labels." + k + " }}"
+			}
+			pr.Spec.Groups[0].Rules = append(pr.Spec.Groups[0].Rules, createMockRule("bar_foo"))
+			pr.Spec.Groups[0].Rules[1].Labels = nil
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).To(HaveKeyWithValue("ccloud/support-group", "containers"))
+			Expect(aPR.Labels).To(HaveKeyWithValue("ccloud/service", "service"))
+			Expect(aPR.Labels).To(HaveKeyWithValue("tier", "tier"))
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+			Expect(aPR.Spec.Groups[0].Rules).To(HaveLen(2))
+			for _, ar := range aPR.Spec.Groups[0].Rules {
+				Expect(ar.Labels).To(HaveKeyWithValue("support_group", "containers"))
+				Expect(ar.Labels).To(HaveKeyWithValue("service", "service"))
+			}
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Watchdog alert rule", func() {
+		watchdogNs := "watchdog"
+		objKey := newObjKey(watchdogNs, "watchdog.alerts")
+		prObjKey := newObjKey(watchdogNs, controllers.AbsencePrometheusRuleName("openstack-watchdog"))
+
+		It("should add a watchdog alert rule to the AbsencePrometheusRule", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:            k8sClient,
+				Scheme:            k8sClient.Scheme(),
+				Log:               logger,
+				KeepLabel:         keepLabel,
+				InstanceID:        "watchdog",
+				WatchdogAlertName: "AbsenceWatchdog",
+			}
+
+			Expect(ensureNamespace(ctx, watchdogNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "watchdog.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+
+			expectWatchdog := func() {
+				aPR, err := getPromRule(prObjKey)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(aPR.Spec.Groups).To(HaveLen(2))
+				Expect(aPR.Spec.Groups[0].Name).To(Equal("watchdog.alerts/watchdog.alerts"))
+				watchdog := aPR.Spec.Groups[1]
+				Expect(watchdog.Name).To(Equal("absent-metrics-operator-watchdog"))
+				Expect(watchdog.Rules).To(HaveLen(1))
+				Expect(watchdog.Rules[0].Alert).To(Equal("AbsenceWatchdog"))
+				Expect(watchdog.Rules[0].Expr.String()).To(Equal("vector(1)"))
+				Expect(watchdog.Rules[0].Labels).To(HaveKeyWithValue("severity", "none"))
+				Expect(watchdog.Rules[0].Labels).To(HaveKeyWithValue("service", "service"))
+			}
+			expectWatchdog()
+
+			// Updates of the AbsencePrometheusRule do not duplicate the watchdog rule group.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Spec.Groups[0].Rules = append(pr.Spec.Groups[0].Rules, createMockRule("bar_foo"))
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			expectWatchdog()
+
+			// Renaming or disabling the watchdog alert rule updates existing
+			// AbsencePrometheusRules even if their absence rule groups are unchanged.
+			r.WatchdogAlertName = "AbsenceHeartbeat"
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(2))
+			Expect(aPR.Spec.Groups[1].Rules[0].Alert).To(Equal("AbsenceHeartbeat"))
+			Expect(aPR.Annotations).To(HaveKeyWithValue("absent-metrics-operator/watchdog", "AbsenceHeartbeat"))
+
+			r.WatchdogAlertName = ""
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+			Expect(aPR.Spec.Groups[0].Name).To(Equal("watchdog.alerts/watchdog.alerts"))
+			Expect(aPR.Annotations).ToNot(HaveKey("absent-metrics-operator/watchdog"))
+
+			r.WatchdogAlertName = "AbsenceWatchdog"
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			expectWatchdog()
+
+			// The watchdog rule group does not keep the AbsencePrometheusRule alive.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Missing metrics recording rule", func() {
+		missingNs := "missingmetrics"
+		objKey := newObjKey(missingNs, "missingmetrics.alerts")
+		prObjKey := newObjKey(missingNs, controllers.AbsencePrometheusRuleName("openstack-missingmetrics"))
+
+		It("should add a single recording rule to the AbsencePrometheusRule", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:               k8sClient,
+				Scheme:               k8sClient.Scheme(),
+				Log:                  logger,
+				KeepLabel:            keepLabel,
+				InstanceID:           "missingmetrics",
+				RecordMissingMetrics: true,
+			}
+
+			Expect(ensureNamespace(ctx, missingNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{
+						{Name: "first.alerts", Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+						{Name: "second.alerts", Rules: []monitoringv1.Rule{createMockRule("bar_foo")}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+
+			expectRecord := func(expr string) {
+				aPR, err := getPromRule(prObjKey)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(aPR.Spec.Groups).To(HaveLen(3))
+				g := aPR.Spec.Groups[2]
+				Expect(g.Name).To(Equal("absent-metrics-operator-missing-metrics"))
+				Expect(g.Rules).To(HaveLen(1))
+				Expect(g.Rules[0].Record).To(Equal("absence:missing_metrics:count"))
+				Expect(g.Rules[0].Expr.String()).To(Equal(expr))
+			}
+			expectRecord("sum(absent(bar_foo) or vector(0)) + sum(absent(foo_bar) or vector(0))")
+
+			// The recording rule follows the absence alert rules of all rule groups.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Spec.Groups[1].Rules = append(pr.Spec.Groups[1].Rules, createMockRule("baz_foo"))
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			expectRecord("sum(absent(bar_foo) or vector(0)) + sum(absent(baz_foo) or vector(0)) + " +
+				"sum(absent(foo_bar) or vector(0))")
+
+			// Disabling the recording rule updates existing AbsencePrometheusRules even if
+			// their absence rule groups are unchanged.
+			r.RecordMissingMetrics = false
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(2))
+			Expect(aPR.Annotations).ToNot(HaveKey("absent-metrics-operator/missing-metrics"))
+
+			r.RecordMissingMetrics = true
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			expectRecord("sum(absent(bar_foo) or vector(0)) + sum(absent(baz_foo) or vector(0)) + " +
+				"sum(absent(foo_bar) or vector(0))")
+		})
+	})
+
+	Describe("Multiple Prometheus servers", func() {
+		multiServerNs := "multiserver"
+		objKey := newObjKey(multiServerNs, "multiserver.alerts")
+		openstackObjKey := newObjKey(multiServerNs, controllers.AbsencePrometheusRuleName("openstack-multiserver"))
+		kubernetesObjKey := newObjKey(multiServerNs, controllers.AbsencePrometheusRuleName("kubernetes-multiserver"))
+
+		It("should generate AbsencePrometheusRules for each Prometheus server", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:                    k8sClient,
+				Scheme:                    k8sClient.Scheme(),
+				Log:                       logger,
+				KeepLabel:                 keepLabel,
+				InstanceID:                "multiserver",
+				PrometheusServerDelimiter: ".",
+			}
+
+			Expect(ensureNamespace(ctx, multiServerNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack.kubernetes"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "multiserver.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			before := counterValue("absent_metrics_operator_rules_processed_total", multiServerNs)
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			// The alert rule is processed once even though there are two Prometheus servers.
+			Expect(counterValue("absent_metrics_operator_rules_processed_total", multiServerNs) - before).To(Equal(1.0))
+
+			for promServer, key := range map[string]types.NamespacedName{
+				"openstack":  openstackObjKey,
+				"kubernetes": kubernetesObjKey,
+			} {
+				aPR, err := getPromRule(key)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(aPR.Labels).To(HaveKeyWithValue("prometheus", promServer))
+				Expect(aPR.Spec.Groups).To(HaveLen(1))
+				Expect(aPR.Spec.Groups[0].Name).To(Equal("multiserver.alerts/multiserver.alerts"))
+				Expect(aPR.Spec.Groups[0].Rules).To(HaveLen(1))
+				Expect(aPR.Spec.Groups[0].Rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+			}
+
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			for _, key := range []types.NamespacedName{openstackObjKey, kubernetesObjKey} {
+				_, err = getPromRule(key)
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			}
+		})
+	})
+
+	Describe("Disabled PrometheusRules metric", func() {
+		disabledNs := "disabledrules"
+		objKey := newObjKey(disabledNs, "disabled.alerts")
+		metricName := "absent_metrics_operator_disabled_rules"
+
+		It("should count the PrometheusRules for which the operator is disabled", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "disabledrules",
+			}
+			setDisableLabel := func(value string) {
+				pr, err := getPromRule(objKey)
+				Expect(err).ToNot(HaveOccurred())
+				if value == "" {
+					delete(pr.Labels, "absent-metrics-operator/disable")
+				} else {
+					pr.Labels["absent-metrics-operator/disable"] = value
+				}
+				Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+				waitForControllerToProcess()
+				_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			Expect(ensureNamespace(ctx, disabledNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels: map[string]string{
+						"prometheus":                      "openstack",
+						"absent-metrics-operator/disable": "true",
+					},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "disabled.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gaugeValue(reg, metricName, disabledNs)).To(Equal(1.0))
+
+			setDisableLabel("")
+			Expect(gaugeValue(reg, metricName, disabledNs)).To(Equal(0.0))
+
+			setDisableLabel("true")
+			Expect(gaugeValue(reg, metricName, disabledNs)).To(Equal(1.0))
+
+			// Frozen PrometheusRules are not counted.
+			setDisableLabel("freeze")
+			Expect(gaugeValue(reg, metricName, disabledNs)).To(Equal(0.0))
+
+			setDisableLabel("true")
+			Expect(gaugeValue(reg, metricName, disabledNs)).To(Equal(1.0))
+
+			// The count is reduced when a disabled PrometheusRule is deleted.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gaugeValue(reg, metricName, disabledNs)).To(Equal(0.0))
+		})
+	})
+
+	Describe("Clean up with multiple operator instances", func() {
+		cleanupNs := "cleanup"
+		objKey := newObjKey(cleanupNs, "cleanup.alerts")
+		prObjKey := newObjKey(cleanupNs, controllers.AbsencePrometheusRuleName("openstack-other"))
+
+		It("should ignore the AbsencePrometheusRules of other instances", func() {
+			// Both instances use a different label than the operator instance of the test
+			// suite so that it does not interfere.
+			managedByLabel := "example.com/managed-by"
+			r := &controllers.PrometheusRuleReconciler{
+				Client:         k8sClient,
+				Scheme:         k8sClient.Scheme(),
+				Log:            logger,
+				KeepLabel:      keepLabel,
+				ManagedByLabel: managedByLabel,
+			}
+			other := &controllers.PrometheusRuleReconciler{
+				Client:         k8sClient,
+				Scheme:         k8sClient.Scheme(),
+				Log:            logger,
+				KeepLabel:      keepLabel,
+				ManagedByLabel: managedByLabel,
+				InstanceID:     "other",
+			}
+
+			Expect(ensureNamespace(ctx, cleanupNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "cleanup.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err := other.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			// The clean up of the deleted PrometheusRule has to list the
+			// AbsencePrometheusRules in the namespace since the Prometheus server is
+			// unknown. The AbsencePrometheusRule of the other instance must not be listed.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			aPR, err := getPromRule(prObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Spec.Groups).To(HaveLen(1))
+
+			// The other instance cleans up its own AbsencePrometheusRule.
+			_, err = other.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(prObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("Split AbsencePrometheusRules", func() {
+		splitNs := "split"
+		objKey := newObjKey(splitNs, "split.alerts")
+		prObjKey := newObjKey(splitNs, controllers.AbsencePrometheusRuleName("openstack-split"))
+		part1ObjKey := newObjKey(splitNs, prObjKey.Name+"-1")
+		part2ObjKey := newObjKey(splitNs, prObjKey.Name+"-2")
+
+		It("should split large AbsencePrometheusRules and clean up all parts", func() {
+			r := &controllers.PrometheusRuleReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Log:        logger,
+				KeepLabel:  keepLabel,
+				InstanceID: "split",
+				// Every rule group exceeds this size so each one ends up in its own part.
+				MaxAbsencePrometheusRuleSize: 1,
+			}
+
+			Expect(ensureNamespace(ctx, splitNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{
+						{Name: "foo.alerts", Rules: []monitoringv1.Rule{createMockRule("foo_bar")}},
+						{Name: "bar.alerts", Rules: []monitoringv1.Rule{createMockRule("bar_bar")}},
+						{Name: "baz.alerts", Rules: []monitoringv1.Rule{createMockRule("baz_bar")}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			var groups []string
+			for _, key := range []types.NamespacedName{prObjKey, part1ObjKey, part2ObjKey} {
+				aPR, err := getPromRule(key)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(aPR.Labels).To(HaveKeyWithValue("absent-metrics-operator/managed-by", "split"))
+				Expect(aPR.Spec.Groups).To(HaveLen(1))
+				groups = append(groups, aPR.Spec.Groups[0].Name)
+			}
+			Expect(groups).To(ConsistOf(
+				"split.alerts/bar.alerts",
+				"split.alerts/baz.alerts",
+				"split.alerts/foo.alerts",
+			))
+
+			// Reconciling again does not change the parts.
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(part2ObjKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			// Parts that are no longer needed are deleted.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Spec.Groups = pr.Spec.Groups[:2]
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(part1ObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getPromRule(part2ObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			// All parts are cleaned up together.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			for _, key := range []types.NamespacedName{prObjKey, part1ObjKey, part2ObjKey} {
+				_, err = getPromRule(key)
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			}
+			waitForControllerToProcess()
+		})
+	})
+
+	Describe("RBAC errors", func() {
+		rbacNs := "rbac"
+		objKey := newObjKey(rbacNs, "rbac.alerts")
+
+		It("should count Forbidden errors and log a hint once per namespace and resource", func() {
+			var logs []string
+			r := &controllers.PrometheusRuleReconciler{
+				Client: &forbiddenListClient{Client: k8sClient},
+				Scheme: k8sClient.Scheme(),
+				Log: funcr.New(func(prefix, args string) {
+					logs = append(logs, args)
+				}, funcr.Options{}),
+				KeepLabel:  keepLabel,
+				InstanceID: "rbac",
+			}
+
+			// The PrometheusRule does not exist therefore the operator lists the
+			// AbsencePrometheusRules in its namespace to clean up orphaned absence alert
+			// rules, which is forbidden.
+			for i := 0; i < 2; i++ {
+				_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(counterValue("absent_metrics_operator_rbac_errors_total", rbacNs)).To(Equal(float64(2)))
+
+			hints := func(resource string) []string {
+				var result []string
+				for _, l := range logs {
+					if strings.Contains(l, "missing RBAC permissions for "+resource+" in namespace") {
+						result = append(result, l)
+					}
+				}
+				return result
+			}
+			prHints := hints("prometheusrules.monitoring.coreos.com")
+			Expect(prHints).To(HaveLen(1))
+			Expect(prHints[0]).To(ContainSubstring(`"namespace"="rbac"`))
+			Expect(prHints[0]).To(ContainSubstring(`"resource"="prometheusrules.monitoring.coreos.com"`))
+			Expect(prHints[0]).To(ContainSubstring("forbidden"))
+
+			// The hint names the resource of the request, e.g. the ConfigMaps that hold
+			// the absence alert rules with '-output=configmap', and is logged once for
+			// each resource.
+			r.Output = controllers.OutputConfigMap
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: objKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hints("prometheusrules.monitoring.coreos.com")).To(HaveLen(1))
+			cmHints := hints("configmaps")
+			Expect(cmHints).To(HaveLen(1))
+			Expect(cmHints[0]).To(ContainSubstring(`"resource"="configmaps"`))
+		})
+	})
+
+	Describe("Label-only changes", func() {
+		relabelNs := "relabel"
+		objKey := newObjKey(relabelNs, "relabel.alerts")
+		osPRObjKey := newObjKey(relabelNs, osAbsentPRName)
+		k8sPRObjKey := newObjKey(relabelNs, k8sAbsentPRName)
+
+		It("should update the labels and relocate the absence alert rules", func() {
+			Expect(ensureNamespace(ctx, relabelNs)).To(Succeed())
+			pr := monitoringv1.PrometheusRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      objKey.Name,
+					Namespace: objKey.Namespace,
+					Labels:    map[string]string{"prometheus": "openstack", "tier": "os", "service": "swift"},
+				},
+				Spec: monitoringv1.PrometheusRuleSpec{
+					Groups: []monitoringv1.RuleGroup{{
+						Name:  "relabel.alerts",
+						Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			aPR, err := getPromRule(osPRObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).To(HaveKeyWithValue("service", "swift"))
+			ruleGroups := aPR.Spec.Groups
+			Expect(ruleGroups).To(HaveLen(1))
+
+			// A change of the service label updates the labels of the AbsencePrometheusRule.
+			// The absence alert rules have their own labels and stay the same.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Labels["service"] = "limes"
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			aPR, err = getPromRule(osPRObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).To(HaveKeyWithValue("service", "limes"))
+			Expect(aPR.Spec.Groups).To(Equal(ruleGroups))
+
+			// A change of the Prometheus server moves the absence alert rules to the
+			// AbsencePrometheusRule of the new Prometheus server.
+			pr, err = getPromRule(objKey)
+			Expect(err).ToNot(HaveOccurred())
+			pr.Labels["prometheus"] = "kubernetes"
+			Expect(k8sClient.Update(ctx, &pr)).To(Succeed())
+			waitForControllerToProcess()
+			aPR, err = getPromRule(k8sPRObjKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).To(HaveKeyWithValue("prometheus", "kubernetes"))
+			Expect(aPR.Spec.Groups).To(Equal(ruleGroups))
+			_, err = getPromRule(osPRObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			// Delete the PromRule so that it doesn't affect the other tests.
+			Expect(deletePromRule(objKey)).To(Succeed())
+			waitForControllerToProcess()
+			_, err = getPromRule(k8sPRObjKey)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
 	Describe("Orphaned gauge metrics", func() {
 		It("should be cleaned up", func() {
 			method := http.MethodGet
@@ -338,6 +2923,31 @@ var _ = Describe("Controller", func() {
 // Wait for controller to resync and complete its processing.
 var waitForControllerToProcess = func() { time.Sleep(500 * time.Millisecond) }
 
+// listCountingClient is a client.Client that counts its List requests.
+type listCountingClient struct {
+	client.Client
+	lists int
+}
+
+func (c *listCountingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	c.lists++
+	return c.Client.List(ctx, list, opts...)
+}
+
+// forbiddenListClient is a client.Client whose List requests fail as if the operator
+// was missing the RBAC permissions for them.
+type forbiddenListClient struct {
+	client.Client
+}
+
+func (c *forbiddenListClient) List(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+	gr := schema.GroupResource{Group: monitoringv1.SchemeGroupVersion.Group, Resource: monitoringv1.PrometheusRuleName}
+	if _, ok := list.(*corev1.ConfigMapList); ok {
+		gr = schema.GroupResource{Resource: "configmaps"}
+	}
+	return apierrors.NewForbidden(gr, "", errors.New("missing RBAC permissions"))
+}
+
 func newObjKey(namespace, name string) client.ObjectKey {
 	return client.ObjectKey{
 		Namespace: namespace,
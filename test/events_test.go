@@ -0,0 +1,47 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/sapcc/absent-metrics-operator/controllers"
+)
+
+var _ = Describe("RateLimitedRecorder", func() {
+	It("should drop identical events within the interval", func() {
+		fake := record.NewFakeRecorder(100)
+		recorder := controllers.NewRateLimitedRecorder(fake, time.Hour)
+
+		pr := &monitoringv1.PrometheusRule{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"}}
+		otherPR := &monitoringv1.PrometheusRule{ObjectMeta: metav1.ObjectMeta{Name: "baz", Namespace: "bar"}}
+		err := errors.New("something went wrong")
+		for i := 0; i < 10; i++ {
+			recorder.Event(pr, corev1.EventTypeWarning, "ReconcileFailed", err.Error())
+			recorder.Eventf(otherPR, corev1.EventTypeWarning, "ReconcileFailed", "%s", err.Error())
+		}
+		recorder.Event(pr, corev1.EventTypeWarning, "ReconcileFailed", "something else went wrong")
+
+		Expect(fake.Events).To(HaveLen(3))
+	})
+})
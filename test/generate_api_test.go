@@ -0,0 +1,93 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/sapcc/absent-metrics-operator/controllers"
+)
+
+var _ = Describe("GenerateHandler", func() {
+	r := &controllers.PrometheusRuleReconciler{
+		Log:       logger,
+		KeepLabel: keepLabel,
+	}
+
+	post := func(body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/generate", bytes.NewReader(body))
+		r.GenerateHandler().ServeHTTP(w, req)
+		return w
+	}
+
+	It("should respond with the generated AbsencePrometheusRule", func() {
+		pr := monitoringv1.PrometheusRule{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "openstack-api.alerts",
+				Namespace: "api",
+				Labels:    map[string]string{"prometheus": "openstack"},
+			},
+			Spec: monitoringv1.PrometheusRuleSpec{
+				Groups: []monitoringv1.RuleGroup{{
+					Name:  "api.alerts",
+					Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+				}},
+			},
+		}
+		body, err := json.Marshal(pr)
+		Expect(err).ToNot(HaveOccurred())
+
+		w := post(body)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/json"))
+		var aPR monitoringv1.PrometheusRule
+		Expect(json.Unmarshal(w.Body.Bytes(), &aPR)).To(Succeed())
+		Expect(aPR.Name).To(Equal("openstack-absent-metric-alert-rules"))
+		Expect(aPR.Namespace).To(Equal("api"))
+		Expect(aPR.Labels).To(Equal(map[string]string{
+			"absent-metrics-operator/managed-by": "true",
+			"prometheus":                         "openstack",
+			"type":                               "alerting-rules",
+			"tier":                               "tier",
+			"service":                            "service",
+			"ccloud/service":                     "service",
+		}))
+		Expect(aPR.Spec.Groups).To(HaveLen(1))
+		Expect(aPR.Spec.Groups[0].Name).To(Equal("openstack-api.alerts/api.alerts"))
+		Expect(aPR.Spec.Groups[0].Rules).To(HaveLen(1))
+		Expect(aPR.Spec.Groups[0].Rules[0].Alert).To(Equal("AbsentTierServiceFooBar"))
+		Expect(aPR.Spec.Groups[0].Rules[0].Expr.String()).To(Equal("absent(foo_bar)"))
+	})
+
+	It("should reject invalid requests", func() {
+		Expect(post([]byte("{")).Code).To(Equal(http.StatusBadRequest))
+
+		// The PrometheusRule does not have a 'prometheus' label.
+		Expect(post([]byte(`{"metadata":{"name":"foo"}}`)).Code).To(Equal(http.StatusUnprocessableEntity))
+
+		w := httptest.NewRecorder()
+		r.GenerateHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/generate", nil))
+		Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,179 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/sapcc/absent-metrics-operator/internal/grafana"
+)
+
+var _ = Describe("Grafana export", func() {
+	It("should convert absence alert rules into Grafana-managed alert rules", func() {
+		duration := monitoringv1.Duration("10m")
+		aPR := &monitoringv1.PrometheusRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "openstack-absent-metric-alert-rules", Namespace: "swift"},
+			Spec: monitoringv1.PrometheusRuleSpec{
+				Groups: []monitoringv1.RuleGroup{{
+					Name: "openstack-swift.alerts/swift.alerts",
+					Rules: []monitoringv1.Rule{{
+						Alert:       "AbsentOsSwiftUpTotal",
+						Expr:        intstr.FromString("absent(swift_up_total)"),
+						For:         &duration,
+						Labels:      map[string]string{"tier": "os", "service": "swift", "severity": "info"},
+						Annotations: map[string]string{"summary": "missing swift_up_total"},
+					}},
+				}},
+			},
+		}
+		groups := grafana.ConvertRuleGroups(aPR, grafana.Opts{
+			DatasourceUID:   "prometheus-openstack",
+			Folder:          "absence",
+			OrgID:           1,
+			DefaultInterval: "1m",
+		})
+		b, err := json.Marshal(groups)
+		Expect(err).ToNot(HaveOccurred())
+
+		var actual []map[string]any
+		Expect(json.Unmarshal(b, &actual)).To(Succeed())
+		Expect(actual).To(HaveLen(1))
+		Expect(actual[0]).To(HaveKeyWithValue("orgId", BeEquivalentTo(1)))
+		Expect(actual[0]).To(HaveKeyWithValue("name", "openstack-swift.alerts/swift.alerts"))
+		Expect(actual[0]).To(HaveKeyWithValue("folder", "absence"))
+		Expect(actual[0]).To(HaveKeyWithValue("interval", "1m"))
+
+		rules, ok := actual[0]["rules"].([]any)
+		Expect(ok).To(BeTrue())
+		Expect(rules).To(HaveLen(1))
+		rule, ok := rules[0].(map[string]any)
+		Expect(ok).To(BeTrue())
+		Expect(rule).To(HaveKeyWithValue("uid", HaveLen(16)))
+		Expect(rule).To(HaveKeyWithValue("title", "AbsentOsSwiftUpTotal"))
+		Expect(rule).To(HaveKeyWithValue("condition", "B"))
+		Expect(rule).To(HaveKeyWithValue("noDataState", "OK"))
+		Expect(rule).To(HaveKeyWithValue("execErrState", "Error"))
+		Expect(rule).To(HaveKeyWithValue("for", "10m"))
+		Expect(rule).To(HaveKeyWithValue("labels", HaveKeyWithValue("service", "swift")))
+		Expect(rule).To(HaveKeyWithValue("annotations", HaveKeyWithValue("summary", "missing swift_up_total")))
+
+		data, ok := rule["data"].([]any)
+		Expect(ok).To(BeTrue())
+		Expect(data).To(HaveLen(2))
+		query, ok := data[0].(map[string]any)
+		Expect(ok).To(BeTrue())
+		Expect(query).To(HaveKeyWithValue("refId", "A"))
+		Expect(query).To(HaveKeyWithValue("datasourceUid", "prometheus-openstack"))
+		Expect(query).To(HaveKeyWithValue("model", And(
+			HaveKeyWithValue("expr", "absent(swift_up_total)"),
+			HaveKeyWithValue("instant", true),
+		)))
+		condition, ok := data[1].(map[string]any)
+		Expect(ok).To(BeTrue())
+		Expect(condition).To(HaveKeyWithValue("refId", "B"))
+		Expect(condition).To(HaveKeyWithValue("datasourceUid", "__expr__"))
+		Expect(condition).To(HaveKeyWithValue("model", And(
+			HaveKeyWithValue("type", "threshold"),
+			HaveKeyWithValue("expression", "A"),
+		)))
+	})
+
+	It("should use different UIDs for absence alert rules with the same name", func() {
+		firstStage := monitoringv1.Duration("10m")
+		secondStage := monitoringv1.Duration("1h")
+		rule := func(expr string, d *monitoringv1.Duration, severity string) monitoringv1.Rule {
+			return monitoringv1.Rule{
+				Alert:  "AbsentOsSwiftUpTotal",
+				Expr:   intstr.FromString(expr),
+				For:    d,
+				Labels: map[string]string{"severity": severity},
+			}
+		}
+		aPR := &monitoringv1.PrometheusRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "openstack-absent-metric-alert-rules", Namespace: "swift"},
+			Spec: monitoringv1.PrometheusRuleSpec{
+				Groups: []monitoringv1.RuleGroup{{
+					Name: "openstack-swift.alerts/swift.alerts",
+					Rules: []monitoringv1.Rule{
+						// An escalated absence alert rule.
+						rule("absent(swift_up_total)", &firstStage, "info"),
+						rule("absent(swift_up_total)", &secondStage, "critical"),
+						// The same metric with a different label matcher.
+						rule(`absent(swift_up_total{job="proxy"})`, &firstStage, "info"),
+					},
+				}},
+			},
+		}
+		groups := grafana.ConvertRuleGroups(aPR, grafana.Opts{DatasourceUID: "prometheus-openstack"})
+		Expect(groups).To(HaveLen(1))
+		Expect(groups[0].Rules).To(HaveLen(3))
+		uids := make(map[string]bool)
+		for _, r := range groups[0].Rules {
+			uids[r.UID] = true
+		}
+		Expect(uids).To(HaveLen(3))
+
+		// The UIDs are stable.
+		again := grafana.ConvertRuleGroups(aPR, grafana.Opts{DatasourceUID: "prometheus-openstack"})
+		Expect(again[0].Rules[0].UID).To(Equal(groups[0].Rules[0].UID))
+	})
+
+	It("should write the alert rules of PrometheusRule files to a ConfigMap", func() {
+		output := filepath.Join(GinkgoT().TempDir(), "configmap.yaml")
+		var out strings.Builder
+		Expect(grafana.Main([]string{
+			"-datasource-uid", "prometheus-openstack",
+			"-configmap", "monitoring/absence-alert-rules",
+			"-output", output,
+			filepath.Join("fixtures", "ruletest", "rules.yaml"),
+		}, &out)).To(Equal(0))
+		Expect(out.String()).To(BeEmpty())
+
+		b, err := os.ReadFile(output)
+		Expect(err).ToNot(HaveOccurred())
+		var cm corev1.ConfigMap
+		Expect(yaml.Unmarshal(b, &cm)).To(Succeed())
+		Expect(cm.Namespace).To(Equal("monitoring"))
+		Expect(cm.Name).To(Equal("absence-alert-rules"))
+		Expect(cm.Data).To(HaveKey("absence-alert-rules.json"))
+
+		var file grafana.File
+		Expect(json.Unmarshal([]byte(cm.Data["absence-alert-rules.json"]), &file)).To(Succeed())
+		Expect(file.APIVersion).To(Equal(1))
+		Expect(file.Groups).To(HaveLen(1))
+		titles := make([]string, 0, len(file.Groups[0].Rules))
+		for _, r := range file.Groups[0].Rules {
+			titles = append(titles, r.Title)
+		}
+		Expect(titles).To(ContainElements("AbsentOsSwiftUpTotal", "AbsentOsSwiftDiskFreeBytes"))
+	})
+
+	It("should require a datasource UID", func() {
+		var out strings.Builder
+		Expect(grafana.Main([]string{filepath.Join("fixtures", "ruletest", "rules.yaml")}, &out)).To(Equal(2))
+		Expect(out.String()).To(ContainSubstring("usage:"))
+	})
+})
@@ -0,0 +1,147 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/sapcc/absent-metrics-operator/controllers"
+)
+
+var _ = Describe("ParseKeepLabel", func() {
+	It("should parse a comma-separated list of label names", func() {
+		keep, err := controllers.ParseKeepLabel("tier, service,support_group")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keep).To(Equal(controllers.KeepLabel{
+			controllers.LabelTier:         true,
+			controllers.LabelService:      true,
+			controllers.LabelSupportGroup: true,
+		}))
+	})
+
+	DescribeTable("should reject invalid label names",
+		func(in string) {
+			_, err := controllers.ParseKeepLabel(in)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("invalid label name", "tier,support-group"),
+		Entry("empty label name", "tier,,service"),
+		Entry("label set by the operator", "context"),
+	)
+
+	It("should accept labels that are not known to the operator", func() {
+		// Any label of the original alert rules can be retained, therefore label names
+		// are not checked against a list of known labels.
+		keep, err := controllers.ParseKeepLabel("tierr,customer")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keep).To(Equal(controllers.KeepLabel{"tierr": true, "customer": true}))
+	})
+})
+
+var _ = Describe("Label precedence", func() {
+	// The PrometheusRule has stale labels that differ from the labels of its alert rules.
+	pr := &monitoringv1.PrometheusRule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "openstack-stale.alerts",
+			Namespace: "stale",
+			Labels:    map[string]string{"prometheus": "openstack", "tier": "old-tier", "service": "old-service"},
+		},
+		Spec: monitoringv1.PrometheusRuleSpec{
+			Groups: []monitoringv1.RuleGroup{{
+				Name:  "stale.alerts",
+				Rules: []monitoringv1.Rule{createMockRule("foo_bar")},
+			}},
+		},
+	}
+
+	DescribeTable("should determine the defaults for the tier and service labels",
+		func(precedence, tier, service string) {
+			r := &controllers.PrometheusRuleReconciler{
+				Log:             logger,
+				KeepLabel:       keepLabel,
+				LabelPrecedence: precedence,
+			}
+			aPR, err := r.GenerateAbsencePrometheusRule(pr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aPR.Labels).To(HaveKeyWithValue("tier", tier))
+			Expect(aPR.Labels).To(HaveKeyWithValue("service", service))
+		},
+		Entry("by default from the labels of the PrometheusRule", "", "old-tier", "old-service"),
+		Entry("from the labels of the PrometheusRule", controllers.LabelPrecedenceResource, "old-tier", "old-service"),
+		Entry("from the alert rules", controllers.LabelPrecedenceInference, "tier", "service"),
+	)
+})
+
+var _ = Describe("Owner mapping", func() {
+	newPromRule := func(namespace string, rules ...monitoringv1.Rule) *monitoringv1.PrometheusRule {
+		return &monitoringv1.PrometheusRule{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "openstack-owner.alerts",
+				Namespace: namespace,
+				Labels:    map[string]string{"prometheus": "openstack"},
+			},
+			Spec: monitoringv1.PrometheusRuleSpec{
+				Groups: []monitoringv1.RuleGroup{{Name: "owner.alerts", Rules: rules}},
+			},
+		}
+	}
+
+	It("should use the team of the namespace for absence alert rules without an owner", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "owners.yaml")
+		Expect(os.WriteFile(path, []byte("swift: storage\nresmgmt: containers\n"), 0o600)).To(Succeed())
+		owners, err := controllers.NewOwnerMapping(path)
+		Expect(err).ToNot(HaveOccurred())
+		r := &controllers.PrometheusRuleReconciler{
+			Log:          logger,
+			KeepLabel:    keepLabel,
+			OwnerMapping: owners,
+			ParseOpts:    controllers.ParseOpts{OwnerLabel: controllers.LabelSupportGroup},
+		}
+
+		ownerOf := func(pr *monitoringv1.PrometheusRule) map[string]string {
+			aPR, err := r.GenerateAbsencePrometheusRule(pr)
+			Expect(err).ToNot(HaveOccurred())
+			return aPR.Spec.Groups[0].Rules[0].Labels
+		}
+		Expect(ownerOf(newPromRule("swift", createMockRule("foo_bar")))).To(HaveKeyWithValue("support_group", "storage"))
+		Expect(ownerOf(newPromRule("resmgmt", createMockRule("foo_bar")))).To(HaveKeyWithValue("support_group", "containers"))
+		Expect(ownerOf(newPromRule("unknown", createMockRule("foo_bar")))).ToNot(HaveKey("support_group"))
+
+		// The alert rule specifies its owner itself.
+		rule := createMockRule("foo_bar")
+		rule.Labels["support_group"] = "compute"
+		Expect(ownerOf(newPromRule("swift", rule))).To(HaveKeyWithValue("support_group", "compute"))
+
+		// Changes to the file are picked up without creating a new mapping.
+		Expect(os.WriteFile(path, []byte("swift: object-storage\n"), 0o600)).To(Succeed())
+		Expect(ownerOf(newPromRule("swift", createMockRule("foo_bar")))).To(HaveKeyWithValue("support_group", "object-storage"))
+		Expect(ownerOf(newPromRule("resmgmt", createMockRule("foo_bar")))).ToNot(HaveKey("support_group"))
+	})
+
+	It("should reject invalid files", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "owners.yaml")
+		Expect(os.WriteFile(path, []byte("- swift\n"), 0o600)).To(Succeed())
+		_, err := controllers.NewOwnerMapping(path)
+		Expect(err).To(HaveOccurred())
+		_, err = controllers.NewOwnerMapping(filepath.Join(GinkgoT().TempDir(), "missing.yaml"))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,74 @@
+// Copyright 2024 SAP SE
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/sapcc/absent-metrics-operator/controllers"
+	"github.com/sapcc/absent-metrics-operator/internal/ruletest"
+)
+
+// newRuleTestReconciler returns a reconciler with the default options of the operator.
+func newRuleTestReconciler() *controllers.PrometheusRuleReconciler {
+	return &controllers.PrometheusRuleReconciler{
+		Log: logr.Discard(),
+		KeepLabel: controllers.KeepLabel{
+			controllers.LabelSupportGroup: true,
+			controllers.LabelTier:         true,
+			controllers.LabelService:      true,
+		},
+	}
+}
+
+var _ = Describe("Rule tests", func() {
+	testFile := filepath.Join("fixtures", "ruletest", "tests.yaml")
+
+	It("should produce the expected output", func() {
+		var out strings.Builder
+		ok, err := ruletest.RunFile(newRuleTestReconciler(), testFile, &out)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+
+		expected, err := os.ReadFile(filepath.Join("fixtures", "ruletest", "output.golden"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out.String()).To(Equal(string(expected)))
+	})
+
+	It("should return a non-zero exit code if a test fails", func() {
+		var out strings.Builder
+		Expect(ruletest.Main(newRuleTestReconciler(), []string{testFile}, &out)).To(Equal(1))
+	})
+
+	It("should generate the absence alert rules with the options of the reconciler", func() {
+		// With a longer 'for' duration, AbsentOsSwiftUpTotal is still pending at 20m.
+		r := newRuleTestReconciler()
+		r.ParseOpts.MinFor = 30 * time.Minute
+
+		var out strings.Builder
+		ok, err := ruletest.RunFile(r, testFile, &out)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		Expect(out.String()).To(ContainSubstring("FAIL: swift_up_total is missing\n  eval_time: 20m\n" +
+			"    expected: [AbsentOsSwiftUpTotal]\n    got:      []\n"))
+	})
+})
@@ -62,6 +62,7 @@ var (
 		controllers.LabelTier:         true,
 		controllers.LabelService:      true,
 	}
+	fallbackPromServer = "fallback"
 )
 
 func TestController(t *testing.T) {
@@ -105,6 +106,8 @@ var _ = BeforeSuite(func() {
 		Scheme:    mgr.GetScheme(),
 		Log:       ctrl.Log.WithName("controller").WithName("prometheusrule"),
 		KeepLabel: keepLabel,
+
+		FallbackPrometheusServer: fallbackPromServer,
 	}).SetupWithManager(mgr)
 	Expect(err).NotTo(HaveOccurred())
 
@@ -181,16 +184,7 @@ func addMockPrometheusRules(ctx context.Context) error {
 			return err
 		}
 
-		// Create namespace if it doesn't exist already.
-		var ns corev1.Namespace
-		err = k8sClient.Get(ctx, client.ObjectKey{Name: pr.Namespace}, &ns)
-		if err != nil {
-			if !apierrors.IsNotFound(err) {
-				return err
-			}
-			ns.Name = pr.Namespace
-			err = k8sClient.Create(ctx, &ns)
-		}
+		err = ensureNamespace(ctx, pr.Namespace)
 		if err != nil {
 			return err
 		}
@@ -204,3 +198,17 @@ func addMockPrometheusRules(ctx context.Context) error {
 
 	return nil
 }
+
+// ensureNamespace creates a namespace if it doesn't exist already.
+func ensureNamespace(ctx context.Context, name string) error {
+	var ns corev1.Namespace
+	err := k8sClient.Get(ctx, client.ObjectKey{Name: name}, &ns)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		ns.Name = name
+		err = k8sClient.Create(ctx, &ns)
+	}
+	return err
+}